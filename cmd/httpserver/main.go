@@ -1,88 +1,154 @@
 package main
 
 import (
-	"crypto/sha256"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"chillhttp/internal/accesslog"
+	"chillhttp/internal/compress"
+	"chillhttp/internal/metrics"
+	"chillhttp/internal/negotiate"
+	"chillhttp/internal/proxy"
 	"chillhttp/internal/request"
 	"chillhttp/internal/response"
 	"chillhttp/internal/server"
+	"chillhttp/internal/sse"
+	"chillhttp/internal/tracing"
 
 	"github.com/pingcap/log"
+	"go.uber.org/zap"
 )
 
 const port = 42069
 
+// proxyTimeout bounds how long a proxied request may take upstream.
+const proxyTimeout = 30 * time.Second
+
 func HttpHandler(w *response.Writer, req *request.Request) {
 	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-		proxyHandler(w, req)
+		server.SetRoute(req, "/httpbin/*")
+		server.Timeout(proxyTimeout)(server.HandleErrors(proxyHandler))(w, req)
 		return
 	}
 
 	if req.RequestLine.RequestTarget == "/video" {
+		server.SetRoute(req, "/video")
 		videoHandler(w, req)
 		return
 	}
 
+	if req.RequestLine.RequestTarget == "/progress" {
+		server.SetRoute(req, "/progress")
+		progressHandler(w, req)
+		return
+	}
+
 	if req.RequestLine.RequestTarget == "/yourproblem" {
+		server.SetRoute(req, "/yourproblem")
 		badRequestHandler(w, req)
 		return
 	}
 
 	if req.RequestLine.RequestTarget == "/myproblem" {
+		server.SetRoute(req, "/myproblem")
 		serverErrorHandler(w, req)
 		return
 	}
 
+	server.SetRoute(req, "/*")
 	okHandler(w, req)
 }
 
-func proxyHandler(w *response.Writer, req *request.Request) {
+func proxyHandler(w *response.Writer, req *request.Request) error {
 	proxyPath := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin")
 	targetURL := "https://httpbin.org" + proxyPath
 
-	resp, err := http.Get(targetURL)
+	outReq, err := proxy.NewRequest(req, targetURL)
 	if err != nil {
-		serverErrorHandler(w, req)
-		return
+		return err
+	}
+	resp, err := http.DefaultClient.Do(outReq)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &server.HandlerError{Code: int(response.GatewayTimeout), Err: "httpbin.org took too long to answer."}
+	} else if err != nil {
+		return &server.HandlerError{Code: int(response.BadGateway), Err: "httpbin.org couldn't be reached."}
 	}
 	defer resp.Body.Close()
 
-	// Remove Content-Length, add Transfer-Encoding: chunked
-	headers := response.GetDefaultHeaders(0)
-	delete(headers, "Content-Length")
+	// Upstream framing is dropped; the body is re-sent chunked, with a
+	// Content-Digest of our own in place of upstream's
+	headers := proxy.ResponseHeaders(resp.Header)
+	headers.Delete("Content-Digest")
 	headers["Transfer-Encoding"] = "chunked"
-	headers["Trailer"] = "X-Content-Sha256, X-Content-Length"
+	headers["Trailer"] = "X-Content-Length"
+	w.DigestAlgorithms = []string{"sha-256", "sha-512"}
 
 	// Write status line and headers
 	w.WriteStatusLine(response.StatusCode(resp.StatusCode))
+	for _, c := range proxy.ResponseCookies(resp.Header) {
+		w.SetCookie(c)
+	}
 	w.WriteHeaders(headers)
-	
-	var fullBody []byte
+
+	length := 0
 	buf := make([]byte, 1024)
 	for {
 		n, err := resp.Body.Read(buf)
 		if n > 0 {
-			// Print n for debugging
-			chunk := buf[:n]
-			fmt.Println("Read bytes:", n)
-			fullBody = append(fullBody, chunk...)
-			w.WriteChunkedBody(chunk)
+			length += n
+			w.WriteChunkedBody(buf[:n])
 		}
-		if err != nil {
+		if err == io.EOF {
 			break
+		} else if err != nil {
+			return err
 		}
 	}
 	w.WriteChunkedBodyDone()
+	w.WriteTrailers(map[string]string{"X-Content-Length": strconv.Itoa(length)})
+	return nil
+}
 
-	hashSum := sha256.Sum256(fullBody)
-	trailers := response.GetDefaultTrailerHeaders(len(fullBody), fmt.Sprintf("%x", hashSum))
-	w.WriteTrailers(trailers)
+// progressHandler streams progress updates as Server-Sent Events, resuming
+// after the last one a reconnecting client saw.
+func progressHandler(w *response.Writer, req *request.Request) {
+	stream, err := sse.NewStream(w, req, sse.Options{Retry: time.Second})
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+
+	step, _ := strconv.Atoi(sse.LastEventID(req))
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for step < 10 {
+		select {
+		case <-stream.Done():
+			return
+		case <-ticker.C:
+		}
+		step++
+		err := stream.Send(sse.Event{
+			Event: "progress",
+			ID:    strconv.Itoa(step),
+			Data:  fmt.Sprintf("%d%%", step*10),
+		})
+		if err != nil {
+			return
+		}
+	}
+	stream.Send(sse.Event{Event: "done"})
 }
 
 func videoHandler(w *response.Writer, req *request.Request) {
@@ -102,82 +168,127 @@ func videoHandler(w *response.Writer, req *request.Request) {
 	w.WriteBody(video)
 }
 
-func okHandler(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.OK)
-	body := []byte(`<html>
-		<head>
-			<title>200 OK</title>
-		</head>
-		<body>
-			<h1>Success!</h1>
-			<p>Your request was an absolute banger.</p>
-		</body>
-	</html>`)
+// pageOffers are the representations of the built-in pages.
+var pageOffers = []negotiate.Offer{
+	{ContentType: "text/html"},
+	{ContentType: "application/json"},
+	{ContentType: "text/plain"},
+}
 
-	header := response.GetDefaultHeaders(len(body))
-	header["Content-Type"] = "text/html"
-	err := w.WriteHeaders(header)
-	if err != nil {
-		fmt.Println("Error writing headers: ", err)
-		return
+// writePage renders a built-in page as HTML, JSON or plain text, whichever
+// the client's Accept header prefers. Error pages the client accepts none of
+// are sent as plain text, as a 406 would hide their status.
+func writePage(w *response.Writer, req *request.Request, statusCode response.StatusCode, heading, message string) {
+	var offer negotiate.Offer
+	if statusCode >= 400 {
+		var ok bool
+		if offer, ok = negotiate.Best(req.Headers, pageOffers...); !ok {
+			offer = negotiate.Offer{ContentType: "text/plain"}
+		}
+	} else {
+		var ok bool
+		if offer, ok = negotiate.Negotiate(w, req, pageOffers...); !ok {
+			return
+		}
 	}
-	w.WriteBody(body)
-}
 
-func serverErrorHandler(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.InternalServerError)
-	body := []byte(`<html>
+	var body []byte
+	switch offer.ContentType {
+	case "application/json":
+		body, _ = json.Marshal(map[string]any{
+			"status":  int(statusCode),
+			"title":   heading,
+			"message": message,
+		})
+	case "text/plain":
+		body = []byte(fmt.Sprintf("%s\n\n%s\n", heading, message))
+	default:
+		body = []byte(fmt.Sprintf(`<html>
 	<head>
-		<title>500 Internal Server Error</title>
+		<title>%d %s</title>
 	</head>
 	<body>
-		<h1>Internal Server Error</h1>
-		<p>Okay, you know what? This one is on me.</p>
+		<h1>%s</h1>
+		<p>%s</p>
 	</body>
-	</html>`)
+	</html>`, statusCode, response.StatusText(statusCode), heading, message))
+	}
 
+	w.WriteStatusLine(statusCode)
 	header := response.GetDefaultHeaders(len(body))
-	header["Content-Type"] = "text/html"
+	header["Content-Type"] = offer.ContentType
+	header["Vary"] = "Accept"
 	err := w.WriteHeaders(header)
 	if err != nil {
-		fmt.Println("Error writing headers: ", err)
+		log.Warn("writing headers", zap.Error(err))
 		return
 	}
 	w.WriteBody(body)
 }
 
-func badRequestHandler(w *response.Writer, _ *request.Request) {
-	w.WriteStatusLine(response.BadRequest)
-	body := []byte(`<html>
-	<head>
-		<title>400 Bad Request</title>
-	</head>
-	<body>
-		<h1>Bad Request</h1>
-		<p>Your request honestly kinda sucked.</p>
-	</body>
-	</html>`)
+func okHandler(w *response.Writer, req *request.Request) {
+	writePage(w, req, response.OK, "Success!", "Your request was an absolute banger.")
+}
 
-	header := response.GetDefaultHeaders(len(body))
-	header["Content-Type"] = "text/html"
-	err := w.WriteHeaders(header)
-	if err != nil {
-		fmt.Println("Error writing headers: ", err)
-		return
-	}
-	w.WriteBody(body)
+func serverErrorHandler(w *response.Writer, req *request.Request) {
+	writePage(w, req, response.InternalServerError, "Internal Server Error", "Okay, you know what? This one is on me.")
+}
+
+func badRequestHandler(w *response.Writer, req *request.Request) {
+	writePage(w, req, response.BadRequest, "Bad Request", "Your request honestly kinda sucked.")
+}
+
+// accessLogFormats maps the values of -access-log-format to formats.
+var accessLogFormats = map[string]accesslog.Format{
+	"common":   accesslog.Common,
+	"combined": accesslog.Combined,
+	"json":     accesslog.JSON,
 }
 
 func main() {
-	server, err := server.Serve(port, HttpHandler)
+	accessLogPath := flag.String("access-log", "", "file to write the access log to, rotated at 100 MB; stdout if empty")
+	accessLogFormat := flag.String("access-log-format", "combined", "access log format: common, combined or json")
+	metricsPath := flag.String("metrics-path", "/metrics", "path serving Prometheus metrics; none if empty")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "OTLP/HTTP URL to export trace spans to, e.g. "+tracing.DefaultOTLPEndpoint+"; no tracing if empty")
+	flag.Parse()
+
+	format, ok := accessLogFormats[*accessLogFormat]
+	if !ok {
+		log.Fatal("unknown access log format", zap.String("format", *accessLogFormat))
+	}
+	logOpts := accesslog.Options{Format: format}
+	if *accessLogPath != "" {
+		out := accesslog.File(accesslog.Rotation{Filename: *accessLogPath, MaxBackups: 10, Compress: true})
+		defer out.Close()
+		logOpts.Output = out
+	}
+
+	handler := compress.Middleware(compress.Options{})(HttpHandler)
+	if *otlpEndpoint != "" {
+		tracer := tracing.NewTracer(tracing.NewOTLPExporter(tracing.OTLPOptions{
+			Endpoint:    *otlpEndpoint,
+			ServiceName: "chillhttp",
+		}), tracing.Options{})
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			tracer.Shutdown(ctx)
+		}()
+		handler = tracer.Middleware()(handler)
+	}
+	accessLog := accesslog.New(logOpts)
+	handler = accessLog.Middleware()(handler)
+	server, err := server.Serve(port, handler,
+		server.WithMetrics(metrics.NewRegistry(), *metricsPath),
+		server.WithRejectHook(accessLog.RejectHook()))
 	if err != nil {
-		fmt.Println("Error starting server: %w", err)
+		log.Fatal("starting server", zap.Error(err))
 	}
 	defer server.Close()
-	fmt.Println("Server listening on :", port)
+	log.Info("server listening", zap.Int("port", port))
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
 	log.Info("Received shutdown signal, shutting down server...")
-}
\ No newline at end of file
+}
@@ -2,14 +2,17 @@ module chillhttp
 
 go 1.23.2
 
+require (
+	github.com/pingcap/log v1.1.0
+	github.com/stretchr/testify v1.10.0
+	go.uber.org/zap v1.19.0
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0
+)
+
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/pingcap/log v1.1.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.10.0 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.7.0 // indirect
-	go.uber.org/zap v1.19.0 // indirect
-	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,252 @@
+// Package accesslog records every request a server answers, one line each,
+// in the Common or Combined Log Format or as JSON. A Logger's Middleware logs
+// the requests that reach the handler; requests the server rejects before
+// that, such as malformed ones, are only logged through its RejectHook.
+package accesslog
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+	"chillhttp/internal/server"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+type Format int
+
+const (
+	// Common is the Common Log Format of NCSA httpd and Apache:
+	//	host ident user [time] "request line" status bytes
+	Common Format = iota
+	// Combined is Common followed by the quoted Referer and User-Agent.
+	Combined
+	// JSON writes an object per line with every field, including the
+	// duration and the request ID, which the text formats have no room for.
+	JSON
+)
+
+// clfTime is the timestamp layout of the text formats.
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+type Options struct {
+	Format Format
+	// Output receives the log lines; nil means os.Stdout. Use File for a
+	// file that rotates.
+	Output io.Writer
+}
+
+// Rotation describes a log file that is rotated as it grows.
+type Rotation struct {
+	Filename string
+	// MaxSize is the size in megabytes past which the file is rotated; 0
+	// means 100.
+	MaxSize int
+	// MaxBackups and MaxAge, in days, bound how many rotated files are
+	// kept; 0 keeps them all.
+	MaxBackups int
+	MaxAge     int
+	// Compress gzips the rotated files.
+	Compress bool
+}
+
+// File returns a writer appending to r.Filename, which it creates if needed
+// and rotates as set out in r. Close it when done logging.
+func File(r Rotation) io.WriteCloser {
+	return &lumberjack.Logger{
+		Filename:   r.Filename,
+		MaxSize:    r.MaxSize,
+		MaxBackups: r.MaxBackups,
+		MaxAge:     r.MaxAge,
+		Compress:   r.Compress,
+	}
+}
+
+// entry is what gets logged about a request.
+type entry struct {
+	start      time.Time
+	duration   time.Duration
+	method     string
+	target     string
+	proto      string
+	status     response.StatusCode
+	bytes      int64
+	remoteAddr string
+	host       string
+	userAgent  string
+	referer    string
+	requestID  string
+}
+
+// A Logger writes the access log of a server, from its Middleware and its
+// RejectHook alike.
+type Logger struct {
+	log func(entry)
+}
+
+func New(opts Options) *Logger {
+	return &Logger{log: newLogger(opts)}
+}
+
+// Middleware logs the requests of a Logger made from opts; see
+// Logger.Middleware. Requests the server rejects itself aren't logged.
+func Middleware(opts Options) server.Middleware {
+	return New(opts).Middleware()
+}
+
+// Middleware logs each request once the handler is done with it. It also
+// gives requests an ID with server.RequestID, so it has one to log; wrap it
+// around any middleware that changes the response, such as compression, to
+// log what the client actually got.
+func (l *Logger) Middleware() server.Middleware {
+	log := l.log
+	return func(next server.Handler) server.Handler {
+		next = server.RequestID()(next)
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			next(w, req)
+			log(entry{
+				start:      start,
+				duration:   time.Since(start),
+				method:     req.RequestLine.Method,
+				target:     req.RequestLine.RequestTarget,
+				proto:      "HTTP/" + req.RequestLine.HttpVersion,
+				status:     w.Status(),
+				bytes:      w.BytesWritten(),
+				remoteAddr: req.RemoteAddr,
+				host:       req.Host(),
+				userAgent:  req.Headers.Get("User-Agent"),
+				referer:    req.Headers.Get("Referer"),
+				requestID:  server.GetRequestID(req),
+			})
+		}
+	}
+}
+
+// RejectHook logs the requests the server answers with an error itself; pass
+// it to server.WithRejectHook. A request that couldn't be parsed is logged
+// with "-" for its request line. Rejected requests have no request ID.
+func (l *Logger) RejectHook() func(server.Rejection) {
+	return func(r server.Rejection) {
+		e := entry{
+			start:      r.Start,
+			duration:   r.Duration,
+			status:     r.Status,
+			bytes:      r.Bytes,
+			remoteAddr: r.RemoteAddr,
+		}
+		if req := r.Request; req != nil {
+			e.method = req.RequestLine.Method
+			e.target = req.RequestLine.RequestTarget
+			e.proto = "HTTP/" + req.RequestLine.HttpVersion
+			e.host = req.Host()
+			e.userAgent = req.Headers.Get("User-Agent")
+			e.referer = req.Headers.Get("Referer")
+		}
+		l.log(e)
+	}
+}
+
+// newLogger returns the function that writes entries in the format opts
+// asks for.
+func newLogger(opts Options) func(entry) {
+	out := opts.Output
+	if out == nil {
+		out = os.Stdout
+	}
+
+	if opts.Format == JSON {
+		core := zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+			TimeKey:        "time",
+			EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+			EncodeDuration: zapcore.SecondsDurationEncoder,
+		}), zapcore.Lock(zapcore.AddSync(out)), zapcore.InfoLevel)
+		return func(e entry) {
+			core.Write(zapcore.Entry{Time: e.start}, []zap.Field{
+				zap.String("method", e.method),
+				zap.String("target", e.target),
+				zap.String("proto", e.proto),
+				zap.Int("status", int(e.status)),
+				zap.Int64("bytes", e.bytes),
+				zap.Duration("duration", e.duration),
+				zap.String("remote_addr", e.remoteAddr),
+				zap.String("host", e.host),
+				zap.String("user_agent", e.userAgent),
+				zap.String("referer", e.referer),
+				zap.String("request_id", e.requestID),
+			})
+		}
+	}
+
+	var mu sync.Mutex
+	combined := opts.Format == Combined
+	return func(e entry) {
+		line := appendCommon(nil, e)
+		if combined {
+			line = appendCombined(line, e)
+		}
+		line = append(line, '\n')
+
+		mu.Lock()
+		defer mu.Unlock()
+		out.Write(line)
+	}
+}
+
+// appendCommon formats e in the Common Log Format.
+func appendCommon(b []byte, e entry) []byte {
+	host, _, err := net.SplitHostPort(e.remoteAddr)
+	if err != nil {
+		host = "-"
+	}
+	bytes := "-"
+	if e.bytes > 0 {
+		bytes = fmt.Sprint(e.bytes)
+	}
+	requestLine := "-"
+	if e.method != "" {
+		requestLine = escape(e.method) + " " + escape(e.target) + " " + escape(e.proto)
+	}
+	return fmt.Appendf(b, "%s - - [%s] \"%s\" %d %s",
+		host, e.start.Format(clfTime), requestLine, e.status, bytes)
+}
+
+// appendCombined adds the fields of the Combined Log Format to a Common one.
+func appendCombined(b []byte, e entry) []byte {
+	return fmt.Appendf(b, " \"%s\" \"%s\"", orDash(escape(e.referer)), orDash(escape(e.userAgent)))
+}
+
+// escape makes a client-supplied value safe inside a quoted field, the way
+// Apache does: quotes and backslashes are escaped and other unprintable
+// bytes written as \xhh.
+func escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
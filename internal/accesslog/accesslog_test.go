@@ -0,0 +1,152 @@
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"chillhttp/internal/compress"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+	"chillhttp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextFormats(t *testing.T) {
+	e := entry{
+		start:      time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*3600)),
+		method:     "GET",
+		target:     `/a"b`,
+		proto:      "HTTP/1.1",
+		status:     200,
+		bytes:      2326,
+		remoteAddr: "127.0.0.1:51234",
+		userAgent:  "curl/8.0\x01",
+	}
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a\"b HTTP/1.1" 200 2326`,
+		string(appendCommon(nil, e)))
+	assert.Equal(t, ` "-" "curl/8.0\x01"`, string(appendCombined(nil, e)))
+
+	e.bytes = 0
+	e.remoteAddr = ""
+	assert.Equal(t, `- - - [10/Oct/2000:13:55:36 -0700] "GET /a\"b HTTP/1.1" 200 -`,
+		string(appendCommon(nil, e)))
+}
+
+// lineWriter hands each log line to the test as it is written.
+type lineWriter chan string
+
+func (lw lineWriter) Write(p []byte) (int, error) {
+	lw <- string(p)
+	return len(p), nil
+}
+
+func helloHandler(w *response.Writer, _ *request.Request) {
+	body := []byte("hello")
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// serve answers one request, sent as raw, with handler behind the access
+// log, and returns the response and the line logged for it.
+func serve(t *testing.T, opts Options, handler server.Handler, raw string) (*http.Response, string) {
+	t.Helper()
+	lines := make(lineWriter, 1)
+	opts.Output = lines
+	logger := New(opts)
+	s, err := server.Serve(0, logger.Middleware()(handler), server.WithRejectHook(logger.RejectHook()))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, raw)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, <-lines
+}
+
+func TestCombined(t *testing.T) {
+	resp, line := serve(t, Options{Format: Combined}, helloHandler,
+		"GET /hi?x=1 HTTP/1.1\r\nHost: localhost\r\nUser-Agent: test/1.0\r\nReferer: http://example.test/\r\n\r\n")
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Regexp(t, `^\S+ - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\] "GET /hi\?x=1 HTTP/1\.1" 200 5 "http://example\.test/" "test/1\.0"\n$`, line)
+}
+
+func TestJSON(t *testing.T) {
+	resp, line := serve(t, Options{Format: JSON}, helloHandler,
+		"GET / HTTP/1.1\r\nHost: localhost\r\nUser-Agent: test/1.0\r\nX-Request-Id: abc-123\r\n\r\n")
+	assert.Equal(t, "abc-123", resp.Header.Get("X-Request-Id"))
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal([]byte(line), &got))
+	assert.Equal(t, "GET", got["method"])
+	assert.Equal(t, "/", got["target"])
+	assert.Equal(t, "HTTP/1.1", got["proto"])
+	assert.Equal(t, 200.0, got["status"])
+	assert.Equal(t, 5.0, got["bytes"])
+	assert.Equal(t, "localhost", got["host"])
+	assert.Equal(t, "test/1.0", got["user_agent"])
+	assert.Equal(t, "abc-123", got["request_id"])
+	assert.NotEmpty(t, got["remote_addr"])
+	assert.IsType(t, 0.0, got["duration"])
+	_, err := time.Parse(time.RFC3339Nano, got["time"].(string))
+	assert.NoError(t, err)
+}
+
+func TestRejected(t *testing.T) {
+	resp, line := serve(t, Options{Format: Combined}, helloHandler,
+		"GET /hi HTTP/1.1\r\nUser-Agent: test/1.0\r\n\r\n")
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.Regexp(t, `"GET /hi HTTP/1\.1" 400 \d+ "-" "test/1\.0"\n$`, line)
+
+	resp, line = serve(t, Options{Format: Common}, helloHandler, "GET /\r\n\r\n")
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.Regexp(t, `\] "-" 400 -\n$`, line)
+}
+
+func TestBytesAfterCompression(t *testing.T) {
+	body := make([]byte, 4096)
+	handler := compress.Middleware(compress.Options{})(func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+	resp, line := serve(t, Options{Format: JSON}, handler,
+		"GET / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n")
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal([]byte(line), &got))
+	assert.Greater(t, got["bytes"], 0.0)
+	assert.Less(t, got["bytes"], float64(len(body)))
+	assert.Len(t, resp.Header.Get("X-Request-Id"), 32)
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f := File(Rotation{Filename: path, MaxSize: 1})
+	log := newLogger(Options{Format: Common, Output: f})
+	log(entry{start: time.Now(), method: "GET", target: "/", proto: "HTTP/1.1", status: 404})
+	require.NoError(t, f.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"GET / HTTP/1.1" 404 -`)
+}
@@ -0,0 +1,168 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"chillhttp/internal/headers"
+	"chillhttp/internal/negotiate"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+	"chillhttp/internal/server"
+)
+
+// defaultMinSize is the smallest body worth compressing when Options
+// doesn't set one: below it the gzip header and framing eat the savings.
+const defaultMinSize = 1024
+
+type Options struct {
+	// Level is a compression level from compress/flate; 0 means
+	// flate.DefaultCompression.
+	Level int
+	// MinSize is the smallest Content-Length that gets compressed; 0 means
+	// 1 KiB. Bodies of unknown length are always compressed.
+	MinSize int
+}
+
+// offers lists the codings we can produce, in order of preference. HTTP's
+// "deflate" is the zlib format (RFC 9110 section 8.4.1.2).
+var offers = []negotiate.Offer{
+	{Encoding: "gzip"},
+	{Encoding: "deflate"},
+	{Encoding: "identity"},
+}
+
+// compressedTypes lists media types whose content is already compressed.
+// Types ending in "/" match the whole top-level type.
+var compressedTypes = []string{
+	"image/", "audio/", "video/", "font/woff", "font/woff2",
+	"application/gzip", "application/x-gzip", "application/zip",
+	"application/zstd", "application/x-bzip2", "application/x-xz",
+	"application/x-7z-compressed", "application/x-rar-compressed",
+	"application/octet-stream", "application/pdf",
+}
+
+// Middleware compresses responses with gzip or deflate when the client's
+// Accept-Encoding allows it.
+func Middleware(opts Options) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			if req.RequestLine.Method != "HEAD" {
+				w.AddFilter(Filter(req, opts))
+			}
+			next(w, req)
+		}
+	}
+}
+
+// Filter returns a response.Filter that compresses the response to req.
+// Responses that are already encoded, already compressed, too small, marked
+// no-transform, partial (206) or without a body are left alone.
+func Filter(req *request.Request, opts Options) response.Filter {
+	if opts.MinSize == 0 {
+		opts.MinSize = defaultMinSize
+	}
+
+	return func(statusCode response.StatusCode, h headers.Headers) response.Encoder {
+		if !compressible(statusCode, h, opts.MinSize) {
+			return nil
+		}
+		// The body depends on Accept-Encoding whichever coding this
+		// client gets, so caches must keep the variants apart.
+		addVary(h, "Accept-Encoding")
+
+		// RFC 9110 lets a request without Accept-Encoding take any
+		// coding, but clients that leave it out rarely decode any.
+		if req.Headers.Get("Accept-Encoding") == "" {
+			return nil
+		}
+		offer, ok := negotiate.Best(req.Headers, offers...)
+		if !ok || offer.Encoding == "identity" {
+			return nil
+		}
+
+		h.Set("Content-Encoding", offer.Encoding)
+		// Byte ranges of the compressed body would mean nothing to a
+		// client that asked for a range of the uncompressed one.
+		h.Delete("Accept-Ranges")
+		if etag := h.Get("ETag"); etag != "" {
+			// A strong validator names exact bytes; these are different.
+			h.Set("ETag", weakETag(etag))
+		}
+		return encoder(offer.Encoding, opts.Level)
+	}
+}
+
+func compressible(statusCode response.StatusCode, h headers.Headers, minSize int) bool {
+	switch {
+	case statusCode < 200, statusCode == 204, statusCode == 206, statusCode == 304:
+		return false
+	case h.Get("Content-Encoding") != "" && !strings.EqualFold(h.Get("Content-Encoding"), "identity"):
+		return false
+	case h.Get("Content-Range") != "":
+		return false
+	case h.HasToken("Cache-Control", "no-transform"):
+		return false
+	}
+
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < minSize {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		// Without a type we can't tell what the body is; leave it.
+		return false
+	}
+	if mediaType == "image/svg+xml" {
+		return true
+	}
+	for _, t := range compressedTypes {
+		if mediaType == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t)) {
+			return false
+		}
+	}
+	return true
+}
+
+func encoder(coding string, level int) response.Encoder {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return func(dst io.Writer) io.WriteCloser {
+		if coding == "deflate" {
+			zw, err := zlib.NewWriterLevel(dst, level)
+			if err != nil {
+				zw = zlib.NewWriter(dst)
+			}
+			return zw
+		}
+		gw, err := gzip.NewWriterLevel(dst, level)
+		if err != nil {
+			gw = gzip.NewWriter(dst)
+		}
+		return gw
+	}
+}
+
+func addVary(h headers.Headers, field string) {
+	if h.HasToken("Vary", field) || h.HasToken("Vary", "*") {
+		return
+	}
+	if vary := h.Get("Vary"); vary != "" {
+		h.Set("Vary", vary+", "+field)
+		return
+	}
+	h.Set("Vary", field)
+}
+
+func weakETag(etag string) string {
+	if strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "W/" + etag
+}
@@ -0,0 +1,164 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var page = strings.Repeat("<p>chill</p>", 200)
+
+// serve runs handler behind the middleware for a request with the given
+// extra headers and returns the raw response.
+func serve(t *testing.T, version, extra string, handler func(w *response.Writer, req *request.Request)) *http.Response {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/" + version + "\r\nHost: localhost\r\n" + extra + "\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.HttpVersion = version
+	w.KeepAlive = true
+	Middleware(Options{})(handler)(w, req)
+	w.Finish()
+
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func pageHandler(contentType string, body string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, _ *request.Request) {
+		h := response.GetDefaultHeaders(len(body))
+		h["Content-Type"] = contentType
+		h["Accept-Ranges"] = "bytes"
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body))
+	}
+}
+
+func TestGzip(t *testing.T) {
+	resp := serve(t, "1.1", "Accept-Encoding: deflate;q=0.5, gzip\r\n", pageHandler("text/html", page))
+
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+	assert.Empty(t, resp.Header.Get("Accept-Ranges"))
+
+	zr, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(body))
+}
+
+func TestDeflate(t *testing.T) {
+	resp := serve(t, "1.1", "Accept-Encoding: deflate\r\n", pageHandler("text/html", page))
+	assert.Equal(t, "deflate", resp.Header.Get("Content-Encoding"))
+
+	zr, err := zlib.NewReader(resp.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(body))
+}
+
+func TestLeftUncompressed(t *testing.T) {
+	tests := []struct {
+		name    string
+		extra   string
+		handler func(w *response.Writer, req *request.Request)
+		vary    bool
+	}{
+		{"no accept-encoding", "", pageHandler("text/html", page), true},
+		{"identity only", "Accept-Encoding: br\r\n", pageHandler("text/html", page), true},
+		{"tiny body", "Accept-Encoding: gzip\r\n", pageHandler("text/html", "<p>hi</p>"), false},
+		{"compressed type", "Accept-Encoding: gzip\r\n", pageHandler("video/mp4", page), false},
+		{"partial content", "Accept-Encoding: gzip\r\n", func(w *response.Writer, _ *request.Request) {
+			h := response.GetDefaultHeaders(len(page))
+			h["Content-Range"] = fmt.Sprintf("bytes 0-%d/9999", len(page)-1)
+			w.WriteStatusLine(206)
+			w.WriteHeaders(h)
+			w.WriteBody([]byte(page))
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := serve(t, "1.1", tt.extra, tt.handler)
+			assert.Empty(t, resp.Header.Get("Content-Encoding"))
+			assert.NotEqual(t, int64(-1), resp.ContentLength)
+			assert.Equal(t, tt.vary, resp.Header.Get("Vary") == "Accept-Encoding")
+			io.ReadAll(resp.Body)
+		})
+	}
+}
+
+func TestStreamedBodyWithTrailers(t *testing.T) {
+	resp := serve(t, "1.1", "Accept-Encoding: gzip\r\n", func(w *response.Writer, _ *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		delete(h, "Content-Length")
+		h["Transfer-Encoding"] = "chunked"
+		h["Trailer"] = "X-Checksum"
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(map[string]string{"X-Checksum": "abc"})
+	})
+
+	zr, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+}
+
+func TestHttp10CloseDelimited(t *testing.T) {
+	resp := serve(t, "1.0", "Accept-Encoding: gzip\r\n", pageHandler("text/plain", page))
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Empty(t, resp.TransferEncoding)
+	assert.True(t, resp.Close)
+
+	zr, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(body))
+}
+
+func TestAbandonedStreamNotTerminated(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\n\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	w.KeepAlive = true
+	Middleware(Options{})(func(w *response.Writer, _ *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		delete(h, "Content-Length")
+		h["Transfer-Encoding"] = "chunked"
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte(page))
+		// The handler returns without WriteChunkedBodyDone, as on an
+		// upstream error.
+	})(w, req)
+
+	assert.False(t, w.Finish())
+	assert.NotContains(t, buf.String(), "\r\n0\r\n\r\n")
+}
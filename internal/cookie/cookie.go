@@ -0,0 +1,202 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute off and lets the browser decide.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+var sameSiteText = map[SameSite]string{
+	SameSiteLax:    "Lax",
+	SameSiteStrict: "Strict",
+	SameSiteNone:   "None",
+}
+
+// Cookie is a cookie sent by the client in a Cookie header, or one the
+// server sets with a Set-Cookie header (RFC 6265). Only Name and Value are
+// filled in for cookies parsed from a request.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Domain  string
+	Path    string
+	Expires time.Time
+	// MaxAge follows net/http: 0 leaves Max-Age out, a negative value sends
+	// "Max-Age=0" to delete the cookie now, and a positive one is seconds.
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+	// Partitioned puts the cookie in partitioned storage (CHIPS). Browsers
+	// only accept it together with Secure.
+	Partitioned bool
+}
+
+// expiresFormat is the IMF-fixdate format of RFC 9110 section 5.6.7.
+const expiresFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+var (
+	ErrInvalidName   = errors.New("invalid cookie name")
+	ErrInvalidValue  = errors.New("invalid cookie value")
+	ErrInvalidPath   = errors.New("invalid cookie path")
+	ErrInvalidDomain = errors.New("invalid cookie domain")
+	ErrNotSecure     = errors.New("cookie attribute requires Secure")
+)
+
+// isToken reports whether s is a non-empty RFC 9110 token, the grammar of a
+// cookie name.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?={}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isCookieOctet reports whether c may appear in a cookie value (RFC 6265
+// section 4.1.1): no controls, whitespace, DQUOTE, comma, semicolon or
+// backslash.
+func isCookieOctet(c byte) bool {
+	return c > ' ' && c < 0x7f && c != '"' && c != ',' && c != ';' && c != '\\'
+}
+
+// validValue reports whether v is a valid cookie value. Like net/http, we
+// also allow spaces and commas, which String sends quoted.
+func validValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if !isCookieOctet(v[i]) && v[i] != ' ' && v[i] != ',' {
+			return false
+		}
+	}
+	return true
+}
+
+// validAttribute reports whether v can be an attribute value: anything but
+// controls and ';'.
+func validAttribute(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if v[i] < ' ' || v[i] == 0x7f || v[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+// Valid reports why c can't be sent in a Set-Cookie header, if it can't.
+func (c *Cookie) Valid() error {
+	if !isToken(c.Name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, c.Name)
+	}
+	if !validValue(c.Value) {
+		return fmt.Errorf("%w: %q", ErrInvalidValue, c.Value)
+	}
+	if !validAttribute(c.Path) {
+		return fmt.Errorf("%w: %q", ErrInvalidPath, c.Path)
+	}
+	if !validAttribute(c.Domain) || strings.ContainsAny(c.Domain, " \t") {
+		return fmt.Errorf("%w: %q", ErrInvalidDomain, c.Domain)
+	}
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return fmt.Errorf("%w: SameSite=None", ErrNotSecure)
+	}
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("%w: Partitioned", ErrNotSecure)
+	}
+	return nil
+}
+
+// String returns c serialized as the value of a Set-Cookie header. It does
+// not validate c; see Valid.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	if strings.ContainsAny(c.Value, " ,") {
+		b.WriteByte('"')
+		b.WriteString(c.Value)
+		b.WriteByte('"')
+	} else {
+		b.WriteString(c.Value)
+	}
+
+	if c.Path != "" {
+		b.WriteString("; Path=")
+		b.WriteString(c.Path)
+	}
+	if c.Domain != "" {
+		// A leading dot is obsolete and ignored by browsers (RFC 6265
+		// section 5.2.3).
+		b.WriteString("; Domain=")
+		b.WriteString(strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=")
+		b.WriteString(c.Expires.UTC().Format(expiresFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=")
+		b.WriteString(strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if text, ok := sameSiteText[c.SameSite]; ok {
+		b.WriteString("; SameSite=")
+		b.WriteString(text)
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// Parse parses the value of a request's Cookie header, e.g.
+// "session=abc; theme=dark". Malformed pairs are skipped rather than failing
+// the whole header, as browsers send whatever other sites managed to set.
+func Parse(header string) []*Cookie {
+	var cookies []*Cookie
+	for header != "" {
+		var pair string
+		pair, header, _ = strings.Cut(header, ";")
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || !isToken(name) {
+			continue
+		}
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		if !validValue(value) {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
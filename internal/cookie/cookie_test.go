@@ -0,0 +1,53 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestString(t *testing.T) {
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Domain:      ".example.com",
+		Path:        "/",
+		Expires:     time.Date(2026, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	assert.NoError(t, c.Valid())
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Fri, 02 Jan 2026 02:04:05 GMT; "+
+		"Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", c.String())
+
+	assert.Equal(t, "a=b", (&Cookie{Name: "a", Value: "b"}).String())
+	assert.Equal(t, "a=; Max-Age=0", (&Cookie{Name: "a", MaxAge: -1}).String())
+	assert.Equal(t, `a="b c"; SameSite=Lax`, (&Cookie{Name: "a", Value: "b c", SameSite: SameSiteLax}).String())
+}
+
+func TestValid(t *testing.T) {
+	assert.ErrorIs(t, (&Cookie{Name: ""}).Valid(), ErrInvalidName)
+	assert.ErrorIs(t, (&Cookie{Name: "a b"}).Valid(), ErrInvalidName)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Value: "x;y"}).Valid(), ErrInvalidValue)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Value: "x\r\nSet-Cookie: y"}).Valid(), ErrInvalidValue)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Path: "/; Secure"}).Valid(), ErrInvalidPath)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Domain: "a b"}).Valid(), ErrInvalidDomain)
+	assert.ErrorIs(t, (&Cookie{Name: "a", SameSite: SameSiteNone}).Valid(), ErrNotSecure)
+	assert.ErrorIs(t, (&Cookie{Name: "a", Partitioned: true}).Valid(), ErrNotSecure)
+}
+
+func TestParse(t *testing.T) {
+	cookies := Parse(` session=abc; theme="dark";; bad name=x; empty=; noequals; list=a,b `)
+	assert.Equal(t, []*Cookie{
+		{Name: "session", Value: "abc"},
+		{Name: "theme", Value: "dark"},
+		{Name: "empty", Value: ""},
+		{Name: "list", Value: "a,b"},
+	}, cookies)
+
+	assert.Empty(t, Parse(""))
+}
@@ -0,0 +1,186 @@
+package digest
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// algorithms are the hash algorithms of the RFC 9530 registry we support,
+// keyed by their registered names.
+var algorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// Supported reports whether alg is an algorithm we can compute.
+func Supported(alg string) bool {
+	_, ok := algorithms[alg]
+	return ok
+}
+
+var (
+	ErrMalformed = errors.New("malformed digest field")
+	// ErrMismatch is returned when a body doesn't match the Content-Digest
+	// it was sent with.
+	ErrMismatch = errors.New("Content-Digest doesn't match the body")
+)
+
+// Hasher computes the digests of a body as it is written, for one or more
+// algorithms at once.
+type Hasher struct {
+	names  []string
+	hashes []hash.Hash
+}
+
+// NewHasher returns a Hasher for the supported algorithms among algs, or nil
+// if there are none.
+func NewHasher(algs ...string) *Hasher {
+	var h Hasher
+	for _, alg := range algs {
+		if newHash, ok := algorithms[alg]; ok && !contains(h.names, alg) {
+			h.names = append(h.names, alg)
+			h.hashes = append(h.hashes, newHash())
+		}
+	}
+	if len(h.names) == 0 {
+		return nil
+	}
+	return &h
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	for _, hh := range h.hashes {
+		hh.Write(p)
+	}
+	return len(p), nil
+}
+
+// Value returns the digests of everything written so far as the value of a
+// Content-Digest field, e.g. "sha-256=:X48E...PE=:".
+func (h *Hasher) Value() string {
+	var b strings.Builder
+	for i, name := range h.names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteString("=:")
+		b.WriteString(base64.StdEncoding.EncodeToString(h.hashes[i].Sum(nil)))
+		b.WriteByte(':')
+	}
+	return b.String()
+}
+
+// Parse parses a Content-Digest field, a structured-field dictionary of byte
+// sequences. Keys are kept as sent; members that aren't byte sequences make
+// the field malformed. Repr-Digest isn't supported: requests are checked,
+// and responses digested, against Content-Digest only.
+func Parse(value string) (map[string][]byte, error) {
+	digests := make(map[string][]byte)
+	for _, member := range splitMembers(value) {
+		key, val, ok := strings.Cut(member, "=")
+		if !ok || key == "" {
+			return nil, ErrMalformed
+		}
+		// Drop any parameters.
+		val, _, _ = strings.Cut(val, ";")
+		if len(val) < 2 || val[0] != ':' || val[len(val)-1] != ':' {
+			return nil, ErrMalformed
+		}
+		sum, err := base64.StdEncoding.DecodeString(val[1 : len(val)-1])
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		digests[key] = sum
+	}
+	return digests, nil
+}
+
+// ParseWant parses a Want-Content-Digest field, e.g.
+// "sha-512=3, sha-256=10", and returns the supported algorithms the client
+// will accept, most preferred first.
+func ParseWant(value string) []string {
+	type want struct {
+		alg        string
+		preference int
+	}
+	var wants []want
+	for _, member := range splitMembers(value) {
+		key, val, _ := strings.Cut(member, "=")
+		val, _, _ = strings.Cut(val, ";")
+		preference, err := strconv.Atoi(val)
+		if err != nil || preference < 1 || preference > 10 || !Supported(key) {
+			// 0 means "not acceptable".
+			continue
+		}
+		wants = append(wants, want{key, preference})
+	}
+	sort.SliceStable(wants, func(i, j int) bool {
+		return wants[i].preference > wants[j].preference
+	})
+
+	algs := make([]string, len(wants))
+	for i, w := range wants {
+		algs[i] = w.alg
+	}
+	return algs
+}
+
+func splitMembers(value string) []string {
+	var members []string
+	for _, member := range strings.Split(value, ",") {
+		if member = strings.TrimSpace(member); member != "" {
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// HasherFor returns a Hasher for the supported algorithms named in a
+// Content-Digest field value, to check a body against it with Verify. It
+// returns nil if the field names no algorithm we support.
+func HasherFor(value string) (*Hasher, error) {
+	expected, err := Parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var algs []string
+	for alg := range expected {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+	return NewHasher(algs...), nil
+}
+
+// Verify checks what has been written against a Content-Digest field value.
+// Algorithms the Hasher doesn't compute are skipped.
+func (h *Hasher) Verify(value string) error {
+	expected, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	for i, name := range h.names {
+		sum, ok := expected[name]
+		if ok && subtle.ConstantTimeCompare(h.hashes[i].Sum(nil), sum) != 1 {
+			return ErrMismatch
+		}
+	}
+	return nil
+}
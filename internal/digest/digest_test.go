@@ -0,0 +1,63 @@
+package digest
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasherValue(t *testing.T) {
+	h := NewHasher("sha-512", "md5", "sha-256")
+	require.NotNil(t, h)
+	h.Write([]byte(`{"hello": "world"}`))
+
+	// The example from RFC 9530 section 2.
+	assert.Equal(t, "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:, "+
+		"sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", h.Value())
+
+	assert.Nil(t, NewHasher("md5", "sha"))
+}
+
+func TestParse(t *testing.T) {
+	sum256 := sha256.Sum256([]byte("hi"))
+	sum512 := sha512.Sum512([]byte("hi"))
+	h := NewHasher("sha-256", "sha-512")
+	h.Write([]byte("hi"))
+
+	digests, err := Parse(h.Value())
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"sha-256": sum256[:], "sha-512": sum512[:]}, digests)
+
+	for _, value := range []string{"sha-256", "sha-256=abc", "sha-256=:not base64!:", "=:aGk=:"} {
+		_, err := Parse(value)
+		assert.ErrorIs(t, err, ErrMalformed, value)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	h := NewHasher("sha-256")
+	h.Write([]byte("hi"))
+	value := h.Value()
+
+	check, err := HasherFor(value + ", md5=:AAAA:")
+	require.NoError(t, err)
+	check.Write([]byte("hi"))
+	assert.NoError(t, check.Verify(value))
+
+	check, _ = HasherFor(value)
+	check.Write([]byte("ho"))
+	assert.ErrorIs(t, check.Verify(value), ErrMismatch)
+
+	check, err = HasherFor("md5=:AAAA:")
+	require.NoError(t, err)
+	assert.Nil(t, check)
+}
+
+func TestParseWant(t *testing.T) {
+	assert.Equal(t, []string{"sha-256", "sha-512"}, ParseWant("sha-512=3, sha-256=10, md5=10"))
+	assert.Equal(t, []string{"sha-512"}, ParseWant("sha-256=0, sha-512=1"))
+	assert.Empty(t, ParseWant(""))
+}
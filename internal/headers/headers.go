@@ -1,9 +1,9 @@
 package headers
 
 import (
+	"bytes"
 	"errors"
 	"strings"
-	"unicode"
 )
 
 type Headers map[string]string
@@ -12,75 +12,321 @@ func NewHeaders() Headers {
 	return make(Headers)
 }
 
-func isValidHeaderKeyChar(r rune) bool {
-	return unicode.IsLetter(r) || unicode.IsDigit(r) ||
-		strings.ContainsRune("!#$%&'*+-.^_`|~", r)
-}
+// tokenChars marks the bytes allowed in a field name (RFC 9110 "tchar").
+var tokenChars = func() (t [256]bool) {
+	for c := '0'; c <= '9'; c++ {
+		t[c] = true
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		t[c] = true
+		t[c-'a'+'A'] = true
+	}
+	for _, c := range "!#$%&'*+-.^_`|~" {
+		t[c] = true
+	}
+	return t
+}()
+
+// commonKeys interns the lowercased names of frequent headers so parsing
+// them doesn't allocate.
+var commonKeys = func() map[string]string {
+	m := make(map[string]string)
+	for _, k := range []string{
+		"accept", "accept-charset", "accept-encoding", "accept-language",
+		"authorization", "cache-control", "connection", "content-encoding",
+		"content-length", "content-type", "cookie", "expect", "host",
+		"if-modified-since", "if-none-match", "origin", "referer", "te",
+		"trailer", "transfer-encoding", "upgrade", "user-agent",
+		"x-forwarded-for", "x-request-id",
+	} {
+		m[k] = k
+	}
+	return m
+}()
 
-func validateHeaderKey(key string) error {
-	for _, r := range key {
-		if !isValidHeaderKeyChar(r) {
+// ErrWhitespaceInHeaderName is returned for a field name with spaces or tabs
+// in or around it. RFC 9112 section 5.1 requires rejecting "Host : x":
+// proxies disagree on whether it is a Host header.
+var ErrWhitespaceInHeaderName = errors.New("invalid header format: whitespace in header name")
+
+func validateHeaderKey(key []byte) error {
+	for _, c := range key {
+		if c == ' ' || c == '\t' {
+			return ErrWhitespaceInHeaderName
+		}
+		if !tokenChars[c] {
 			return errors.New("invalid character in header key")
 		}
 	}
 	return nil
 }
 
+// validateHeaderValue rejects control characters other than HTAB, which
+// includes stray CR, LF and NUL bytes.
+func validateHeaderValue(value []byte) error {
+	for _, c := range value {
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return errors.New("invalid character in header value")
+		}
+	}
+	return nil
+}
+
+// lowerKey returns key lowercased, without allocating for common headers.
+func lowerKey(key []byte) string {
+	var buf [64]byte
+	lower := buf[:0]
+	if len(key) > len(buf) {
+		lower = make([]byte, 0, len(key))
+	}
+	for _, c := range key {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower = append(lower, c)
+	}
+
+	if k, ok := commonKeys[string(lower)]; ok {
+		return k
+	}
+	return string(lower)
+}
+
+// trimOWS strips the optional whitespace (spaces and tabs) around a value.
+func trimOWS(b []byte) []byte {
+	for len(b) > 0 && (b[0] == ' ' || b[0] == '\t') {
+		b = b[1:]
+	}
+	for len(b) > 0 && (b[len(b)-1] == ' ' || b[len(b)-1] == '\t') {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// Mode selects how strictly Parse follows RFC 9112.
+type Mode int
+
+const (
+	// Strict requires CRLF line endings and rejects obs-fold, bare CR and
+	// other deviations from RFC 9112. It is the default.
+	Strict Mode = iota
+	// Lenient additionally accepts bare LF line endings and unfolds obs-fold
+	// continuation lines, for legacy clients that send them.
+	Lenient
+)
+
+// Leniency names a deviation from RFC 9112 that Lenient mode accepted.
+type Leniency string
+
+const (
+	LeniencyBareLF  Leniency = "bare-lf"
+	LeniencyObsFold Leniency = "obs-fold"
+)
+
+type ParseOptions struct {
+	Mode Mode
+	// OnLeniency, if set, is called each time Lenient mode accepts input
+	// that Strict mode would have rejected.
+	OnLeniency func(Leniency)
+}
+
+func (o ParseOptions) Report(l Leniency) {
+	if o.OnLeniency != nil {
+		o.OnLeniency(l)
+	}
+}
+
+var crlf = []byte("\r\n")
+
+// LineEnd finds the first line in data. It returns the length of the line
+// without its terminator and with it, or -1 for both if the line isn't
+// complete yet. In Lenient mode a bare LF also ends a line, which bareLF
+// reports.
+func LineEnd(data []byte, mode Mode) (end, next int, bareLF bool) {
+	if mode == Lenient {
+		i := bytes.IndexByte(data, '\n')
+		if i == -1 {
+			return -1, -1, false
+		}
+		if i > 0 && data[i-1] == '\r' {
+			return i - 1, i + 1, false
+		}
+		return i, i + 1, true
+	}
+
+	i := bytes.Index(data, crlf)
+	if i == -1 {
+		return -1, -1, false
+	}
+	return i, i + 2, false
+}
+
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.ParseWithOptions(data, ParseOptions{})
+}
+
+// ParseWithOptions is Parse with a choice of strictness.
+func (h Headers) ParseWithOptions(data []byte, opts ParseOptions) (n int, done bool, err error) {
 	// Check for empty data
 	if len(data) == 0 {
 		return 0, false, nil
 	}
 
-	// Check for end of headers (CRLF at start)
-	if len(data) >= 2 && data[0] == '\r' && data[1] == '\n' {
-		return 2, true, nil
-	}
-
 	// Find the end of the header line
-	end := strings.Index(string(data), "\r\n")
+	end, next, bareLF := LineEnd(data, opts.Mode)
 	if end == -1 {
 		return 0, false, nil
 	}
 
+	// An empty line ends the headers
+	if end == 0 {
+		if bareLF {
+			opts.Report(LeniencyBareLF)
+		}
+		return next, true, nil
+	}
+
 	// Split the header line
-	headerLine := string(data[:end])
-	colonIndex := strings.Index(headerLine, ":")
+	headerLine := data[:end]
+	colonIndex := bytes.IndexByte(headerLine, ':')
 	if colonIndex == -1 {
 		return 0, false, errors.New("invalid header format: missing colon")
 	}
 
 	key := headerLine[:colonIndex]
-	if len(key) == 0 || key[len(key)-1] == ' ' {
-		return 0, false, errors.New("invalid header format: empty key or trailing space")
+	if len(key) == 0 {
+		return 0, false, errors.New("invalid header format: empty key")
 	}
 
-	// Extract and clean key and value
-	key = strings.TrimSpace(headerLine[:colonIndex])
-	value := strings.TrimSpace(headerLine[colonIndex+1:])
-
-	// Validate key format
+	// Validate key format. Whitespace anywhere in the key is rejected: a
+	// leading space would make this an obs-fold continuation line.
 	if err := validateHeaderKey(key); err != nil {
 		return 0, false, err
 	}
 
-	key = strings.ToLower(key)
+	value := trimOWS(headerLine[colonIndex+1:])
+	if err := validateHeaderValue(value); err != nil {
+		return 0, false, err
+	}
 
-	if _, exists := h[key]; exists {
-		h[key] = h[key] + ", " + value
-	} else {
-		h[key] = value
+	var leniencies []Leniency
+	if bareLF {
+		leniencies = append(leniencies, LeniencyBareLF)
+	}
+
+	if opts.Mode == Lenient {
+		// Unfold obs-fold: lines starting with whitespace continue this
+		// value. Until the next line has started we can't tell whether it
+		// does.
+		var folded []byte
+		for {
+			if next >= len(data) {
+				return 0, false, nil
+			}
+			if data[next] != ' ' && data[next] != '\t' {
+				break
+			}
+
+			contEnd, contNext, contBareLF := LineEnd(data[next:], opts.Mode)
+			if contEnd == -1 {
+				return 0, false, nil
+			}
+			cont := trimOWS(data[next : next+contEnd])
+			if err := validateHeaderValue(cont); err != nil {
+				return 0, false, err
+			}
+
+			if folded == nil {
+				folded = append(folded, value...)
+			}
+			if len(cont) > 0 {
+				if len(folded) > 0 {
+					folded = append(folded, ' ')
+				}
+				folded = append(folded, cont...)
+			}
+			leniencies = append(leniencies, LeniencyObsFold)
+			if contBareLF {
+				leniencies = append(leniencies, LeniencyBareLF)
+			}
+			next += contNext
+		}
+		if folded != nil {
+			value = folded
+		}
+	}
+
+	h.Add(lowerKey(key), string(value))
+
+	for _, l := range leniencies {
+		opts.Report(l)
 	}
 
-	// Return bytes consumed (header line + CRLF)
-	return end + 2, false, nil
+	// Return bytes consumed (header line + line ending)
+	return next, false, nil
 }
 
-func (h Headers) Get(key string) (string) {
-	key = strings.ToLower(key)
+func (h Headers) Get(key string) string {
 	if value, exists := h[key]; exists {
 		return value
 	}
+	if value, exists := h[lowerKey([]byte(key))]; exists {
+		return value
+	}
+
+	// Response headers are built with canonical keys ("Content-Type"), so
+	// fall back to a case-insensitive scan.
+	for k, value := range h {
+		if strings.EqualFold(k, key) {
+			return value
+		}
+	}
 
 	return ""
 }
+
+// Add appends value to the field named by the lowercase key, combining
+// repeated fields into one list.
+func (h Headers) Add(key, value string) {
+	existing, exists := h[key]
+	if !exists {
+		h[key] = value
+		return
+	}
+	// Cookie values may contain commas, so split Cookie headers are joined
+	// the way RFC 9113 section 8.2.3 reassembles them.
+	separator := ", "
+	if key == "cookie" {
+		separator = "; "
+	}
+	h[key] = existing + separator + value
+}
+
+// Set replaces any existing value for key, whatever its casing.
+func (h Headers) Set(key, value string) {
+	h.Delete(key)
+	h[key] = value
+}
+
+// Delete removes key, whatever its casing.
+func (h Headers) Delete(key string) {
+	for k := range h {
+		if strings.EqualFold(k, key) {
+			delete(h, k)
+		}
+	}
+}
+
+// HasToken reports whether the comma-separated list in key contains token,
+// compared case-insensitively (e.g. "Connection: keep-alive, Upgrade").
+func (h Headers) HasToken(key, token string) bool {
+	list := h.Get(key)
+	for list != "" {
+		var v string
+		v, list, _ = strings.Cut(list, ",")
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package headers
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -109,3 +110,156 @@ func TestCombineValuesForSameKey(t *testing.T) {
 	assert.Equal(t, 29, n)
 	assert.False(t, done)
 }
+
+func TestCombineCookieHeaders(t *testing.T) {
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte("Cookie: a=1\r\n"))
+	require.NoError(t, err)
+	_, _, err = headers.Parse([]byte("Cookie: b=2, 3\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "a=1; b=2, 3", headers["cookie"])
+}
+
+func TestGetSetDeleteIgnoreCase(t *testing.T) {
+	headers := NewHeaders()
+	headers["Content-Type"] = "text/plain"
+	assert.Equal(t, "text/plain", headers.Get("content-type"))
+
+	headers.Set("content-type", "text/html")
+	assert.Equal(t, "text/html", headers.Get("Content-Type"))
+	assert.Len(t, headers, 1)
+
+	headers.Delete("CONTENT-TYPE")
+	assert.Empty(t, headers)
+}
+
+func TestHasToken(t *testing.T) {
+	headers := NewHeaders()
+	headers["connection"] = "Keep-Alive, Upgrade"
+	assert.True(t, headers.HasToken("Connection", "keep-alive"))
+	assert.True(t, headers.HasToken("Connection", "upgrade"))
+	assert.False(t, headers.HasToken("Connection", "close"))
+	assert.False(t, headers.HasToken("Transfer-Encoding", "chunked"))
+}
+
+func FuzzHeadersParse(f *testing.F) {
+	f.Add([]byte("Host: localhost:42069\r\n\r\n"))
+	f.Add([]byte("X-Forwarded-For: 127.0.0.1\r\n\r\n"))
+	f.Add([]byte("Host:    localhost:42069    \r\n\r\n"))
+	f.Add([]byte("       Host : localhost:42069       \r\n\r\n"))
+	f.Add([]byte("X-Folded: one\r\n two\r\n\r\n"))
+	f.Add([]byte("X-Value: a\x00b\r\n\r\n"))
+	f.Add([]byte("\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		headers := NewHeaders()
+		n, done, err := headers.Parse(data)
+		if err != nil {
+			assert.Equal(t, 0, n)
+			assert.Empty(t, headers)
+			return
+		}
+		require.LessOrEqual(t, n, len(data))
+		if done {
+			assert.Equal(t, 2, n)
+			return
+		}
+		if n == 0 {
+			// Need more data: there must be no complete line yet.
+			assert.NotContains(t, string(data), "\r\n")
+			return
+		}
+
+		assert.Equal(t, "\r\n", string(data[n-2:n]))
+		require.Len(t, headers, 1)
+		for key, value := range headers {
+			assert.NotEmpty(t, key)
+			assert.NoError(t, validateHeaderKey([]byte(key)))
+			assert.Equal(t, strings.ToLower(key), key)
+			assert.NoError(t, validateHeaderValue([]byte(value)))
+			assert.Equal(t, string(trimOWS([]byte(value))), value)
+		}
+	})
+}
+
+func TestStrictRejectsBareLF(t *testing.T) {
+	headers := NewHeaders()
+	n, done, err := headers.Parse([]byte("Host: localhost:42069\nAccept: */*\r\n\r\n"))
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+}
+
+func TestLenientBareLF(t *testing.T) {
+	var applied []Leniency
+	opts := ParseOptions{Mode: Lenient, OnLeniency: func(l Leniency) { applied = append(applied, l) }}
+
+	headers := NewHeaders()
+	data := []byte("Host: localhost:42069\nAccept: */*\n\n")
+	n, done, err := headers.ParseWithOptions(data, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 22, n)
+	assert.False(t, done)
+	assert.Equal(t, "localhost:42069", headers["host"])
+
+	n, done, err = headers.ParseWithOptions(data[22:], opts)
+	require.NoError(t, err)
+	assert.Equal(t, 12, n)
+	assert.False(t, done)
+
+	n, done, err = headers.ParseWithOptions(data[34:], opts)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.True(t, done)
+
+	assert.Equal(t, []Leniency{LeniencyBareLF, LeniencyBareLF, LeniencyBareLF}, applied)
+}
+
+func TestStrictRejectsObsFold(t *testing.T) {
+	headers := NewHeaders()
+	data := []byte("X-Folded: one\r\n two\r\n\r\n")
+	n, _, err := headers.Parse(data)
+	require.NoError(t, err)
+
+	_, _, err = headers.Parse(data[n:])
+	require.Error(t, err)
+}
+
+func TestLenientObsFold(t *testing.T) {
+	var applied []Leniency
+	opts := ParseOptions{Mode: Lenient, OnLeniency: func(l Leniency) { applied = append(applied, l) }}
+
+	headers := NewHeaders()
+	data := []byte("X-Folded: one\r\n  two\r\n\tthree \r\n\r\n")
+
+	// The continuation can't be seen yet.
+	n, done, err := headers.ParseWithOptions(data[:15], opts)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.False(t, done)
+
+	n, done, err = headers.ParseWithOptions(data, opts)
+	require.NoError(t, err)
+	assert.Equal(t, len(data)-2, n)
+	assert.False(t, done)
+	assert.Equal(t, "one two three", headers["x-folded"])
+	assert.Equal(t, []Leniency{LeniencyObsFold, LeniencyObsFold}, applied)
+}
+
+func TestLenientStillRejectsBareCR(t *testing.T) {
+	headers := NewHeaders()
+	_, _, err := headers.ParseWithOptions([]byte("X-Value: a\rb\n\n"), ParseOptions{Mode: Lenient})
+	require.Error(t, err)
+}
+
+func TestWhitespaceInHeaderName(t *testing.T) {
+	for _, data := range []string{
+		"Host : localhost\r\n\r\n",
+		"Host\t: localhost\r\n\r\n",
+		"Content Length: 5\r\n\r\n",
+	} {
+		headers := NewHeaders()
+		_, _, err := headers.Parse([]byte(data))
+		assert.ErrorIs(t, err, ErrWhitespaceInHeaderName, data)
+	}
+}
@@ -0,0 +1,409 @@
+package http2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ClientPreface is what a client sends first on an HTTP/2 connection,
+// before its SETTINGS frame (RFC 9113 section 3.4).
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const (
+	frameHeaderLen = 9
+	// minMaxFrameSize is the initial and smallest SETTINGS_MAX_FRAME_SIZE,
+	// and maxMaxFrameSize the largest.
+	minMaxFrameSize = 1 << 14
+	maxMaxFrameSize = 1<<24 - 1
+	// maxWindowSize is the largest a flow-control window may grow.
+	maxWindowSize = 1<<31 - 1
+	// defaultWindowSize is the initial flow-control window of a stream and
+	// of the connection.
+	defaultWindowSize = 65535
+)
+
+// FrameType identifies the kind of a frame.
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+var frameNames = map[FrameType]string{
+	FrameData:         "DATA",
+	FrameHeaders:      "HEADERS",
+	FramePriority:     "PRIORITY",
+	FrameRSTStream:    "RST_STREAM",
+	FrameSettings:     "SETTINGS",
+	FramePushPromise:  "PUSH_PROMISE",
+	FramePing:         "PING",
+	FrameGoAway:       "GOAWAY",
+	FrameWindowUpdate: "WINDOW_UPDATE",
+	FrameContinuation: "CONTINUATION",
+}
+
+func (t FrameType) String() string {
+	if name, ok := frameNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN_FRAME_TYPE_%d", uint8(t))
+}
+
+// Flags are the flags of a frame; their meaning depends on its type.
+type Flags uint8
+
+const (
+	FlagEndStream  Flags = 0x1
+	FlagAck        Flags = 0x1
+	FlagEndHeaders Flags = 0x4
+	FlagPadded     Flags = 0x8
+	FlagPriority   Flags = 0x20
+)
+
+// Has reports whether all of v are set.
+func (f Flags) Has(v Flags) bool {
+	return f&v == v
+}
+
+// ErrCode is an error code of RST_STREAM and GOAWAY frames.
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+var errCodeNames = map[ErrCode]string{
+	ErrCodeNo:                 "NO_ERROR",
+	ErrCodeProtocol:           "PROTOCOL_ERROR",
+	ErrCodeInternal:           "INTERNAL_ERROR",
+	ErrCodeFlowControl:        "FLOW_CONTROL_ERROR",
+	ErrCodeSettingsTimeout:    "SETTINGS_TIMEOUT",
+	ErrCodeStreamClosed:       "STREAM_CLOSED",
+	ErrCodeFrameSize:          "FRAME_SIZE_ERROR",
+	ErrCodeRefusedStream:      "REFUSED_STREAM",
+	ErrCodeCancel:             "CANCEL",
+	ErrCodeCompression:        "COMPRESSION_ERROR",
+	ErrCodeConnect:            "CONNECT_ERROR",
+	ErrCodeEnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	ErrCodeInadequateSecurity: "INADEQUATE_SECURITY",
+	ErrCodeHTTP11Required:     "HTTP_1_1_REQUIRED",
+}
+
+func (c ErrCode) String() string {
+	if name, ok := errCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown error code 0x%x", uint32(c))
+}
+
+// ConnectionError is an error that ends the whole connection with a GOAWAY
+// frame carrying its code.
+type ConnectionError ErrCode
+
+func (e ConnectionError) Error() string {
+	return "http2: connection error: " + ErrCode(e).String()
+}
+
+// StreamError is an error that ends one stream with a RST_STREAM frame.
+type StreamError struct {
+	StreamID uint32
+	Code     ErrCode
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error: %v", e.StreamID, e.Code)
+}
+
+// SettingID identifies a parameter of a SETTINGS frame.
+type SettingID uint16
+
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+// Setting is one parameter of a SETTINGS frame.
+type Setting struct {
+	ID    SettingID
+	Value uint32
+}
+
+// FrameHeader is the fixed 9-byte header every frame starts with.
+type FrameHeader struct {
+	Length   uint32
+	Type     FrameType
+	Flags    Flags
+	StreamID uint32
+}
+
+// Frame is a frame as read, with its payload still encoded.
+type Frame struct {
+	FrameHeader
+	// Payload is only valid until the next call to ReadFrame.
+	Payload []byte
+}
+
+// Framer reads and writes frames. Reads and writes may happen concurrently
+// with each other, but not with themselves.
+type Framer struct {
+	r    io.Reader
+	w    io.Writer
+	rbuf []byte
+	wbuf []byte
+	// maxReadSize is the largest frame payload we accept: our
+	// SETTINGS_MAX_FRAME_SIZE.
+	maxReadSize uint32
+}
+
+// NewFramer returns a Framer writing to w and reading from r.
+func NewFramer(w io.Writer, r io.Reader) *Framer {
+	return &Framer{
+		r:           r,
+		w:           w,
+		rbuf:        make([]byte, frameHeaderLen+minMaxFrameSize),
+		maxReadSize: minMaxFrameSize,
+	}
+}
+
+// ReadFrame reads the next frame. A frame larger than allowed is a
+// connection error of type FRAME_SIZE_ERROR.
+func (f *Framer) ReadFrame() (*Frame, error) {
+	hdr := f.rbuf[:frameHeaderLen]
+	if _, err := io.ReadFull(f.r, hdr); err != nil {
+		return nil, err
+	}
+	fr := &Frame{FrameHeader: FrameHeader{
+		Length:   uint32(hdr[0])<<16 | uint32(hdr[1])<<8 | uint32(hdr[2]),
+		Type:     FrameType(hdr[3]),
+		Flags:    Flags(hdr[4]),
+		StreamID: binary.BigEndian.Uint32(hdr[5:]) & (1<<31 - 1),
+	}}
+	if fr.Length > f.maxReadSize {
+		return nil, ConnectionError(ErrCodeFrameSize)
+	}
+
+	if int(fr.Length) > cap(f.rbuf) {
+		f.rbuf = make([]byte, fr.Length)
+	}
+	fr.Payload = f.rbuf[:fr.Length]
+	if _, err := io.ReadFull(f.r, fr.Payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return fr, nil
+}
+
+// WriteFrame writes a frame whose payload is the concatenation of payload.
+func (f *Framer) WriteFrame(t FrameType, flags Flags, streamID uint32, payload ...[]byte) error {
+	length := 0
+	for _, p := range payload {
+		length += len(p)
+	}
+	if length > maxMaxFrameSize {
+		return errors.New("http2: frame too large")
+	}
+
+	f.wbuf = append(f.wbuf[:0],
+		byte(length>>16), byte(length>>8), byte(length),
+		byte(t), byte(flags))
+	f.wbuf = binary.BigEndian.AppendUint32(f.wbuf, streamID&(1<<31-1))
+	for _, p := range payload {
+		f.wbuf = append(f.wbuf, p...)
+	}
+	_, err := f.w.Write(f.wbuf)
+	return err
+}
+
+// WriteSettings writes a SETTINGS frame.
+func (f *Framer) WriteSettings(settings ...Setting) error {
+	payload := make([]byte, 0, 6*len(settings))
+	for _, s := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(s.ID))
+		payload = binary.BigEndian.AppendUint32(payload, s.Value)
+	}
+	return f.WriteFrame(FrameSettings, 0, 0, payload)
+}
+
+// WriteSettingsAck acknowledges the peer's SETTINGS.
+func (f *Framer) WriteSettingsAck() error {
+	return f.WriteFrame(FrameSettings, FlagAck, 0)
+}
+
+// WritePing writes a PING frame, or its acknowledgement.
+func (f *Framer) WritePing(ack bool, data [8]byte) error {
+	var flags Flags
+	if ack {
+		flags = FlagAck
+	}
+	return f.WriteFrame(FramePing, flags, 0, data[:])
+}
+
+// WriteGoAway writes a GOAWAY frame telling the peer that no stream after
+// lastStreamID was or will be processed.
+func (f *Framer) WriteGoAway(lastStreamID uint32, code ErrCode, debug []byte) error {
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID&(1<<31-1))
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	return f.WriteFrame(FrameGoAway, 0, 0, payload, debug)
+}
+
+// WriteRSTStream writes a RST_STREAM frame.
+func (f *Framer) WriteRSTStream(streamID uint32, code ErrCode) error {
+	return f.WriteFrame(FrameRSTStream, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+// WriteWindowUpdate writes a WINDOW_UPDATE frame.
+func (f *Framer) WriteWindowUpdate(streamID, increment uint32) error {
+	return f.WriteFrame(FrameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, increment))
+}
+
+// WriteData writes a DATA frame.
+func (f *Framer) WriteData(streamID uint32, endStream bool, data []byte) error {
+	var flags Flags
+	if endStream {
+		flags = FlagEndStream
+	}
+	return f.WriteFrame(FrameData, flags, streamID, data)
+}
+
+// WriteHeaders writes a header block as a HEADERS frame followed by as many
+// CONTINUATION frames as it takes to stay within maxFrameSize.
+func (f *Framer) WriteHeaders(streamID uint32, endStream bool, block []byte, maxFrameSize uint32) error {
+	t := FrameHeaders
+	var flags Flags
+	if endStream {
+		flags = FlagEndStream
+	}
+	for first := true; first || len(block) > 0; first = false {
+		n := min(len(block), int(maxFrameSize))
+		fragment := block[:n]
+		block = block[n:]
+		if len(block) == 0 {
+			flags |= FlagEndHeaders
+		}
+		if err := f.WriteFrame(t, flags, streamID, fragment); err != nil {
+			return err
+		}
+		t, flags = FrameContinuation, 0
+	}
+	return nil
+}
+
+// dataPayload returns the data of a DATA frame without its padding.
+func (fr *Frame) dataPayload() ([]byte, error) {
+	return fr.unpad(fr.Payload)
+}
+
+// headerBlockFragment returns the header block fragment of a HEADERS frame,
+// without padding and priority fields.
+func (fr *Frame) headerBlockFragment() ([]byte, error) {
+	p, err := fr.unpad(fr.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if fr.Flags.Has(FlagPriority) {
+		if len(p) < 5 {
+			return nil, ConnectionError(ErrCodeFrameSize)
+		}
+		// A stream can't depend on itself (RFC 9113 section 5.3.1).
+		if binary.BigEndian.Uint32(p)&(1<<31-1) == fr.StreamID {
+			return nil, StreamError{fr.StreamID, ErrCodeProtocol}
+		}
+		p = p[5:]
+	}
+	return p, nil
+}
+
+func (fr *Frame) unpad(p []byte) ([]byte, error) {
+	if !fr.Flags.Has(FlagPadded) {
+		return p, nil
+	}
+	if len(p) < 1 {
+		return nil, ConnectionError(ErrCodeFrameSize)
+	}
+	padLength := int(p[0])
+	if padLength >= len(p) {
+		return nil, ConnectionError(ErrCodeProtocol)
+	}
+	return p[1 : len(p)-padLength], nil
+}
+
+// settings parses the parameters of a SETTINGS frame.
+func (fr *Frame) settings() ([]Setting, error) {
+	if fr.StreamID != 0 {
+		return nil, ConnectionError(ErrCodeProtocol)
+	}
+	if fr.Flags.Has(FlagAck) {
+		if len(fr.Payload) != 0 {
+			return nil, ConnectionError(ErrCodeFrameSize)
+		}
+		return nil, nil
+	}
+	if len(fr.Payload)%6 != 0 {
+		return nil, ConnectionError(ErrCodeFrameSize)
+	}
+
+	settings := make([]Setting, 0, len(fr.Payload)/6)
+	for p := fr.Payload; len(p) > 0; p = p[6:] {
+		settings = append(settings, Setting{
+			ID:    SettingID(binary.BigEndian.Uint16(p)),
+			Value: binary.BigEndian.Uint32(p[2:]),
+		})
+	}
+	return settings, nil
+}
+
+// ParseSettings parses the payload of a SETTINGS frame, as carried base64url
+// encoded in the HTTP2-Settings header of an h2c upgrade.
+func ParseSettings(payload []byte) ([]Setting, error) {
+	fr := &Frame{FrameHeader: FrameHeader{Type: FrameSettings}, Payload: payload}
+	return fr.settings()
+}
+
+// check validates a setting's value (RFC 9113 section 6.5.2).
+func (s Setting) check() error {
+	switch s.ID {
+	case SettingEnablePush:
+		if s.Value > 1 {
+			return ConnectionError(ErrCodeProtocol)
+		}
+	case SettingInitialWindowSize:
+		if s.Value > maxWindowSize {
+			return ConnectionError(ErrCodeFlowControl)
+		}
+	case SettingMaxFrameSize:
+		if s.Value < minMaxFrameSize || s.Value > maxMaxFrameSize {
+			return ConnectionError(ErrCodeProtocol)
+		}
+	}
+	return nil
+}
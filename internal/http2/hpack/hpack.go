@@ -0,0 +1,297 @@
+// Package hpack implements HPACK (RFC 7541), the header compression of
+// HTTP/2.
+package hpack
+
+import (
+	"errors"
+)
+
+// DefaultTableSize is the dynamic table size both ends start with.
+const DefaultTableSize = 4096
+
+var (
+	// ErrInvalid is returned for a header block that can't be decoded. It
+	// is a connection error of type COMPRESSION_ERROR in HTTP/2.
+	ErrInvalid = errors.New("hpack: invalid header block")
+	// ErrHeaderListTooLarge is returned when a decoded header list is over
+	// the limit set with SetMaxHeaderListSize. The block is still decoded
+	// in full, so the decoder stays in step with the encoder.
+	ErrHeaderListTooLarge = errors.New("hpack: header list too large")
+)
+
+// maxInt caps decoded integers well clear of overflowing anything.
+const maxInt = 1<<32 - 1
+
+// appendInt appends i in the prefix-integer representation of RFC 7541
+// section 5.1. first holds the bits above the n-bit prefix.
+func appendInt(dst []byte, first byte, n uint, i uint64) []byte {
+	limit := uint64(1)<<n - 1
+	if i < limit {
+		return append(dst, first|byte(i))
+	}
+	dst = append(dst, first|byte(limit))
+	i -= limit
+	for i >= 128 {
+		dst = append(dst, byte(i&0x7f)|0x80)
+		i >>= 7
+	}
+	return append(dst, byte(i))
+}
+
+// readInt reads an integer with an n-bit prefix from the start of p.
+func readInt(p []byte, n uint) (uint64, []byte, error) {
+	if len(p) == 0 {
+		return 0, nil, ErrInvalid
+	}
+	limit := uint64(1)<<n - 1
+	i := uint64(p[0]) & limit
+	p = p[1:]
+	if i < limit {
+		return i, p, nil
+	}
+
+	var shift uint
+	for len(p) > 0 {
+		b := p[0]
+		p = p[1:]
+		i += uint64(b&0x7f) << shift
+		if i > maxInt {
+			return 0, nil, ErrInvalid
+		}
+		if b&0x80 == 0 {
+			return i, p, nil
+		}
+		shift += 7
+	}
+	return 0, nil, ErrInvalid
+}
+
+// appendString appends a string literal, Huffman-coded unless that is longer.
+func appendString(dst []byte, s string) []byte {
+	if n := HuffmanEncodedLen(s); n <= len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(n))
+		return AppendHuffman(dst, s)
+	}
+	dst = appendInt(dst, 0, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+func readString(p []byte) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", nil, ErrInvalid
+	}
+	huffman := p[0]&0x80 != 0
+	n, p, err := readInt(p, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(p)) < n {
+		return "", nil, ErrInvalid
+	}
+	raw, p := p[:n], p[n:]
+	if !huffman {
+		return string(raw), p, nil
+	}
+	s, err := HuffmanDecode(raw)
+	if err != nil {
+		return "", nil, ErrInvalid
+	}
+	return s, p, nil
+}
+
+// Decoder decodes the header blocks of one direction of a connection.
+type Decoder struct {
+	table dynamicTable
+	// maxTableSize is the largest table size the encoder may switch to: the
+	// SETTINGS_HEADER_TABLE_SIZE we advertised.
+	maxTableSize uint32
+	maxListSize  uint32
+}
+
+// NewDecoder returns a Decoder whose encoder may use a dynamic table of up
+// to maxTableSize bytes.
+func NewDecoder(maxTableSize uint32) *Decoder {
+	return &Decoder{
+		table:        dynamicTable{maxSize: maxTableSize},
+		maxTableSize: maxTableSize,
+	}
+}
+
+// SetMaxHeaderListSize limits the size of a decoded header list, counted as
+// for the dynamic table. 0 means no limit.
+func (d *Decoder) SetMaxHeaderListSize(n uint32) {
+	d.maxListSize = n
+}
+
+// Decode decodes a complete header block.
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	var fields []HeaderField
+	var listSize uint32
+	tooLarge := false
+	emit := func(f HeaderField) {
+		listSize += f.Size()
+		if d.maxListSize > 0 && listSize > d.maxListSize {
+			tooLarge = true
+		}
+		if !tooLarge {
+			fields = append(fields, f)
+		}
+	}
+
+	sawField := false
+	for p := block; len(p) > 0; {
+		b := p[0]
+		var err error
+		switch {
+		case b&0x80 != 0:
+			// Indexed field (section 6.1).
+			var i uint64
+			if i, p, err = readInt(p, 7); err != nil {
+				return nil, err
+			}
+			f, ok := d.at(i)
+			if !ok {
+				return nil, ErrInvalid
+			}
+			emit(f)
+			sawField = true
+
+		case b&0xc0 == 0x40:
+			// Literal with incremental indexing (section 6.2.1).
+			var f HeaderField
+			if f, p, err = d.readLiteral(p, 6); err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+			emit(f)
+			sawField = true
+
+		case b&0xe0 == 0x20:
+			// Dynamic table size update (section 6.3), only allowed at
+			// the start of a block.
+			if sawField {
+				return nil, ErrInvalid
+			}
+			var size uint64
+			if size, p, err = readInt(p, 5); err != nil {
+				return nil, err
+			}
+			if size > uint64(d.maxTableSize) {
+				return nil, ErrInvalid
+			}
+			d.table.setMaxSize(uint32(size))
+
+		default:
+			// Literal without indexing (0000) or never indexed (0001).
+			var f HeaderField
+			if f, p, err = d.readLiteral(p, 4); err != nil {
+				return nil, err
+			}
+			f.Sensitive = b&0x10 != 0
+			emit(f)
+			sawField = true
+		}
+	}
+
+	if tooLarge {
+		return nil, ErrHeaderListTooLarge
+	}
+	return fields, nil
+}
+
+// at returns the field at index i of the combined static and dynamic
+// index space.
+func (d *Decoder) at(i uint64) (HeaderField, bool) {
+	switch {
+	case i == 0:
+		return HeaderField{}, false
+	case i <= uint64(len(staticTable)):
+		return staticTable[i-1], true
+	case i-uint64(len(staticTable)) <= uint64(d.table.len()):
+		return d.table.get(int(i) - len(staticTable)), true
+	default:
+		return HeaderField{}, false
+	}
+}
+
+// readLiteral reads a literal field whose name index has an n-bit prefix.
+func (d *Decoder) readLiteral(p []byte, n uint) (HeaderField, []byte, error) {
+	nameIndex, p, err := readInt(p, n)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	var f HeaderField
+	if nameIndex > 0 {
+		named, ok := d.at(nameIndex)
+		if !ok {
+			return HeaderField{}, nil, ErrInvalid
+		}
+		f.Name = named.Name
+	} else if f.Name, p, err = readString(p); err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	if f.Value, p, err = readString(p); err != nil {
+		return HeaderField{}, nil, err
+	}
+	return f, p, nil
+}
+
+// Encoder encodes the header blocks of one direction of a connection.
+type Encoder struct {
+	table dynamicTable
+	// minSize is the smallest the table size went since the last block,
+	// and sizeChanged whether it changed at all; the decoder must hear of
+	// both (RFC 7541 section 4.2).
+	minSize     uint32
+	sizeChanged bool
+}
+
+// NewEncoder returns an Encoder using a table of DefaultTableSize.
+func NewEncoder() *Encoder {
+	return &Encoder{table: dynamicTable{maxSize: DefaultTableSize}}
+}
+
+// SetMaxTableSize changes the dynamic table size, e.g. when the peer sends
+// SETTINGS_HEADER_TABLE_SIZE. The change is signalled at the start of the
+// next block.
+func (e *Encoder) SetMaxTableSize(size uint32) {
+	if !e.sizeChanged || size < e.minSize {
+		e.minSize = size
+	}
+	e.sizeChanged = true
+	e.table.setMaxSize(size)
+}
+
+// AppendBlock appends the header block encoding fields to dst.
+func (e *Encoder) AppendBlock(dst []byte, fields ...HeaderField) []byte {
+	if e.sizeChanged {
+		if e.minSize < e.table.maxSize {
+			dst = appendInt(dst, 0x20, 5, uint64(e.minSize))
+		}
+		dst = appendInt(dst, 0x20, 5, uint64(e.table.maxSize))
+		e.sizeChanged = false
+	}
+
+	for _, f := range fields {
+		index, nameOnly := e.table.lookup(f)
+		switch {
+		case index != 0 && !nameOnly && !f.Sensitive:
+			dst = appendInt(dst, 0x80, 7, uint64(index))
+			continue
+		case f.Sensitive:
+			dst = appendInt(dst, 0x10, 4, uint64(index))
+		case f.Size() <= e.table.maxSize:
+			dst = appendInt(dst, 0x40, 6, uint64(index))
+			e.table.add(f)
+		default:
+			dst = appendInt(dst, 0, 4, uint64(index))
+		}
+		if index == 0 {
+			dst = appendString(dst, f.Name)
+		}
+		dst = appendString(dst, f.Value)
+	}
+	return dst
+}
@@ -0,0 +1,174 @@
+package hpack
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+func fields(pairs ...string) []HeaderField {
+	var fs []HeaderField
+	for i := 0; i < len(pairs); i += 2 {
+		fs = append(fs, HeaderField{Name: pairs[i], Value: pairs[i+1]})
+	}
+	return fs
+}
+
+// The request examples of RFC 7541 Appendix C.4, with Huffman coding.
+var requestExamples = []struct {
+	fields []HeaderField
+	block  string
+}{
+	{
+		fields(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com"),
+		"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+	},
+	{
+		fields(":method", "GET", ":scheme", "http", ":path", "/", ":authority", "www.example.com", "cache-control", "no-cache"),
+		"8286 84be 5886 a8eb 1064 9cbf",
+	},
+	{
+		fields(":method", "GET", ":scheme", "https", ":path", "/index.html", ":authority", "www.example.com", "custom-key", "custom-value"),
+		"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+	},
+}
+
+// The response examples of RFC 7541 Appendix C.6, with a 256-byte table.
+var responseExamples = []struct {
+	fields []HeaderField
+	block  string
+}{
+	{
+		fields(":status", "302", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:21 GMT", "location", "https://www.example.com"),
+		"4882 6402 5885 aec3 771a 4b61 96d0 7abe 9410 54d4 44a8 2005 9504 0b81 66e0 82a6 2d1b ff6e 919d 29ad 1718 63c7 8f0b 97c8 e9ae 82ae 43d3",
+	},
+	{
+		fields(":status", "307", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:21 GMT", "location", "https://www.example.com"),
+		"4883 640e ffc1 c0bf",
+	},
+	{
+		fields(":status", "200", "cache-control", "private", "date", "Mon, 21 Oct 2013 20:13:22 GMT", "location", "https://www.example.com",
+			"content-encoding", "gzip", "set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"),
+		"88c1 6196 d07a be94 1054 d444 a820 0595 040b 8166 e084 a62d 1bff c05a 839b d9ab 77ad 94e7 821d d7f2 e6c7 b335 dfdf cd5b 3960 d5af 2708 7f36 72c1 ab27 0fb5 291f 9587 3160 65c0 03ed 4ee5 b106 3d50 07",
+	},
+}
+
+func TestRFCRequestExamples(t *testing.T) {
+	enc := NewEncoder()
+	dec := NewDecoder(DefaultTableSize)
+	for _, ex := range requestExamples {
+		block := unhex(t, ex.block)
+		assert.Equal(t, block, enc.AppendBlock(nil, ex.fields...))
+
+		got, err := dec.Decode(block)
+		require.NoError(t, err)
+		assert.Equal(t, ex.fields, got)
+	}
+	assert.Equal(t, uint32(164), dec.table.size)
+}
+
+func TestRFCResponseExamples(t *testing.T) {
+	enc := &Encoder{table: dynamicTable{maxSize: 256}}
+	dec := NewDecoder(256)
+	for _, ex := range responseExamples {
+		block := unhex(t, ex.block)
+		assert.Equal(t, block, enc.AppendBlock(nil, ex.fields...))
+
+		got, err := dec.Decode(block)
+		require.NoError(t, err)
+		assert.Equal(t, ex.fields, got)
+	}
+	// Evictions leave the last three entries of section C.6.3.
+	assert.Equal(t, 3, dec.table.len())
+	assert.Equal(t, uint32(215), dec.table.size)
+}
+
+func TestHuffmanRoundTrip(t *testing.T) {
+	for _, s := range []string{"", "a", "www.example.com", "no-cache", "\x00\xff binary \x7f", strings.Repeat("z", 300)} {
+		encoded := AppendHuffman(nil, s)
+		assert.Len(t, encoded, HuffmanEncodedLen(s))
+		decoded, err := HuffmanDecode(encoded)
+		require.NoError(t, err)
+		assert.Equal(t, s, decoded)
+	}
+}
+
+func TestHuffmanInvalidPadding(t *testing.T) {
+	// "a" is 00011; padding with zeros instead of ones is invalid.
+	_, err := HuffmanDecode([]byte{0x18})
+	assert.ErrorIs(t, err, ErrInvalidHuffman)
+
+	// A whole byte of padding is too much.
+	_, err = HuffmanDecode([]byte{0x1f, 0xff})
+	assert.ErrorIs(t, err, ErrInvalidHuffman)
+
+	// The end-of-string symbol may not appear.
+	_, err = HuffmanDecode([]byte{0xff, 0xff, 0xff, 0xff})
+	assert.ErrorIs(t, err, ErrInvalidHuffman)
+}
+
+func TestSensitiveFieldsNeverIndexed(t *testing.T) {
+	enc := NewEncoder()
+	block := enc.AppendBlock(nil, HeaderField{Name: "authorization", Value: "secret", Sensitive: true})
+	assert.Equal(t, byte(0x10), block[0]&0xf0)
+	assert.Equal(t, 0, enc.table.len())
+
+	got, err := NewDecoder(DefaultTableSize).Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, []HeaderField{{Name: "authorization", Value: "secret", Sensitive: true}}, got)
+}
+
+func TestTableSizeUpdate(t *testing.T) {
+	enc := NewEncoder()
+	dec := NewDecoder(DefaultTableSize)
+	_, err := dec.Decode(enc.AppendBlock(nil, fields("x-a", "1")...))
+	require.NoError(t, err)
+
+	// Shrinking to 0 and growing back is signalled as both sizes, which
+	// empties the decoder's table.
+	enc.SetMaxTableSize(0)
+	enc.SetMaxTableSize(1024)
+	block := enc.AppendBlock(nil, fields("x-b", "2")...)
+	assert.Equal(t, []byte{0x20, 0x3f, 0xe1, 0x07}, block[:4])
+	got, err := dec.Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, fields("x-b", "2"), got)
+	assert.Equal(t, 1, dec.table.len())
+	assert.Equal(t, uint32(1024), dec.table.maxSize)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for name, block := range map[string][]byte{
+		"index zero":               {0x80},
+		"index out of range":       {0xff, 0x00},
+		"truncated integer":        {0xff, 0x80},
+		"truncated string":         {0x40, 0x05, 'a'},
+		"size update above limit":  {0x3f, 0xe2, 0x1f},
+		"size update after fields": {0x82, 0x20},
+		"integer overflow":         {0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+	} {
+		_, err := NewDecoder(DefaultTableSize).Decode(block)
+		assert.ErrorIs(t, err, ErrInvalid, name)
+	}
+}
+
+func TestMaxHeaderListSize(t *testing.T) {
+	block := NewEncoder().AppendBlock(nil, fields("x-big", strings.Repeat("v", 100))...)
+	dec := NewDecoder(DefaultTableSize)
+	dec.SetMaxHeaderListSize(100)
+	_, err := dec.Decode(block)
+	assert.ErrorIs(t, err, ErrHeaderListTooLarge)
+
+	// The field still went into the table, in step with the encoder.
+	assert.Equal(t, 1, dec.table.len())
+}
@@ -0,0 +1,95 @@
+package hpack
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrInvalidHuffman is returned for a Huffman-coded string that doesn't
+// decode: an unknown code, the end-of-string symbol, or bad padding.
+var ErrInvalidHuffman = errors.New("hpack: invalid Huffman-encoded data")
+
+// huffmanNode is a node of the decoding tree. Leaves have no children.
+type huffmanNode struct {
+	children [2]*huffmanNode
+	sym      byte
+}
+
+var (
+	huffmanRoot     *huffmanNode
+	huffmanRootOnce sync.Once
+)
+
+func buildHuffmanTree() {
+	huffmanRoot = &huffmanNode{}
+	for sym, code := range huffmanCodes {
+		n := huffmanRoot
+		for i := int(huffmanCodeLens[sym]) - 1; i >= 0; i-- {
+			bit := (code >> i) & 1
+			if n.children[bit] == nil {
+				n.children[bit] = &huffmanNode{}
+			}
+			n = n.children[bit]
+		}
+		n.sym = byte(sym)
+	}
+}
+
+// HuffmanEncodedLen returns the length of s once Huffman-encoded.
+func HuffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLens[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+// AppendHuffman appends the Huffman encoding of s to dst.
+func AppendHuffman(dst []byte, s string) []byte {
+	var acc uint64 // pending bits, right-aligned
+	n := 0         // how many bits of acc are pending
+	for i := 0; i < len(s); i++ {
+		acc = acc<<huffmanCodeLens[s[i]] | uint64(huffmanCodes[s[i]])
+		n += int(huffmanCodeLens[s[i]])
+		for n >= 8 {
+			n -= 8
+			dst = append(dst, byte(acc>>n))
+		}
+	}
+	if n > 0 {
+		// Pad with the high bits of the end-of-string code, all ones.
+		dst = append(dst, byte(acc<<(8-n))|byte(0xff>>n))
+	}
+	return dst
+}
+
+// HuffmanDecode decodes Huffman-encoded data.
+func HuffmanDecode(data []byte) (string, error) {
+	huffmanRootOnce.Do(buildHuffmanTree)
+
+	out := make([]byte, 0, len(data)*8/5)
+	n := huffmanRoot
+	depth := 0      // bits read since the last symbol
+	allOnes := true // whether those bits were all ones
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> i) & 1
+			n = n.children[bit]
+			if n == nil {
+				// Only the end-of-string code runs off the tree.
+				return "", ErrInvalidHuffman
+			}
+			depth++
+			allOnes = allOnes && bit == 1
+			if n.children[0] == nil && n.children[1] == nil {
+				out = append(out, n.sym)
+				n, depth, allOnes = huffmanRoot, 0, true
+			}
+		}
+	}
+	// What's left must be padding: fewer than 8 bits, all ones.
+	if depth > 7 || !allOnes {
+		return "", ErrInvalidHuffman
+	}
+	return string(out), nil
+}
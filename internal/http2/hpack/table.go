@@ -0,0 +1,153 @@
+package hpack
+
+// HeaderField is a name-value pair in a header list. Names are lowercase.
+type HeaderField struct {
+	Name, Value string
+	// Sensitive marks a field that must never be added to a compression
+	// table, such as a cookie or credentials (RFC 7541 section 7.1.3).
+	Sensitive bool
+}
+
+// Size is the size of the field as counted against table sizes: its name
+// and value plus 32 bytes of overhead (RFC 7541 section 4.1).
+func (f HeaderField) Size() uint32 {
+	return uint32(len(f.Name) + len(f.Value) + 32)
+}
+
+// staticTable is the static table of RFC 7541 Appendix A. Index 1 is
+// staticTable[0].
+var staticTable = [...]HeaderField{
+	{Name: ":authority"},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":path", Value: "/"},
+	{Name: ":path", Value: "/index.html"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-charset"},
+	{Name: "accept-encoding", Value: "gzip, deflate"},
+	{Name: "accept-language"},
+	{Name: "accept-ranges"},
+	{Name: "accept"},
+	{Name: "access-control-allow-origin"},
+	{Name: "age"},
+	{Name: "allow"},
+	{Name: "authorization"},
+	{Name: "cache-control"},
+	{Name: "content-disposition"},
+	{Name: "content-encoding"},
+	{Name: "content-language"},
+	{Name: "content-length"},
+	{Name: "content-location"},
+	{Name: "content-range"},
+	{Name: "content-type"},
+	{Name: "cookie"},
+	{Name: "date"},
+	{Name: "etag"},
+	{Name: "expect"},
+	{Name: "expires"},
+	{Name: "from"},
+	{Name: "host"},
+	{Name: "if-match"},
+	{Name: "if-modified-since"},
+	{Name: "if-none-match"},
+	{Name: "if-range"},
+	{Name: "if-unmodified-since"},
+	{Name: "last-modified"},
+	{Name: "link"},
+	{Name: "location"},
+	{Name: "max-forwards"},
+	{Name: "proxy-authenticate"},
+	{Name: "proxy-authorization"},
+	{Name: "range"},
+	{Name: "referer"},
+	{Name: "refresh"},
+	{Name: "retry-after"},
+	{Name: "server"},
+	{Name: "set-cookie"},
+	{Name: "strict-transport-security"},
+	{Name: "transfer-encoding"},
+	{Name: "user-agent"},
+	{Name: "vary"},
+	{Name: "via"},
+	{Name: "www-authenticate"},
+}
+
+// dynamicTable is the table of recently sent fields that both ends keep in
+// step. New entries go in front and have the lowest index; the oldest are
+// evicted to keep the total size within maxSize.
+type dynamicTable struct {
+	// entries holds the fields oldest first, so that adding one is an
+	// append.
+	entries []HeaderField
+	size    uint32
+	maxSize uint32
+}
+
+func (t *dynamicTable) len() int {
+	return len(t.entries)
+}
+
+// get returns the field at 1-based index i of the dynamic table.
+func (t *dynamicTable) get(i int) HeaderField {
+	return t.entries[len(t.entries)-i]
+}
+
+func (t *dynamicTable) add(f HeaderField) {
+	t.entries = append(t.entries, f)
+	t.size += f.Size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(size uint32) {
+	t.maxSize = size
+	t.evict()
+}
+
+func (t *dynamicTable) evict() {
+	n := 0
+	for t.size > t.maxSize && n < len(t.entries) {
+		t.size -= t.entries[n].Size()
+		n++
+	}
+	if n > 0 {
+		t.entries = append(t.entries[:0], t.entries[n:]...)
+	}
+}
+
+// lookup returns the index, in the combined index space, of a field equal to
+// f, or failing that of one with the same name. nameOnly reports which.
+func (t *dynamicTable) lookup(f HeaderField) (index int, nameOnly bool) {
+	for i, sf := range staticTable {
+		if sf.Name != f.Name {
+			continue
+		}
+		if sf.Value == f.Value {
+			return i + 1, false
+		}
+		if index == 0 {
+			index = i + 1
+		}
+	}
+	for i := len(t.entries) - 1; i >= 0; i-- {
+		df := t.entries[i]
+		if df.Name != f.Name {
+			continue
+		}
+		idx := len(staticTable) + len(t.entries) - i
+		if df.Value == f.Value {
+			return idx, false
+		}
+		if index == 0 {
+			index = idx
+		}
+	}
+	return index, index != 0
+}
@@ -0,0 +1,823 @@
+// Package http2 serves HTTP/2 (RFC 9113) with the same request.Request and
+// response.Writer that HTTP/1.1 handlers use, so a handler can't tell which
+// protocol it is answering.
+package http2
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"chillhttp/internal/http2/hpack"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+)
+
+const (
+	// DefaultMaxConcurrentStreams is the SETTINGS_MAX_CONCURRENT_STREAMS we
+	// advertise when Server doesn't set one.
+	DefaultMaxConcurrentStreams = 100
+	// DefaultMaxHeaderListSize is the SETTINGS_MAX_HEADER_LIST_SIZE we
+	// advertise when Server doesn't set one, the same 1 MiB the HTTP/1.1
+	// parser allows a header line.
+	DefaultMaxHeaderListSize = 1 << 20
+
+	// streamWindowSize and connWindowSize are the receive windows we give
+	// each stream and the connection, so uploads aren't throttled to 64 KiB
+	// per round trip.
+	streamWindowSize = 1 << 18
+	connWindowSize   = 1 << 20
+
+	// idleTimeout bounds how long a connection may sit without a frame.
+	idleTimeout = 2 * time.Minute
+)
+
+// errClientDisconnected is returned to writers once the connection is gone,
+// and errStreamReset once their stream is.
+var (
+	errClientDisconnected = errors.New("http2: client disconnected")
+	errStreamReset        = errors.New("http2: stream reset")
+)
+
+// Handler answers a request; it has the same shape as server.Handler.
+type Handler func(w *response.Writer, req *request.Request)
+
+// Server holds the configuration shared by the HTTP/2 connections of a
+// server.
+type Server struct {
+	Handler Handler
+	// RequestOptions configures how request bodies are read.
+	RequestOptions request.Options
+	// MaxConcurrentStreams limits how many requests a client may have in
+	// flight on one connection. 0 means DefaultMaxConcurrentStreams.
+	MaxConcurrentStreams uint32
+	// MaxHeaderListSize limits the size of a request's header fields. 0
+	// means DefaultMaxHeaderListSize.
+	MaxHeaderListSize uint32
+
+	mu       sync.Mutex
+	conns    map[*serverConn]struct{}
+	shutdown bool
+}
+
+// ConnOptions describes how a connection came to speak HTTP/2.
+type ConnOptions struct {
+	// TLS is the state of a connection that negotiated "h2" with ALPN.
+	TLS *tls.ConnectionState
+	// Reader, if set, is read from instead of the connection, e.g. to
+	// replay bytes already read while sniffing for the client preface.
+	Reader io.Reader
+	// PrefaceRead is set when the client preface has already been read.
+	PrefaceRead bool
+	// Upgrade is the request of an HTTP/1.1 connection upgraded with
+	// "Upgrade: h2c", which is answered on stream 1. Its body must have been
+	// read. ServeConn releases it.
+	Upgrade *request.Request
+	// UpgradeSettings holds the client's settings from the HTTP2-Settings
+	// header of the upgrade request.
+	UpgradeSettings []Setting
+	// Context is the parent of the contexts of the connection's requests,
+	// which are also canceled when their stream is reset or the connection
+	// closes. Nil means context.Background().
+	Context context.Context
+}
+
+// ServeConn serves HTTP/2 on conn until the client goes away, the
+// connection fails or the server shuts down. It doesn't close conn.
+func (s *Server) ServeConn(conn net.Conn, opts ConnOptions) {
+	r := opts.Reader
+	if r == nil {
+		r = conn
+	}
+	sc := &serverConn{
+		srv:               s,
+		conn:              conn,
+		opts:              opts,
+		framer:            NewFramer(conn, r),
+		dec:               hpack.NewDecoder(hpack.DefaultTableSize),
+		enc:               hpack.NewEncoder(),
+		streams:           make(map[uint32]*stream),
+		sendWindow:        defaultWindowSize,
+		initialSendWindow: defaultWindowSize,
+		maxFrameSize:      minMaxFrameSize,
+		recvWindow:        connWindowSize,
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	parent := opts.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	sc.ctx, sc.cancel = context.WithCancel(parent)
+	sc.dec.SetMaxHeaderListSize(s.maxHeaderListSize())
+
+	if !s.track(sc) {
+		return
+	}
+	defer s.untrack(sc)
+	sc.serve()
+}
+
+func (s *Server) maxConcurrentStreams() uint32 {
+	if s.MaxConcurrentStreams == 0 {
+		return DefaultMaxConcurrentStreams
+	}
+	return s.MaxConcurrentStreams
+}
+
+func (s *Server) maxHeaderListSize() uint32 {
+	if s.MaxHeaderListSize == 0 {
+		return DefaultMaxHeaderListSize
+	}
+	return s.MaxHeaderListSize
+}
+
+func (s *Server) track(sc *serverConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[*serverConn]struct{})
+	}
+	s.conns[sc] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(sc *serverConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, sc)
+}
+
+// Shutdown sends every connection a GOAWAY frame: requests in flight are
+// finished, then the connections close. New connections are refused.
+func (s *Server) Shutdown() {
+	s.mu.Lock()
+	s.shutdown = true
+	conns := make([]*serverConn, 0, len(s.conns))
+	for sc := range s.conns {
+		conns = append(conns, sc)
+	}
+	s.mu.Unlock()
+
+	for _, sc := range conns {
+		sc.goAway(ErrCodeNo)
+	}
+}
+
+// serverConn is one HTTP/2 connection. Frames are read by the serve
+// goroutine; each request runs its handler in a goroutine of its own, and
+// frames are written by whichever goroutine has something to send.
+type serverConn struct {
+	srv    *Server
+	conn   net.Conn
+	opts   ConnOptions
+	framer *Framer
+	// ctx is canceled when the connection closes.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// dec is only used by the serve goroutine.
+	dec *hpack.Decoder
+
+	// wmu serializes writes, which must not interleave: a header block in
+	// particular has to go out in consecutive frames, in the order it was
+	// encoded. A goroutine holding both locks takes wmu first.
+	wmu  sync.Mutex
+	enc  *hpack.Encoder
+	hbuf []byte
+
+	mu   sync.Mutex
+	cond *sync.Cond // broadcast whenever a window or stream state changes
+	// streams holds the streams whose handlers are running.
+	streams map[uint32]*stream
+	// lastStreamID is the highest stream the client opened.
+	lastStreamID uint32
+	// sendWindow is how much DATA we may still send on the connection, and
+	// initialSendWindow the window new streams start with.
+	sendWindow        int64
+	initialSendWindow int64
+	// maxFrameSize is the client's SETTINGS_MAX_FRAME_SIZE.
+	maxFrameSize uint32
+	// recvWindow is how much DATA the client may still send on the
+	// connection, and recvUnacked how much of what it sent was consumed
+	// without a WINDOW_UPDATE yet.
+	recvWindow  int64
+	recvUnacked int64
+	goingAway   bool
+	closed      bool
+
+	// Header block being assembled from HEADERS and CONTINUATION frames.
+	headerStreamID  uint32
+	headerEndStream bool
+	headerBlock     []byte
+}
+
+func (sc *serverConn) serve() {
+	defer sc.close()
+
+	settings := []Setting{
+		{SettingMaxConcurrentStreams, sc.srv.maxConcurrentStreams()},
+		{SettingInitialWindowSize, streamWindowSize},
+		{SettingMaxHeaderListSize, sc.srv.maxHeaderListSize()},
+		{SettingEnablePush, 0},
+	}
+	err := sc.write(func(f *Framer) error {
+		if err := f.WriteSettings(settings...); err != nil {
+			return err
+		}
+		return f.WriteWindowUpdate(0, connWindowSize-defaultWindowSize)
+	})
+	if err != nil {
+		return
+	}
+
+	if sc.opts.Upgrade != nil {
+		// Answer the upgraded request while the client sends its preface.
+		if err := sc.applySettings(sc.opts.UpgradeSettings); err != nil {
+			sc.opts.Upgrade.Release()
+			sc.fail(err)
+			return
+		}
+		sc.startUpgraded(sc.opts.Upgrade)
+	}
+
+	sc.setReadDeadline()
+	if !sc.opts.PrefaceRead {
+		preface := make([]byte, len(ClientPreface))
+		if _, err := io.ReadFull(sc.framer.r, preface); err != nil || string(preface) != ClientPreface {
+			sc.fail(ConnectionError(ErrCodeProtocol))
+			return
+		}
+	}
+
+	// The preface ends with a SETTINGS frame.
+	first := true
+	for {
+		sc.setReadDeadline()
+		fr, err := sc.framer.ReadFrame()
+		if err != nil {
+			var connErr ConnectionError
+			if errors.As(err, &connErr) {
+				sc.fail(err)
+			}
+			return
+		}
+		if first && (fr.Type != FrameSettings || fr.Flags.Has(FlagAck)) {
+			sc.fail(ConnectionError(ErrCodeProtocol))
+			return
+		}
+		first = false
+
+		if err := sc.processFrame(fr); err != nil {
+			var streamErr StreamError
+			if errors.As(err, &streamErr) {
+				sc.resetStream(streamErr)
+				continue
+			}
+			sc.fail(err)
+			return
+		}
+	}
+}
+
+// setReadDeadline times the connection out after idleTimeout without
+// requests; while handlers run it may stay quiet as long as they take. A
+// connection going away with nothing left to finish is timed out at once.
+func (sc *serverConn) setReadDeadline() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	switch {
+	case len(sc.streams) > 0:
+		sc.conn.SetReadDeadline(time.Time{})
+	case sc.goingAway:
+		sc.conn.SetReadDeadline(time.Now())
+	default:
+		sc.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	}
+}
+
+// write runs fn with exclusive use of the framer's writing side.
+func (sc *serverConn) write(fn func(f *Framer) error) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	sc.conn.SetWriteDeadline(time.Now().Add(idleTimeout))
+	return fn(sc.framer)
+}
+
+// fail ends the connection because of err, telling the client why.
+func (sc *serverConn) fail(err error) {
+	code := ErrCodeInternal
+	var connErr ConnectionError
+	if errors.As(err, &connErr) {
+		code = ErrCode(connErr)
+	}
+	sc.goAway(code)
+}
+
+// goAway sends a GOAWAY frame. No new streams are accepted afterwards; with
+// ErrCodeNo the ones in flight are finished first.
+func (sc *serverConn) goAway(code ErrCode) {
+	sc.mu.Lock()
+	if sc.goingAway && code == ErrCodeNo {
+		sc.mu.Unlock()
+		return
+	}
+	sc.goingAway = true
+	lastStreamID := sc.lastStreamID
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+
+	sc.write(func(f *Framer) error {
+		return f.WriteGoAway(lastStreamID, code, nil)
+	})
+	if code != ErrCodeNo || idle {
+		// Unblock the serve goroutine's read so it cleans up.
+		sc.conn.SetReadDeadline(time.Now())
+	}
+}
+
+// close marks the connection closed, waking any handler waiting to write.
+func (sc *serverConn) close() {
+	sc.cancel()
+	sc.mu.Lock()
+	sc.closed = true
+	for _, st := range sc.streams {
+		if st.bodyErr == nil {
+			st.bodyErr = errClientDisconnected
+		}
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+}
+
+// resetStream ends a stream with a RST_STREAM frame.
+func (sc *serverConn) resetStream(e StreamError) {
+	sc.mu.Lock()
+	if st, ok := sc.streams[e.StreamID]; ok {
+		st.resetLocked()
+	}
+	sc.mu.Unlock()
+
+	sc.write(func(f *Framer) error {
+		return f.WriteRSTStream(e.StreamID, e.Code)
+	})
+}
+
+func (sc *serverConn) processFrame(fr *Frame) error {
+	if sc.headerStreamID != 0 && (fr.Type != FrameContinuation || fr.StreamID != sc.headerStreamID) {
+		// Nothing may come between the frames of a header block.
+		return ConnectionError(ErrCodeProtocol)
+	}
+
+	switch fr.Type {
+	case FrameData:
+		return sc.processData(fr)
+	case FrameHeaders:
+		return sc.processHeaders(fr)
+	case FrameContinuation:
+		return sc.processContinuation(fr)
+	case FramePriority:
+		// Priorities are advisory (RFC 9113 section 5.3.2) and we don't
+		// schedule by them, but the frame must still be well-formed.
+		if fr.StreamID == 0 {
+			return ConnectionError(ErrCodeProtocol)
+		}
+		if len(fr.Payload) != 5 {
+			return StreamError{fr.StreamID, ErrCodeFrameSize}
+		}
+		return nil
+	case FrameRSTStream:
+		return sc.processRSTStream(fr)
+	case FrameSettings:
+		return sc.processSettings(fr)
+	case FramePushPromise:
+		// Clients can't push.
+		return ConnectionError(ErrCodeProtocol)
+	case FramePing:
+		return sc.processPing(fr)
+	case FrameGoAway:
+		if fr.StreamID != 0 {
+			return ConnectionError(ErrCodeProtocol)
+		}
+		sc.goAway(ErrCodeNo)
+		return nil
+	case FrameWindowUpdate:
+		return sc.processWindowUpdate(fr)
+	default:
+		// Unknown frame types are ignored (RFC 9113 section 4.1).
+		return nil
+	}
+}
+
+func (sc *serverConn) processSettings(fr *Frame) error {
+	settings, err := fr.settings()
+	if err != nil {
+		return err
+	}
+	if fr.Flags.Has(FlagAck) {
+		return nil
+	}
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+	return sc.write(func(f *Framer) error {
+		return f.WriteSettingsAck()
+	})
+}
+
+func (sc *serverConn) applySettings(settings []Setting) error {
+	for _, s := range settings {
+		if err := s.check(); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range settings {
+		if s.ID == SettingHeaderTableSize {
+			// We never use more table than the default.
+			sc.write(func(*Framer) error {
+				sc.enc.SetMaxTableSize(min(s.Value, hpack.DefaultTableSize))
+				return nil
+			})
+		}
+	}
+
+	// wmu comes before mu, so mu is taken second.
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, s := range settings {
+		switch s.ID {
+		case SettingInitialWindowSize:
+			// The change applies to the windows of open streams too
+			// (RFC 9113 section 6.9.2).
+			delta := int64(s.Value) - sc.initialSendWindow
+			sc.initialSendWindow = int64(s.Value)
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					return ConnectionError(ErrCodeFlowControl)
+				}
+			}
+			sc.cond.Broadcast()
+		case SettingMaxFrameSize:
+			sc.maxFrameSize = s.Value
+		}
+	}
+	return nil
+}
+
+func (sc *serverConn) processPing(fr *Frame) error {
+	if fr.StreamID != 0 {
+		return ConnectionError(ErrCodeProtocol)
+	}
+	if len(fr.Payload) != 8 {
+		return ConnectionError(ErrCodeFrameSize)
+	}
+	if fr.Flags.Has(FlagAck) {
+		return nil
+	}
+	var data [8]byte
+	copy(data[:], fr.Payload)
+	return sc.write(func(f *Framer) error {
+		return f.WritePing(true, data)
+	})
+}
+
+func (sc *serverConn) processWindowUpdate(fr *Frame) error {
+	if len(fr.Payload) != 4 {
+		return ConnectionError(ErrCodeFrameSize)
+	}
+	increment := int64(binary.BigEndian.Uint32(fr.Payload) & (1<<31 - 1))
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if fr.StreamID == 0 {
+		if increment == 0 {
+			return ConnectionError(ErrCodeProtocol)
+		}
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return ConnectionError(ErrCodeFlowControl)
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	if fr.StreamID > sc.lastStreamID {
+		// The stream is idle.
+		return ConnectionError(ErrCodeProtocol)
+	}
+	if increment == 0 {
+		return StreamError{fr.StreamID, ErrCodeProtocol}
+	}
+	st, ok := sc.streams[fr.StreamID]
+	if !ok {
+		// Updates may cross our END_STREAM; ignore them.
+		return nil
+	}
+	st.sendWindow += increment
+	if st.sendWindow > maxWindowSize {
+		return StreamError{fr.StreamID, ErrCodeFlowControl}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(fr *Frame) error {
+	if fr.StreamID == 0 {
+		return ConnectionError(ErrCodeProtocol)
+	}
+	if len(fr.Payload) != 4 {
+		return ConnectionError(ErrCodeFrameSize)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if fr.StreamID > sc.lastStreamID {
+		return ConnectionError(ErrCodeProtocol)
+	}
+	if st, ok := sc.streams[fr.StreamID]; ok {
+		st.resetLocked()
+	}
+	return nil
+}
+
+func (sc *serverConn) processData(fr *Frame) error {
+	if fr.StreamID == 0 {
+		return ConnectionError(ErrCodeProtocol)
+	}
+	data, err := fr.dataPayload()
+	if err != nil {
+		return err
+	}
+	// Flow control counts the whole payload, padding included.
+	length := int64(len(fr.Payload))
+
+	sc.mu.Lock()
+	if length > sc.recvWindow {
+		sc.mu.Unlock()
+		return ConnectionError(ErrCodeFlowControl)
+	}
+	sc.recvWindow -= length
+
+	if fr.StreamID > sc.lastStreamID {
+		sc.mu.Unlock()
+		return ConnectionError(ErrCodeProtocol)
+	}
+	st, ok := sc.streams[fr.StreamID]
+	if !ok || st.reset {
+		// The stream is over on our side and the client may not have
+		// heard yet; give the window back.
+		sc.mu.Unlock()
+		sc.returnWindow(nil, length)
+		return nil
+	}
+	if st.remoteClosed {
+		sc.mu.Unlock()
+		sc.returnWindow(nil, length)
+		return StreamError{fr.StreamID, ErrCodeStreamClosed}
+	}
+	if length > st.recvWindow {
+		sc.mu.Unlock()
+		sc.returnWindow(nil, length)
+		return StreamError{fr.StreamID, ErrCodeFlowControl}
+	}
+	st.recvWindow -= length
+	st.received += int64(len(data))
+	if st.declaredLength >= 0 && st.received > st.declaredLength {
+		sc.mu.Unlock()
+		return StreamError{fr.StreamID, ErrCodeProtocol}
+	}
+	st.body.Write(data)
+	if fr.Flags.Has(FlagEndStream) {
+		if err := st.endRemote(); err != nil {
+			sc.mu.Unlock()
+			return err
+		}
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	if padding := length - int64(len(data)); padding > 0 {
+		sc.returnWindow(st, padding)
+	}
+	return nil
+}
+
+// returnWindow gives n bytes of receive window back to the client once
+// enough has built up to be worth a WINDOW_UPDATE, for the connection and,
+// if st isn't nil, the stream.
+func (sc *serverConn) returnWindow(st *stream, n int64) {
+	var connIncrement, streamIncrement int64
+	sc.mu.Lock()
+	sc.recvUnacked += n
+	if sc.recvUnacked >= connWindowSize/2 {
+		connIncrement = sc.recvUnacked
+		sc.recvWindow += connIncrement
+		sc.recvUnacked = 0
+	}
+	if st != nil && !st.remoteClosed && !st.reset {
+		st.recvUnacked += n
+		if st.recvUnacked >= streamWindowSize/2 {
+			streamIncrement = st.recvUnacked
+			st.recvWindow += streamIncrement
+			st.recvUnacked = 0
+		}
+	}
+	sc.mu.Unlock()
+
+	if connIncrement == 0 && streamIncrement == 0 {
+		return
+	}
+	sc.write(func(f *Framer) error {
+		if connIncrement > 0 {
+			if err := f.WriteWindowUpdate(0, uint32(connIncrement)); err != nil {
+				return err
+			}
+		}
+		if streamIncrement > 0 {
+			return f.WriteWindowUpdate(st.id, uint32(streamIncrement))
+		}
+		return nil
+	})
+}
+
+func (sc *serverConn) processHeaders(fr *Frame) error {
+	if fr.StreamID == 0 || fr.StreamID%2 == 0 {
+		// Client streams are odd-numbered.
+		return ConnectionError(ErrCodeProtocol)
+	}
+	fragment, err := fr.headerBlockFragment()
+	if err != nil {
+		return err
+	}
+
+	sc.headerBlock = append(sc.headerBlock[:0], fragment...)
+	sc.headerEndStream = fr.Flags.Has(FlagEndStream)
+	if !fr.Flags.Has(FlagEndHeaders) {
+		sc.headerStreamID = fr.StreamID
+		return nil
+	}
+	return sc.processHeaderBlock(fr.StreamID)
+}
+
+func (sc *serverConn) processContinuation(fr *Frame) error {
+	if sc.headerStreamID == 0 {
+		return ConnectionError(ErrCodeProtocol)
+	}
+	// Don't let a client grow a header block without end.
+	if len(sc.headerBlock)+len(fr.Payload) > 2*int(sc.srv.maxHeaderListSize()) {
+		return ConnectionError(ErrCodeEnhanceYourCalm)
+	}
+	sc.headerBlock = append(sc.headerBlock, fr.Payload...)
+	if !fr.Flags.Has(FlagEndHeaders) {
+		return nil
+	}
+	id := sc.headerStreamID
+	sc.headerStreamID = 0
+	return sc.processHeaderBlock(id)
+}
+
+// processHeaderBlock handles a complete header block: the start of a
+// request, or the trailers ending one.
+func (sc *serverConn) processHeaderBlock(id uint32) error {
+	fields, err := sc.dec.Decode(sc.headerBlock)
+	tooLarge := errors.Is(err, hpack.ErrHeaderListTooLarge)
+	if err != nil && !tooLarge {
+		return ConnectionError(ErrCodeCompression)
+	}
+
+	sc.mu.Lock()
+	if id <= sc.lastStreamID {
+		st, ok := sc.streams[id]
+		sc.mu.Unlock()
+		if !ok {
+			// Trailers that crossed our RST_STREAM.
+			return nil
+		}
+		return sc.processTrailers(st, fields, tooLarge)
+	}
+	sc.lastStreamID = id
+	if sc.goingAway {
+		// Past the GOAWAY's last stream; the client will retry it.
+		sc.mu.Unlock()
+		return nil
+	}
+	refused := uint32(len(sc.streams)) >= sc.srv.maxConcurrentStreams()
+	sc.mu.Unlock()
+
+	if refused {
+		return StreamError{id, ErrCodeRefusedStream}
+	}
+	if tooLarge {
+		return sc.writeStatus(id, response.RequestHeaderFieldsTooLarge, sc.headerEndStream)
+	}
+
+	st, err := sc.newRequest(id, fields, sc.headerEndStream)
+	if err != nil {
+		var streamErr StreamError
+		if errors.As(err, &streamErr) {
+			return err
+		}
+		return sc.writeStatus(id, statusForRequestError(err), sc.headerEndStream)
+	}
+	sc.run(st)
+	return nil
+}
+
+// statusForRequestError picks the status for a request whose headers
+// request.NewRequest rejected.
+func statusForRequestError(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.ContentTooLarge
+	case errors.Is(err, request.ErrUnsupportedContentEncoding):
+		return response.UnsupportedMediaType
+	default:
+		return response.BadRequest
+	}
+}
+
+func (sc *serverConn) processTrailers(st *stream, fields []HeaderField, tooLarge bool) error {
+	if !sc.headerEndStream {
+		// Trailers must end the stream.
+		return StreamError{st.id, ErrCodeProtocol}
+	}
+	if tooLarge {
+		return StreamError{st.id, ErrCodeProtocol}
+	}
+	trailers, err := trailerFields(fields)
+	if err != nil {
+		return StreamError{st.id, ErrCodeProtocol}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if st.remoteClosed {
+		return StreamError{st.id, ErrCodeStreamClosed}
+	}
+	st.trailers = trailers
+	if err := st.endRemote(); err != nil {
+		return err
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+// writeStatus answers a stream with a bare status, without running the
+// handler.
+func (sc *serverConn) writeStatus(id uint32, statusCode response.StatusCode, remoteClosed bool) error {
+	err := sc.write(func(f *Framer) error {
+		sc.hbuf = sc.enc.AppendBlock(sc.hbuf[:0], statusField(statusCode), contentLengthZero)
+		if err := f.WriteHeaders(id, true, sc.hbuf, sc.peerMaxFrameSize()); err != nil {
+			return err
+		}
+		if !remoteClosed {
+			// Tell the client not to bother sending the body.
+			return f.WriteRSTStream(id, ErrCodeNo)
+		}
+		return nil
+	})
+	return err
+}
+
+// peerMaxFrameSize returns the client's SETTINGS_MAX_FRAME_SIZE.
+func (sc *serverConn) peerMaxFrameSize() uint32 {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.maxFrameSize
+}
+
+// startUpgraded answers the request that came in over HTTP/1.1 with
+// "Upgrade: h2c" on stream 1, which the client has already half-closed.
+func (sc *serverConn) startUpgraded(old *request.Request) {
+	line := old.RequestLine
+	line.HttpVersion = "2"
+	h := upgradedHeaders(old.Headers)
+	body := bytes.Clone(old.Body)
+	old.Release()
+
+	sc.mu.Lock()
+	sc.lastStreamID = 1
+	sc.mu.Unlock()
+
+	st := sc.newStream(1, true)
+	req, err := request.NewRequest(line, h, bytes.NewReader(body), sc.srv.RequestOptions)
+	if err != nil {
+		sc.forget(st)
+		sc.writeStatus(1, statusForRequestError(err), true)
+		return
+	}
+	req.WithContext(st.ctx)
+	st.req = req
+	sc.run(st)
+}
+
+var contentLengthZero = HeaderField{Name: "content-length", Value: "0"}
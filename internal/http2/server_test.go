@@ -0,0 +1,410 @@
+package http2
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"chillhttp/internal/http2/hpack"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClient speaks raw HTTP/2 to a Server.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	fr   *Framer
+	enc  *hpack.Encoder
+	dec  *hpack.Decoder
+	buf  []byte
+}
+
+// startConn serves one connection with handler and returns a client that
+// sent its preface but not yet its SETTINGS.
+func startConn(t *testing.T, handler Handler) *testClient {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	s := &Server{Handler: handler}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.ServeConn(conn, ConnOptions{})
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() {
+		conn.Close()
+		<-done
+	})
+
+	_, err = io.WriteString(conn, ClientPreface)
+	require.NoError(t, err)
+	return &testClient{
+		t:    t,
+		conn: conn,
+		fr:   NewFramer(conn, conn),
+		enc:  hpack.NewEncoder(),
+		dec:  hpack.NewDecoder(hpack.DefaultTableSize),
+	}
+}
+
+// start opens a connection and exchanges SETTINGS.
+func start(t *testing.T, handler Handler, settings ...Setting) *testClient {
+	t.Helper()
+	c := startConn(t, handler)
+	require.NoError(t, c.fr.WriteSettings(settings...))
+
+	fr := c.read()
+	require.Equal(t, FrameSettings, fr.Type)
+	assert.False(t, fr.Flags.Has(FlagAck))
+	fr = c.read()
+	require.Equal(t, FrameWindowUpdate, fr.Type)
+	require.NoError(t, c.fr.WriteSettingsAck())
+	fr = c.read()
+	require.Equal(t, FrameSettings, fr.Type)
+	assert.True(t, fr.Flags.Has(FlagAck))
+	return c
+}
+
+func (c *testClient) read() *Frame {
+	c.t.Helper()
+	fr, err := c.fr.ReadFrame()
+	require.NoError(c.t, err)
+	// Frames are only valid until the next read.
+	copied := *fr
+	copied.Payload = append([]byte(nil), fr.Payload...)
+	return &copied
+}
+
+func (c *testClient) request(id uint32, endStream bool, fields ...HeaderField) {
+	c.t.Helper()
+	c.buf = c.enc.AppendBlock(c.buf[:0], fields...)
+	require.NoError(c.t, c.fr.WriteHeaders(id, endStream, c.buf, minMaxFrameSize))
+}
+
+func get(path string) []HeaderField {
+	return []HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":path", Value: path},
+		{Name: ":authority", Value: "example.test"},
+	}
+}
+
+func post(path string, extra ...HeaderField) []HeaderField {
+	fields := []HeaderField{
+		{Name: ":method", Value: "POST"},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":path", Value: path},
+		{Name: ":authority", Value: "example.test"},
+	}
+	return append(fields, extra...)
+}
+
+// testResponse is what came back on one stream.
+type testResponse struct {
+	fields   map[string]string
+	body     string
+	trailers map[string]string
+	reset    ErrCode
+}
+
+// responses reads frames until n streams ended, answering PINGs and
+// skipping window updates.
+func (c *testClient) responses(n int) map[uint32]*testResponse {
+	c.t.Helper()
+	out := make(map[uint32]*testResponse)
+	for ended := 0; ended < n; {
+		fr := c.read()
+		r := out[fr.StreamID]
+		if r == nil && fr.StreamID != 0 {
+			r = &testResponse{}
+			out[fr.StreamID] = r
+		}
+		switch fr.Type {
+		case FrameHeaders:
+			fields, err := c.dec.Decode(fr.Payload)
+			require.NoError(c.t, err)
+			m := make(map[string]string)
+			for _, f := range fields {
+				m[f.Name] = f.Value
+			}
+			if r.fields == nil || m[":status"] != "" && strings.HasPrefix(r.fields[":status"], "1") {
+				r.fields = m
+			} else {
+				r.trailers = m
+			}
+		case FrameData:
+			r.body += string(fr.Payload)
+		case FrameRSTStream:
+			r.reset = ErrCode(binary.BigEndian.Uint32(fr.Payload))
+			ended++
+			continue
+		case FrameGoAway:
+			c.t.Fatalf("unexpected GOAWAY %v", ErrCode(binary.BigEndian.Uint32(fr.Payload[4:])))
+		}
+		if fr.StreamID != 0 && fr.Flags.Has(FlagEndStream) && (fr.Type == FrameData || fr.Type == FrameHeaders) {
+			ended++
+		}
+	}
+	return out
+}
+
+func textHandler(body string) Handler {
+	return func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}
+}
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	body, err := io.ReadAll(req.BodyReader())
+	if err != nil {
+		w.WriteStatusLine(response.BadRequest)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		return
+	}
+	w.WriteStatusLine(response.OK)
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("X-Method", req.RequestLine.Method)
+	h.Set("X-Host", req.Host())
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+func TestGet(t *testing.T) {
+	c := start(t, textHandler("hello"))
+	c.request(1, true, get("/")...)
+
+	r := c.responses(1)[1]
+	assert.Equal(t, "200", r.fields[":status"])
+	assert.Equal(t, "5", r.fields["content-length"])
+	assert.Equal(t, "text/plain", r.fields["content-type"])
+	assert.Equal(t, "hello", r.body)
+}
+
+func TestNoConnectionHeaders(t *testing.T) {
+	c := start(t, func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.OK)
+		h := response.GetDefaultHeaders(0)
+		h.Set("Connection", "keep-alive")
+		h.Set("Keep-Alive", "timeout=5")
+		w.WriteHeaders(h)
+	})
+	c.request(1, true, get("/")...)
+
+	r := c.responses(1)[1]
+	assert.Equal(t, "200", r.fields[":status"])
+	assert.NotContains(t, r.fields, "connection")
+	assert.NotContains(t, r.fields, "keep-alive")
+}
+
+func TestPostBody(t *testing.T) {
+	c := start(t, echoHandler)
+	c.request(1, false, post("/", HeaderField{Name: "content-length", Value: "11"})...)
+	require.NoError(t, c.fr.WriteData(1, false, []byte("hello ")))
+	require.NoError(t, c.fr.WriteData(1, true, []byte("world")))
+
+	r := c.responses(1)[1]
+	assert.Equal(t, "200", r.fields[":status"])
+	assert.Equal(t, "POST", r.fields["x-method"])
+	assert.Equal(t, "example.test", r.fields["x-host"])
+	assert.Equal(t, "hello world", r.body)
+}
+
+func TestContentLengthMismatch(t *testing.T) {
+	c := start(t, echoHandler)
+	c.request(1, false, post("/", HeaderField{Name: "content-length", Value: "3"})...)
+	require.NoError(t, c.fr.WriteData(1, true, []byte("hello")))
+
+	r := c.responses(1)[1]
+	assert.Equal(t, ErrCodeProtocol, r.reset)
+}
+
+func TestMultiplexing(t *testing.T) {
+	release := make(chan struct{})
+	c := start(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			<-release
+		}
+		body := req.RequestLine.RequestTarget
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	})
+
+	c.request(1, true, get("/slow")...)
+	c.request(3, true, get("/fast")...)
+	// The fast response overtakes the slow one.
+	fast := c.responses(1)
+	require.Contains(t, fast, uint32(3))
+	assert.Equal(t, "/fast", fast[3].body)
+
+	close(release)
+	slow := c.responses(1)
+	assert.Equal(t, "/slow", slow[1].body)
+}
+
+func TestPing(t *testing.T) {
+	c := start(t, textHandler(""))
+	data := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	require.NoError(t, c.fr.WritePing(false, data))
+
+	fr := c.read()
+	require.Equal(t, FramePing, fr.Type)
+	assert.True(t, fr.Flags.Has(FlagAck))
+	assert.Equal(t, data[:], fr.Payload)
+}
+
+func TestFlowControl(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	c := start(t, textHandler(body), Setting{SettingInitialWindowSize, 30})
+	c.request(1, true, get("/")...)
+
+	// Only the first 30 bytes fit in the stream's window.
+	fr := c.read()
+	require.Equal(t, FrameHeaders, fr.Type)
+	fr = c.read()
+	require.Equal(t, FrameData, fr.Type)
+	assert.Len(t, fr.Payload, 30)
+
+	require.NoError(t, c.fr.WriteWindowUpdate(1, 70))
+	r := c.responses(1)[1]
+	assert.Equal(t, body[30:], r.body)
+}
+
+func TestMalformedRequests(t *testing.T) {
+	tests := map[string][]HeaderField{
+		"missing path":        {{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "https"}},
+		"uppercase name":      append(get("/"), HeaderField{Name: "X-Upper", Value: "1"}),
+		"connection header":   append(get("/"), HeaderField{Name: "connection", Value: "keep-alive"}),
+		"te not trailers":     append(get("/"), HeaderField{Name: "te", Value: "gzip"}),
+		"pseudo after header": append([]HeaderField{{Name: "accept", Value: "*/*"}}, get("/")...),
+		"unknown pseudo":      append(get("/"), HeaderField{Name: ":protocol", Value: "websocket"}),
+	}
+	for name, fields := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := start(t, textHandler("hello"))
+			c.request(1, true, fields...)
+			r := c.responses(1)[1]
+			assert.Equal(t, ErrCodeProtocol, r.reset)
+		})
+	}
+}
+
+func TestCookiesJoined(t *testing.T) {
+	c := start(t, func(w *response.Writer, req *request.Request) {
+		body := req.Headers.Get("Cookie")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	})
+	c.request(1, true, append(get("/"),
+		HeaderField{Name: "cookie", Value: "a=1"},
+		HeaderField{Name: "cookie", Value: "b=2"})...)
+
+	assert.Equal(t, "a=1; b=2", c.responses(1)[1].body)
+}
+
+func TestTrailers(t *testing.T) {
+	c := start(t, func(w *response.Writer, req *request.Request) {
+		body, _ := io.ReadAll(req.BodyReader())
+		h := response.GetDefaultHeaders(0)
+		delete(h, "Content-Length")
+		h.Set("Transfer-Encoding", "chunked")
+		h.Set("Trailer", "X-Checksum")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody(body)
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(map[string]string{"X-Checksum": req.Trailers.Get("X-Checksum")})
+	})
+	c.request(1, false, post("/")...)
+	require.NoError(t, c.fr.WriteData(1, false, []byte("data")))
+	c.request(1, true, HeaderField{Name: "x-checksum", Value: "abc"})
+
+	r := c.responses(1)[1]
+	assert.Equal(t, "200", r.fields[":status"])
+	assert.NotContains(t, r.fields, "transfer-encoding")
+	assert.Equal(t, "data", r.body)
+	assert.Equal(t, "abc", r.trailers["x-checksum"])
+}
+
+func TestBadPreface(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		(&Server{Handler: textHandler("")}).ServeConn(conn, ConnOptions{})
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.test\r\n\r\n")
+	require.NoError(t, err)
+
+	fr := NewFramer(conn, conn)
+	first, err := fr.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, FrameSettings, first.Type)
+	for {
+		f, err := fr.ReadFrame()
+		require.NoError(t, err)
+		if f.Type == FrameGoAway {
+			assert.Equal(t, ErrCodeProtocol, ErrCode(binary.BigEndian.Uint32(f.Payload[4:])))
+			return
+		}
+	}
+}
+
+func TestRequestTooLarge(t *testing.T) {
+	c := start(t, echoHandler)
+	c.request(1, true, append(get("/"), HeaderField{Name: "x-big", Value: strings.Repeat("a", DefaultMaxHeaderListSize)})...)
+
+	r := c.responses(1)[1]
+	assert.Equal(t, "431", r.fields[":status"])
+}
+
+func TestContextCanceledOnReset(t *testing.T) {
+	started := make(chan struct{})
+	result := make(chan error, 1)
+	c := start(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		select {
+		case <-req.Context().Done():
+			result <- req.Context().Err()
+		case <-time.After(3 * time.Second):
+			result <- nil
+		}
+	})
+	c.request(1, true, get("/slow")...)
+	<-started
+	require.NoError(t, c.fr.WriteRSTStream(1, ErrCodeCancel))
+	assert.ErrorIs(t, <-result, context.Canceled)
+}
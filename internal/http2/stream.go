@@ -0,0 +1,483 @@
+package http2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"chillhttp/internal/headers"
+	"chillhttp/internal/http2/hpack"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+)
+
+// HeaderField is a decoded header field.
+type HeaderField = hpack.HeaderField
+
+// stream is one request and its response. Its handler reads the body from
+// it and, through response.Writer, writes the response to it.
+type stream struct {
+	sc  *serverConn
+	id  uint32
+	req *request.Request
+	// ctx is the request's context, canceled when the stream is reset or
+	// its handler returns.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Guarded by sc.mu.
+	sendWindow  int64
+	recvWindow  int64
+	recvUnacked int64
+	// body holds DATA received but not read by the handler yet, and
+	// bodyErr what reading returns once it is drained: io.EOF after
+	// END_STREAM.
+	body    bytes.Buffer
+	bodyErr error
+	// trailers holds the trailer fields that ended the request.
+	trailers headers.Headers
+	// declaredLength is the request's Content-Length, or -1, and received
+	// how much DATA arrived.
+	declaredLength int64
+	received       int64
+	remoteClosed   bool
+	reset          bool
+
+	// ended is set once we sent END_STREAM. Only the handler's goroutine
+	// uses it.
+	ended bool
+}
+
+// newStream registers a stream whose handler is about to run.
+func (sc *serverConn) newStream(id uint32, remoteClosed bool) *stream {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	st := &stream{
+		sc:             sc,
+		id:             id,
+		sendWindow:     sc.initialSendWindow,
+		recvWindow:     streamWindowSize,
+		declaredLength: -1,
+		remoteClosed:   remoteClosed,
+	}
+	if remoteClosed {
+		st.bodyErr = io.EOF
+	}
+	st.ctx, st.cancel = context.WithCancel(sc.ctx)
+	sc.streams[id] = st
+	return st
+}
+
+// forget drops a stream whose handler has finished, giving back the
+// connection window its unread body held.
+func (sc *serverConn) forget(st *stream) {
+	st.cancel()
+	sc.mu.Lock()
+	delete(sc.streams, st.id)
+	unread := int64(st.body.Len())
+	st.body.Reset()
+	sc.mu.Unlock()
+
+	if unread > 0 {
+		sc.returnWindow(nil, unread)
+	}
+	sc.setReadDeadline()
+}
+
+// endRemote records the client's END_STREAM. Callers hold sc.mu.
+func (st *stream) endRemote() error {
+	st.remoteClosed = true
+	if st.declaredLength >= 0 && st.received != st.declaredLength {
+		// RFC 9113 section 8.1.1: the body must match Content-Length.
+		return StreamError{st.id, ErrCodeProtocol}
+	}
+	if st.bodyErr == nil {
+		st.bodyErr = io.EOF
+	}
+	return nil
+}
+
+// resetLocked marks the stream reset, failing its pending reads and writes.
+// Callers hold sc.mu.
+func (st *stream) resetLocked() {
+	st.cancel()
+	st.reset = true
+	st.body.Reset()
+	if st.bodyErr == nil || st.bodyErr == io.EOF {
+		st.bodyErr = errStreamReset
+	}
+	st.sc.cond.Broadcast()
+}
+
+// newRequest validates the header fields that open stream id and creates the
+// stream and its request.
+func (sc *serverConn) newRequest(id uint32, fields []HeaderField, endStream bool) (*stream, error) {
+	line, h, err := requestHeaders(fields)
+	if err != nil {
+		return nil, StreamError{id, ErrCodeProtocol}
+	}
+
+	st := sc.newStream(id, endStream)
+	req, err := request.NewRequest(line, h, st, sc.srv.RequestOptions)
+	if err != nil {
+		sc.forget(st)
+		if errors.Is(err, request.ErrInvalidContentLength) {
+			return nil, StreamError{id, ErrCodeProtocol}
+		}
+		return nil, err
+	}
+	req.TLS = sc.opts.TLS
+	req.RemoteAddr = sc.conn.RemoteAddr().String()
+	req.WithContext(st.ctx)
+	st.req = req
+
+	if value, ok := h["content-length"]; ok {
+		n, _ := strconv.ParseInt(value, 10, 64)
+		if endStream && n != 0 {
+			sc.forget(st)
+			req.Release()
+			return nil, StreamError{id, ErrCodeProtocol}
+		}
+		sc.mu.Lock()
+		st.declaredLength = n
+		sc.mu.Unlock()
+	}
+	return st, nil
+}
+
+// run answers a stream's request in a goroutine of its own.
+func (sc *serverConn) run(st *stream) {
+	go func() {
+		w := response.NewTransportWriter(st)
+		w.HttpVersion = "2"
+		defer sc.finish(st, w)
+		sc.srv.Handler(w, st.req)
+	}()
+}
+
+// finish ends the response to a stream after its handler returned.
+func (sc *serverConn) finish(st *stream, w *response.Writer) {
+	w.Finish()
+	switch {
+	case w.State < response.StateWriteBody, w.Aborted(), w.State == response.StateWriteBody:
+		// The handler never got as far as the headers, or gave up on
+		// the body, so there is nothing to end; the client sees the
+		// stream fail.
+		sc.resetStream(StreamError{st.id, ErrCodeInternal})
+	case !st.ended:
+		if err := st.WriteTrailers(nil); err != nil {
+			break
+		}
+		fallthrough
+	default:
+		sc.mu.Lock()
+		unfinished := !st.remoteClosed && !st.reset
+		sc.mu.Unlock()
+		if unfinished {
+			// The response is complete without the rest of the body;
+			// tell the client to stop sending it (RFC 9113 section 8.1).
+			sc.write(func(f *Framer) error {
+				return f.WriteRSTStream(st.id, ErrCodeNo)
+			})
+		}
+	}
+
+	sc.forget(st)
+	st.req.Release()
+}
+
+// Read reads the request body as it arrives.
+func (st *stream) Read(p []byte) (int, error) {
+	sc := st.sc
+	sc.mu.Lock()
+	for st.body.Len() == 0 && st.bodyErr == nil {
+		sc.cond.Wait()
+	}
+	if st.body.Len() == 0 {
+		err := st.bodyErr
+		if err == io.EOF && st.trailers != nil {
+			st.req.Trailers = st.trailers
+		}
+		sc.mu.Unlock()
+		return 0, err
+	}
+	n, _ := st.body.Read(p)
+	sc.mu.Unlock()
+
+	sc.returnWindow(st, int64(n))
+	return n, nil
+}
+
+// check returns why the stream can't be written to, if it can't. Callers
+// hold sc.mu.
+func (st *stream) check() error {
+	switch {
+	case st.sc.closed:
+		return errClientDisconnected
+	case st.reset:
+		return errStreamReset
+	}
+	return nil
+}
+
+// writeHeaders sends a header block on the stream.
+func (st *stream) writeHeaders(fields []HeaderField, endStream bool) error {
+	sc := st.sc
+	sc.mu.Lock()
+	err := st.check()
+	sc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return sc.write(func(f *Framer) error {
+		sc.hbuf = sc.enc.AppendBlock(sc.hbuf[:0], fields...)
+		return f.WriteHeaders(st.id, endStream, sc.hbuf, sc.peerMaxFrameSize())
+	})
+}
+
+// WriteInterim sends a 1xx response for response.Writer.
+func (st *stream) WriteInterim(statusCode response.StatusCode, h headers.Headers) error {
+	return st.writeHeaders(append([]HeaderField{statusField(statusCode)}, responseFields(h, nil)...), false)
+}
+
+// WriteHeader sends the response headers for response.Writer.
+func (st *stream) WriteHeader(statusCode response.StatusCode, h headers.Headers, cookies []string) error {
+	return st.writeHeaders(append([]HeaderField{statusField(statusCode)}, responseFields(h, cookies)...), false)
+}
+
+// WriteData sends body bytes for response.Writer, as fast as the client's
+// flow-control windows allow.
+func (st *stream) WriteData(p []byte) (int, error) {
+	sc := st.sc
+	written := 0
+	for len(p) > 0 {
+		sc.mu.Lock()
+		for st.check() == nil && (st.sendWindow <= 0 || sc.sendWindow <= 0) {
+			sc.cond.Wait()
+		}
+		if err := st.check(); err != nil {
+			sc.mu.Unlock()
+			return written, err
+		}
+		n := int64(len(p))
+		n = min(n, st.sendWindow, sc.sendWindow, int64(sc.maxFrameSize))
+		st.sendWindow -= n
+		sc.sendWindow -= n
+		sc.mu.Unlock()
+
+		err := sc.write(func(f *Framer) error {
+			return f.WriteData(st.id, false, p[:n])
+		})
+		if err != nil {
+			return written, err
+		}
+		written += int(n)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// WriteTrailers ends the response for response.Writer, with a HEADERS frame
+// if there are trailers and an empty DATA frame otherwise.
+func (st *stream) WriteTrailers(h headers.Headers) error {
+	st.ended = true
+	if fields := responseFields(h, nil); len(fields) > 0 {
+		return st.writeHeaders(fields, true)
+	}
+
+	sc := st.sc
+	sc.mu.Lock()
+	err := st.check()
+	sc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return sc.write(func(f *Framer) error {
+		return f.WriteData(st.id, true, nil)
+	})
+}
+
+func statusField(statusCode response.StatusCode) HeaderField {
+	return HeaderField{Name: ":status", Value: strconv.Itoa(int(statusCode))}
+}
+
+// connectionHeaders are the HTTP/1.1 fields that describe a connection
+// rather than a message. HTTP/2 has none of them (RFC 9113 section 8.2.2).
+var connectionHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// responseFields turns response headers into header fields, in a stable
+// order, leaving out the connection-specific ones.
+func responseFields(h headers.Headers, cookies []string) []HeaderField {
+	fields := make([]HeaderField, 0, len(h)+len(cookies))
+	for key, value := range h {
+		name := strings.ToLower(key)
+		if connectionHeaders[name] {
+			continue
+		}
+		fields = append(fields, HeaderField{Name: name, Value: value})
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+	for _, c := range cookies {
+		fields = append(fields, HeaderField{Name: "set-cookie", Value: c})
+	}
+	return fields
+}
+
+// requestHeaders checks the header fields of a request (RFC 9113 section
+// 8.3) and turns them into a request line and headers.
+func requestHeaders(fields []HeaderField) (request.RequestLine, headers.Headers, error) {
+	var method, scheme, path, authority string
+	seen := make(map[string]bool)
+	h := headers.NewHeaders()
+	regular := false
+	for _, f := range fields {
+		if !validFieldName(f.Name) || !validFieldValue(f.Value) {
+			return request.RequestLine{}, nil, errMalformed
+		}
+		if strings.HasPrefix(f.Name, ":") {
+			// Pseudo-header fields come first, once each.
+			if regular || seen[f.Name] {
+				return request.RequestLine{}, nil, errMalformed
+			}
+			seen[f.Name] = true
+			switch f.Name {
+			case ":method":
+				method = f.Value
+			case ":scheme":
+				scheme = f.Value
+			case ":path":
+				path = f.Value
+			case ":authority":
+				authority = f.Value
+			default:
+				return request.RequestLine{}, nil, errMalformed
+			}
+			continue
+		}
+
+		regular = true
+		if connectionHeaders[f.Name] || (f.Name == "te" && f.Value != "trailers") {
+			return request.RequestLine{}, nil, errMalformed
+		}
+		h.Add(f.Name, f.Value)
+	}
+
+	if !validMethod(method) {
+		return request.RequestLine{}, nil, errMalformed
+	}
+	target := path
+	if method == "CONNECT" {
+		if authority == "" || seen[":scheme"] || seen[":path"] {
+			return request.RequestLine{}, nil, errMalformed
+		}
+		target = authority
+	} else if scheme == "" || !validPath(method, path) {
+		return request.RequestLine{}, nil, errMalformed
+	}
+
+	if authority != "" {
+		if host, ok := h["host"]; !ok {
+			h["host"] = authority
+		} else if !strings.EqualFold(host, authority) {
+			return request.RequestLine{}, nil, errMalformed
+		}
+	}
+	return request.RequestLine{Method: method, RequestTarget: target, HttpVersion: "2"}, h, nil
+}
+
+var errMalformed = errors.New("http2: malformed request")
+
+// trailerFields checks the trailer fields ending a request.
+func trailerFields(fields []HeaderField) (headers.Headers, error) {
+	h := headers.NewHeaders()
+	for _, f := range fields {
+		if strings.HasPrefix(f.Name, ":") || !validFieldName(f.Name) || !validFieldValue(f.Value) {
+			return nil, errMalformed
+		}
+		h.Add(f.Name, f.Value)
+	}
+	return h, nil
+}
+
+// upgradedHeaders returns the headers of an HTTP/1.1 request upgraded to
+// HTTP/2, without the ones that only made sense on the old connection.
+func upgradedHeaders(old headers.Headers) headers.Headers {
+	h := headers.NewHeaders()
+	for key, value := range old {
+		if connectionHeaders[key] || key == "http2-settings" || key == "te" {
+			continue
+		}
+		h[key] = value
+	}
+	return h
+}
+
+// validFieldName accepts lowercase tokens, with a leading colon for
+// pseudo-header fields. Uppercase names are malformed in HTTP/2.
+func validFieldName(name string) bool {
+	name = strings.TrimPrefix(name, ":")
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' {
+			return false
+		}
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`"(),/:;<=>?@[\]{}`, c) != -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// validFieldValue rejects NUL, CR and LF anywhere and whitespace at either
+// end (RFC 9113 section 8.2.1).
+func validFieldValue(value string) bool {
+	if strings.ContainsAny(value, "\x00\r\n") {
+		return false
+	}
+	return value == "" || (value[0] != ' ' && value[0] != '\t' &&
+		value[len(value)-1] != ' ' && value[len(value)-1] != '\t')
+}
+
+// validMethod accepts the uppercase methods the HTTP/1.1 parser does.
+func validMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for i := 0; i < len(method); i++ {
+		if method[i] < 'A' || method[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func validPath(method, path string) bool {
+	if path == "*" {
+		return method == "OPTIONS"
+	}
+	if !strings.HasPrefix(path, "/") {
+		return false
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] <= ' ' || path[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
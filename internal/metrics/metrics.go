@@ -0,0 +1,328 @@
+// Package metrics keeps counters, gauges and histograms and exposes them in
+// the Prometheus text exposition format (version 0.0.4), without the weight
+// of the Prometheus client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"chillhttp/internal/headers"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+)
+
+// ContentType is the media type of the exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are histogram upper bounds, in seconds, suited to request
+// latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metrics and writes them out in the order they were created.
+// Its methods, and those of its metrics, may be called concurrently.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+	names   map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// metric is the part common to all kinds: a name, help text and one series
+// per combination of label values.
+type metric struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+	// buckets are the upper bounds of a histogram, +Inf excluded.
+	buckets []float64
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// counts holds a histogram's per-bucket counts, not cumulative, the
+	// last one for +Inf.
+	counts []uint64
+	count  uint64
+}
+
+func (r *Registry) register(m *metric) *metric {
+	if !validName(m.name) {
+		panic("metrics: invalid metric name " + strconv.Quote(m.name))
+	}
+	for _, l := range m.labels {
+		if !validName(l) || strings.HasPrefix(l, "__") || l == "le" {
+			panic("metrics: invalid label name " + strconv.Quote(l))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[m.name] {
+		panic("metrics: duplicate metric " + m.name)
+	}
+	r.names[m.name] = true
+	m.series = make(map[string]*series)
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// with returns the series for labelValues, creating it if needed. Callers
+// hold m.mu.
+func (m *metric) with(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if m.buckets != nil {
+			s.counts = make([]uint64, len(m.buckets)+1)
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Counter is a value that only goes up, such as a number of requests.
+type Counter struct{ m *metric }
+
+// NewCounter creates a counter with the given label names. By convention
+// its name ends in _total.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(&metric{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+// Add adds v, which must not be negative, to the series for labelValues.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter can't decrease")
+	}
+	c.m.mu.Lock()
+	c.m.with(labelValues).value += v
+	c.m.mu.Unlock()
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Gauge is a value that goes up and down, such as a number of connections.
+type Gauge struct{ m *metric }
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(&metric{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.mu.Lock()
+	g.m.with(labelValues).value = v
+	g.m.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.m.mu.Lock()
+	g.m.with(labelValues).value += v
+	g.m.mu.Unlock()
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Histogram counts observations, such as latencies, in buckets.
+type Histogram struct{ m *metric }
+
+// NewHistogram creates a histogram with the given bucket upper bounds, in
+// increasing order; nil means DefaultBuckets.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: histogram buckets must be in increasing order")
+	}
+	m := &metric{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets}
+	return &Histogram{r.register(m)}
+}
+
+// Observe records v in the series for labelValues.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	i := sort.SearchFloat64s(h.m.buckets, v)
+	h.m.mu.Lock()
+	s := h.m.with(labelValues)
+	s.counts[i]++
+	s.count++
+	s.value += v
+	h.m.mu.Unlock()
+}
+
+// WriteTo writes every metric in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]*metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (m *metric) write(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+	all := make([]*series, 0, len(m.series))
+	for _, s := range m.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i].labelValues, all[j].labelValues
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+
+	for _, s := range all {
+		if m.buckets == nil {
+			writeSample(w, m.name, m.labels, s.labelValues, "", s.value)
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, m.name+"_bucket", m.labels, s.labelValues, formatFloat(bound), float64(cumulative))
+		}
+		writeSample(w, m.name+"_bucket", m.labels, s.labelValues, "+Inf", float64(s.count))
+		writeSample(w, m.name+"_sum", m.labels, s.labelValues, "", s.value)
+		writeSample(w, m.name+"_count", m.labels, s.labelValues, "", float64(s.count))
+	}
+}
+
+// writeSample writes one sample line, with an le label for histogram
+// buckets.
+func writeSample(w *bufio.Writer, name string, labels, values []string, le string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || le != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabel(values[i]))
+		}
+		if le != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "le=\"%s\"", le)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// validName reports whether s matches [a-zA-Z_:][a-zA-Z0-9_:]*, the
+// syntax of metric and label names.
+func validName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c == ':' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Handler returns a handler that answers GET requests with the registry's
+// metrics, for scrapers such as Prometheus.
+func (r *Registry) Handler() func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method != "GET" {
+			h := response.GetDefaultHeaders(0)
+			h["Allow"] = "GET"
+			w.WriteStatusLine(response.MethodNotAllowed)
+			w.WriteHeaders(h)
+			return
+		}
+
+		var body strings.Builder
+		r.WriteTo(&body)
+		h := headers.NewHeaders()
+		h["Content-Type"] = ContentType
+		h["Content-Length"] = strconv.Itoa(body.Len())
+		h["Cache-Control"] = "no-store"
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(body.String()))
+	}
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExposition(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounter("requests_total", "Requests by path.\nSecond line.", "path", "code")
+	inFlight := reg.NewGauge("in_flight", "Requests in flight.")
+	latency := reg.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "path")
+
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "200")
+	requests.Inc(`/"q"\`, "404")
+	inFlight.Inc()
+	inFlight.Inc()
+	inFlight.Dec()
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.1, "/a")
+	latency.Observe(3, "/a")
+
+	var b strings.Builder
+	n, err := reg.WriteTo(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(b.Len()), n)
+	assert.Equal(t, `# HELP requests_total Requests by path.\nSecond line.
+# TYPE requests_total counter
+requests_total{path="/\"q\"\\",code="404"} 1
+requests_total{path="/a",code="200"} 2
+requests_total{path="/b",code="200"} 1
+# HELP in_flight Requests in flight.
+# TYPE in_flight gauge
+in_flight 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="/a",le="0.1"} 2
+latency_seconds_bucket{path="/a",le="1"} 2
+latency_seconds_bucket{path="/a",le="+Inf"} 3
+latency_seconds_sum{path="/a"} 3.15
+latency_seconds_count{path="/a"} 3
+`, b.String())
+}
+
+func TestRegistrationErrors(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("a_total", "")
+	assert.Panics(t, func() { reg.NewGauge("a_total", "") })
+	assert.Panics(t, func() { reg.NewGauge("1st", "") })
+	assert.Panics(t, func() { reg.NewGauge("b", "", "bad-label") })
+	assert.Panics(t, func() { reg.NewHistogram("c", "", nil, "le") })
+	assert.Panics(t, func() { reg.NewHistogram("d", "", []float64{1, 0.5}) })
+
+	g := reg.NewGauge("e", "", "x")
+	assert.Panics(t, func() { g.Inc() })
+	c := reg.NewCounter("f_total", "")
+	assert.Panics(t, func() { c.Add(-1) })
+}
@@ -0,0 +1,243 @@
+package negotiate
+
+import (
+	"strconv"
+	"strings"
+
+	"chillhttp/internal/headers"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+)
+
+// Offer describes one representation a handler can send. Empty fields
+// don't take part in negotiation.
+type Offer struct {
+	ContentType string
+	Language    string
+	Charset     string
+	Encoding    string
+}
+
+// Spec is one element of an Accept-style header: a value such as
+// "text/html", "en-GB" or "gzip", its parameters and its q-value.
+type Spec struct {
+	Value  string
+	Params map[string]string
+	Q      float64
+}
+
+// ParseList parses an Accept, Accept-Language, Accept-Charset or
+// Accept-Encoding header value. Values are lowercased; elements with a
+// malformed q-value are dropped.
+func ParseList(header string) []Spec {
+	var specs []Spec
+	for header != "" {
+		var element string
+		element, header, _ = strings.Cut(header, ",")
+		value, params, _ := strings.Cut(element, ";")
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+
+		spec := Spec{Value: value, Q: 1}
+		valid := true
+		for params != "" {
+			var param string
+			param, params, _ = strings.Cut(params, ";")
+			key, val, _ := strings.Cut(param, "=")
+			key = strings.ToLower(strings.TrimSpace(key))
+			val = strings.Trim(strings.TrimSpace(val), `"`)
+			if key == "q" {
+				q, ok := parseQ(val)
+				if !ok {
+					valid = false
+				}
+				spec.Q = q
+				// Anything after q is an accept-ext, not a media type
+				// parameter.
+				break
+			}
+			if key != "" {
+				if spec.Params == nil {
+					spec.Params = make(map[string]string)
+				}
+				spec.Params[key] = strings.ToLower(val)
+			}
+		}
+		if valid {
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// parseQ parses a qvalue (RFC 9110 section 12.4.2): 0 to 1 with at most
+// three decimals.
+func parseQ(s string) (float64, bool) {
+	if len(s) == 0 || len(s) > 5 || (s[0] != '0' && s[0] != '1') {
+		return 0, false
+	}
+	if len(s) > 1 && s[1] != '.' {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(s, 64)
+	if err != nil || q < 0 || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
+
+// matcher returns the specificity with which spec matches offer, or -1 if it
+// doesn't match. The most specific matching spec sets the offer's q-value.
+type matcher func(spec Spec, offer string) int
+
+func matchMediaType(spec Spec, offer string) int {
+	offerType, offerParams, _ := strings.Cut(offer, ";")
+	offerType = strings.ToLower(strings.TrimSpace(offerType))
+	typ, sub, _ := strings.Cut(offerType, "/")
+	specType, specSub, _ := strings.Cut(spec.Value, "/")
+
+	specificity := 0
+	switch {
+	case specType == "*" && specSub == "*":
+	case specType == typ && specSub == "*":
+		specificity = 1
+	case specType == typ && specSub == sub:
+		specificity = 2
+	default:
+		return -1
+	}
+
+	for key, value := range spec.Params {
+		if !hasParam(offerParams, key, value) {
+			return -1
+		}
+		specificity++
+	}
+	return specificity
+}
+
+func hasParam(params, key, value string) bool {
+	for params != "" {
+		var param string
+		param, params, _ = strings.Cut(params, ";")
+		k, v, _ := strings.Cut(param, "=")
+		if strings.EqualFold(strings.TrimSpace(k), key) && strings.EqualFold(strings.Trim(strings.TrimSpace(v), `"`), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLanguage implements RFC 4647 basic filtering: "en" matches "en" and
+// "en-GB", and longer ranges are more specific.
+func matchLanguage(spec Spec, offer string) int {
+	if spec.Value == "*" {
+		return 0
+	}
+	offer = strings.ToLower(offer)
+	if offer == spec.Value || strings.HasPrefix(offer, spec.Value+"-") {
+		return len(spec.Value)
+	}
+	return -1
+}
+
+func matchToken(spec Spec, offer string) int {
+	if spec.Value == "*" {
+		return 0
+	}
+	if strings.EqualFold(offer, spec.Value) {
+		return 1
+	}
+	return -1
+}
+
+// quality returns the q-value the client gives offer. An absent header
+// accepts everything.
+func quality(specs []Spec, offer string, match matcher) float64 {
+	if specs == nil || offer == "" {
+		return 1
+	}
+	best, q := -1, 0.0
+	for _, spec := range specs {
+		if s := match(spec, offer); s > best {
+			best, q = s, spec.Q
+		}
+	}
+	return q
+}
+
+// encodingQuality is quality for Accept-Encoding, where "identity" is
+// acceptable unless the client rules it out (RFC 9110 section 12.5.3).
+func encodingQuality(specs []Spec, offer string) float64 {
+	if specs == nil || offer == "" {
+		return 1
+	}
+	best, q := -1, 0.0
+	for _, spec := range specs {
+		if s := matchToken(spec, offer); s > best {
+			best, q = s, spec.Q
+		}
+	}
+	if best == -1 && strings.EqualFold(offer, "identity") {
+		return 1
+	}
+	return q
+}
+
+// parseHeader parses the named header, returning nil if it is absent.
+func parseHeader(h headers.Headers, key string) []Spec {
+	value := h.Get(key)
+	if value == "" {
+		return nil
+	}
+	return ParseList(value)
+}
+
+// Best returns the offer the client prefers, going by the product of its
+// q-values for the offer's media type, language, charset and encoding. Ties
+// go to the earlier offer. It returns false if the client accepts none.
+func Best(h headers.Headers, offers ...Offer) (Offer, bool) {
+	accept := parseHeader(h, "Accept")
+	languages := parseHeader(h, "Accept-Language")
+	charsets := parseHeader(h, "Accept-Charset")
+	encodings := parseHeader(h, "Accept-Encoding")
+
+	var best Offer
+	bestQ := 0.0
+	for _, offer := range offers {
+		q := quality(accept, offer.ContentType, matchMediaType) *
+			quality(languages, offer.Language, matchLanguage) *
+			quality(charsets, offer.Charset, matchToken) *
+			encodingQuality(encodings, offer.Encoding)
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// ContentType returns the media type from offers that the client's Accept
+// header prefers.
+func ContentType(h headers.Headers, offers ...string) (string, bool) {
+	candidates := make([]Offer, len(offers))
+	for i, offer := range offers {
+		candidates[i] = Offer{ContentType: offer}
+	}
+	best, ok := Best(h, candidates...)
+	return best.ContentType, ok
+}
+
+// Negotiate returns the offer req prefers. If it accepts none, Negotiate
+// answers it with 406 Not Acceptable and returns false.
+func Negotiate(w *response.Writer, req *request.Request, offers ...Offer) (Offer, bool) {
+	best, ok := Best(req.Headers, offers...)
+	if !ok {
+		body := []byte("None of the available representations is acceptable.\n")
+		w.WriteStatusLine(response.NotAcceptable)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+	return best, ok
+}
@@ -0,0 +1,95 @@
+package negotiate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"chillhttp/internal/headers"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseList(t *testing.T) {
+	specs := ParseList(`text/html;level=1, TEXT/*;q=0.3, */*;q=0.1;ext=x, bad;q=2, application/json;q=0`)
+	assert.Equal(t, []Spec{
+		{Value: "text/html", Params: map[string]string{"level": "1"}, Q: 1},
+		{Value: "text/*", Q: 0.3},
+		{Value: "*/*", Q: 0.1},
+		{Value: "application/json", Q: 0},
+	}, specs)
+}
+
+func TestContentType(t *testing.T) {
+	tests := []struct {
+		accept string
+		offers []string
+		want   string
+		ok     bool
+	}{
+		{"", []string{"text/html", "application/json"}, "text/html", true},
+		{"application/json", []string{"text/html", "application/json"}, "application/json", true},
+		{"text/*;q=0.5, application/json", []string{"text/html", "application/json"}, "application/json", true},
+		{"text/*, text/html;q=0", []string{"text/html", "text/plain"}, "text/plain", true},
+		{"*/*;q=0.1, text/plain;q=0.9", []string{"text/html", "text/plain"}, "text/plain", true},
+		{"text/html;level=2", []string{"text/html;level=1", "text/html;level=2"}, "text/html;level=2", true},
+		{"image/png", []string{"text/html", "application/json"}, "", false},
+		{"*/*;q=0", []string{"text/html"}, "", false},
+	}
+	for _, tt := range tests {
+		h := headers.NewHeaders()
+		if tt.accept != "" {
+			h["accept"] = tt.accept
+		}
+		got, ok := ContentType(h, tt.offers...)
+		assert.Equal(t, tt.ok, ok, tt.accept)
+		assert.Equal(t, tt.want, got, tt.accept)
+	}
+}
+
+func TestBestLanguageCharsetEncoding(t *testing.T) {
+	h := headers.Headers{
+		"accept-language": "fr-CH, fr;q=0.9, en;q=0.6, *;q=0.5",
+		"accept-charset":  "utf-8, iso-8859-1;q=0.5",
+		"accept-encoding": "br;q=1.0, gzip;q=0.8, *;q=0.1",
+	}
+
+	best, ok := Best(h, Offer{Language: "en-US"}, Offer{Language: "fr"}, Offer{Language: "de"})
+	assert.True(t, ok)
+	assert.Equal(t, "fr", best.Language)
+
+	best, _ = Best(h, Offer{Charset: "iso-8859-1"}, Offer{Charset: "UTF-8"})
+	assert.Equal(t, "UTF-8", best.Charset)
+
+	best, _ = Best(h, Offer{Encoding: "gzip"}, Offer{Encoding: "br"}, Offer{Encoding: "identity"})
+	assert.Equal(t, "br", best.Encoding)
+
+	// Every dimension counts: French in gzip beats English in br.
+	best, _ = Best(h, Offer{Language: "en", Encoding: "br"}, Offer{Language: "fr", Encoding: "gzip"})
+	assert.Equal(t, Offer{Language: "fr", Encoding: "gzip"}, best)
+}
+
+func TestIdentityEncoding(t *testing.T) {
+	// identity is acceptable unless ruled out.
+	best, ok := Best(headers.Headers{"accept-encoding": "gzip"}, Offer{Encoding: "br"}, Offer{Encoding: "identity"})
+	assert.True(t, ok)
+	assert.Equal(t, "identity", best.Encoding)
+
+	_, ok = Best(headers.Headers{"accept-encoding": "gzip, identity;q=0"}, Offer{Encoding: "identity"})
+	assert.False(t, ok)
+	_, ok = Best(headers.Headers{"accept-encoding": "gzip, *;q=0"}, Offer{Encoding: "identity"})
+	assert.False(t, ok)
+}
+
+func TestNegotiateNotAcceptable(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nAccept: image/*\r\n\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, ok := Negotiate(response.NewWriter(&buf), req, Offer{ContentType: "text/html"})
+	assert.False(t, ok)
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 406 Not Acceptable\r\n"))
+}
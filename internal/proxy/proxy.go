@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bytes"
+	"chillhttp/internal/cookie"
+	"chillhttp/internal/headers"
+	"chillhttp/internal/request"
+	"chillhttp/internal/tracing"
+	"net/http"
+	"strings"
+)
+
+// hopByHop lists the headers that only describe one connection and must not
+// be forwarded (RFC 9110 section 7.6.1), plus Content-Length, which is
+// recomputed for every hop so that the next server can't read the body
+// differently from how we did.
+var hopByHop = map[string]bool{
+	"connection":          true,
+	"content-length":      true,
+	"keep-alive":          true,
+	"proxy-authenticate":  true,
+	"proxy-authorization": true,
+	"proxy-connection":    true,
+	"te":                  true,
+	"trailer":             true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+}
+
+// isHopByHop reports whether key must be dropped when forwarding a message
+// whose Connection header is connection.
+func isHopByHop(key, connection string) bool {
+	key = strings.ToLower(key)
+	if hopByHop[key] {
+		return true
+	}
+	for connection != "" {
+		var token string
+		token, connection, _ = strings.Cut(connection, ",")
+		if strings.EqualFold(strings.TrimSpace(token), key) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRequest builds the request that forwards req to targetURL. Whatever
+// framing the client used, the body goes upstream as the bytes we actually
+// read, with a single Content-Length that matches them. It carries req's
+// context, so it is abandoned once the client goes away, and continues the
+// trace of the span a tracing.Tracer put in it, if any.
+func NewRequest(req *request.Request, targetURL string) (*http.Request, error) {
+	out, err := http.NewRequestWithContext(req.Context(), req.RequestLine.Method, targetURL, bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	out.Header = ForwardHeaders(req.Headers)
+	tracing.Inject(req.Context(), out.Header)
+	out.ContentLength = int64(len(req.Body))
+	if len(req.Body) == 0 {
+		out.Body = http.NoBody
+	}
+	return out, nil
+}
+
+// ForwardHeaders returns the end-to-end headers of a client request. Host
+// and Expect are dropped as well: the outbound request has its own.
+func ForwardHeaders(h headers.Headers) http.Header {
+	connection := h.Get("Connection")
+	out := make(http.Header, len(h))
+	for key, value := range h {
+		if isHopByHop(key, connection) || strings.EqualFold(key, "host") || strings.EqualFold(key, "expect") {
+			continue
+		}
+		out.Set(key, value)
+	}
+	return out
+}
+
+// ResponseHeaders returns the end-to-end headers of an upstream response,
+// leaving the framing of the response to the client to response.Writer.
+// Set-Cookie can't be comma-joined into one value; see ResponseCookies.
+func ResponseHeaders(src http.Header) headers.Headers {
+	connection := strings.Join(src.Values("Connection"), ",")
+	h := headers.NewHeaders()
+	for key, values := range src {
+		if isHopByHop(key, connection) || key == "Set-Cookie" {
+			continue
+		}
+		h[key] = strings.Join(values, ", ")
+	}
+	return h
+}
+
+var sameSite = map[http.SameSite]cookie.SameSite{
+	http.SameSiteLaxMode:    cookie.SameSiteLax,
+	http.SameSiteStrictMode: cookie.SameSiteStrict,
+	http.SameSiteNoneMode:   cookie.SameSiteNone,
+}
+
+// ResponseCookies returns the cookies set by an upstream response, to be
+// passed on with response.Writer.SetCookie. Lines that don't parse are
+// dropped.
+func ResponseCookies(src http.Header) []*cookie.Cookie {
+	var cookies []*cookie.Cookie
+	for _, line := range src.Values("Set-Cookie") {
+		c, err := http.ParseSetCookie(line)
+		if err != nil {
+			continue
+		}
+		cookies = append(cookies, &cookie.Cookie{
+			Name:        c.Name,
+			Value:       c.Value,
+			Domain:      c.Domain,
+			Path:        c.Path,
+			Expires:     c.Expires,
+			MaxAge:      c.MaxAge,
+			Secure:      c.Secure,
+			HttpOnly:    c.HttpOnly,
+			SameSite:    sameSite[c.SameSite],
+			Partitioned: c.Partitioned,
+		})
+	}
+	return cookies
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"chillhttp/internal/request"
+	"chillhttp/internal/tracing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRequestNormalizesFraming(t *testing.T) {
+	var gotLength int64
+	var gotTransferEncoding []string
+	var gotBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotLength = r.ContentLength
+		gotTransferEncoding = r.TransferEncoding
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+	}))
+	defer upstream.Close()
+
+	// The client sent a chunked body; upstream gets a Content-Length.
+	req, err := request.RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+
+	out, err := NewRequest(req, upstream.URL+"/upload")
+	require.NoError(t, err)
+	assert.Empty(t, out.Header.Get("Transfer-Encoding"))
+	assert.Empty(t, out.Header.Get("Content-Length"))
+
+	resp, err := http.DefaultClient.Do(out)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, int64(5), gotLength)
+	assert.Empty(t, gotTransferEncoding)
+	assert.Equal(t, "hello", gotBody)
+}
+
+func TestNewRequestCarriesContext(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	req.WithContext(ctx)
+
+	out, err := NewRequest(req, "http://upstream.test/")
+	require.NoError(t, err)
+	cancel()
+	assert.ErrorIs(t, out.Context().Err(), context.Canceled)
+}
+
+func TestNewRequestContinuesTrace(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n" +
+		"\r\n"))
+	require.NoError(t, err)
+	sc, _ := tracing.ParseTraceparent(req.Headers.Get("traceparent"))
+	span := &tracing.Span{SpanContext: sc}
+	span.SpanID = tracing.SpanID{1, 2, 3, 4, 5, 6, 7, 8}
+	req.WithContext(tracing.ContextWithSpan(context.Background(), span))
+
+	out, err := NewRequest(req, "http://upstream.test/")
+	require.NoError(t, err)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-0102030405060708-01", out.Header.Get("Traceparent"))
+}
+
+func TestForwardHeadersDropsHopByHop(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Connection: keep-alive, X-Hop\r\n" +
+		"Keep-Alive: timeout=5\r\n" +
+		"X-Hop: secret\r\n" +
+		"TE: trailers\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Accept: */*\r\n" +
+		"\r\n"))
+	require.NoError(t, err)
+
+	h := ForwardHeaders(req.Headers)
+	assert.Equal(t, http.Header{"Accept": {"*/*"}}, h)
+}
+
+func TestResponseHeadersDropsFraming(t *testing.T) {
+	h := ResponseHeaders(http.Header{
+		"Content-Type":      {"application/json"},
+		"Content-Length":    {"5"},
+		"Transfer-Encoding": {"chunked"},
+		"Connection":        {"close"},
+		"Vary":              {"Accept", "Accept-Encoding"},
+	})
+	assert.Equal(t, "application/json", h.Get("Content-Type"))
+	assert.Equal(t, "Accept, Accept-Encoding", h.Get("Vary"))
+	assert.Empty(t, h.Get("Content-Length"))
+	assert.Empty(t, h.Get("Transfer-Encoding"))
+	assert.Empty(t, h.Get("Connection"))
+}
+
+func TestResponseCookies(t *testing.T) {
+	src := http.Header{"Set-Cookie": {
+		"a=1; Path=/; HttpOnly",
+		"b=2; Secure; SameSite=Strict",
+	}}
+	assert.Empty(t, ResponseHeaders(src).Get("Set-Cookie"))
+
+	cookies := ResponseCookies(src)
+	require.Len(t, cookies, 2)
+	assert.Equal(t, "a=1; Path=/; HttpOnly", cookies[0].String())
+	assert.Equal(t, "b=2; Secure; SameSite=Strict", cookies[1].String())
+}
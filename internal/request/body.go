@@ -0,0 +1,203 @@
+package request
+
+import (
+	"bytes"
+	"chillhttp/internal/headers"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type chunkState int
+
+const (
+	chunkSize chunkState = iota
+	chunkData
+	chunkDataEnd
+	chunkTrailer
+)
+
+// maxChunkSizeDigits keeps chunk sizes well clear of overflowing an int.
+const maxChunkSizeDigits = 15
+
+// Errors for requests whose body framing is ambiguous, the raw material of
+// request smuggling: two parties reading the same bytes could disagree on
+// where the request ends. Servers answer them with 400 and close the
+// connection.
+var (
+	ErrDuplicateContentLength            = errors.New("duplicate Content-Length header")
+	ErrInvalidContentLength              = errors.New("invalid Content-Length header")
+	ErrContentLengthWithTransferEncoding = errors.New("both Content-Length and Transfer-Encoding present")
+	ErrInvalidTransferEncoding           = errors.New("invalid Transfer-Encoding: chunked must be the final coding, applied once")
+)
+
+// ErrBodyTooLarge is returned for a body over the configured limit, counted
+// after any Content-Encoding is decoded. Servers answer it with 413.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// ErrUnsupportedTransferEncoding is returned for a well-framed chunked body
+// that also uses transfer codings we can't decode, such as "gzip, chunked".
+// Servers answer it with 501.
+var ErrUnsupportedTransferEncoding = errors.New("unsupported Transfer-Encoding")
+
+// setFraming decides how the body is delimited once the headers are in:
+// chunked transfer coding, Content-Length, or no body at all.
+func (r *Request) setFraming() error {
+	contentLength, hasContentLength := r.Headers["content-length"]
+
+	if transferEncoding, ok := r.Headers["transfer-encoding"]; ok {
+		// RFC 9112 section 6.1: Transfer-Encoding in an HTTP/1.0 message
+		// means the framing can't be trusted.
+		if r.RequestLine.HttpVersion == "1.0" {
+			return fmt.Errorf("%w: not allowed in HTTP/1.0", ErrInvalidTransferEncoding)
+		}
+		// RFC 9112 section 6.3 lets Transfer-Encoding override
+		// Content-Length, but a peer that doesn't know that reads a
+		// different body. Refuse the ambiguity instead.
+		if hasContentLength {
+			return ErrContentLengthWithTransferEncoding
+		}
+
+		codings := strings.Split(transferEncoding, ",")
+		for i, coding := range codings {
+			isChunked := strings.EqualFold(strings.TrimSpace(coding), "chunked")
+			if isChunked != (i == len(codings)-1) {
+				return fmt.Errorf("%w: %q", ErrInvalidTransferEncoding, transferEncoding)
+			}
+		}
+		// chunked is the only transfer coding we decode.
+		if len(codings) > 1 {
+			return fmt.Errorf("%w: %q", ErrUnsupportedTransferEncoding, transferEncoding)
+		}
+
+		r.chunked = true
+		return nil
+	}
+
+	if !hasContentLength {
+		return nil
+	}
+
+	num, err := parseContentLength(contentLength)
+	if err != nil {
+		return err
+	}
+	if r.maxBodySize > 0 && int64(num) > r.maxBodySize {
+		return ErrBodyTooLarge
+	}
+
+	r.contentLength = num
+	return nil
+}
+
+// parseContentLength accepts only a plain run of digits: no sign, no spaces
+// and no list of values.
+func parseContentLength(value string) (int, error) {
+	if value == "" || len(value) > 18 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, value)
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, value)
+		}
+	}
+	return strconv.Atoi(value)
+}
+
+// parseChunked decodes a chunked body (RFC 9112 section 7.1) a piece at a
+// time, returning how many bytes of data it consumed.
+func (r *Request) parseChunked(data []byte) (int, error) {
+	switch r.chunkState {
+	case chunkSize:
+		end, next, bareLF := headers.LineEnd(data, r.opts.Mode)
+		if end == -1 {
+			return 0, nil
+		}
+
+		size, err := parseChunkSize(data[:end])
+		if err != nil {
+			return 0, err
+		}
+		if bareLF {
+			r.opts.Report(headers.LeniencyBareLF)
+		}
+		if r.maxBodySize > 0 && int64(r.bodyLengthRead+size) > r.maxBodySize {
+			return 0, ErrBodyTooLarge
+		}
+		if size == 0 {
+			r.Trailers = headers.NewHeaders()
+			r.chunkState = chunkTrailer
+		} else {
+			r.chunkRemaining = size
+			r.chunkState = chunkData
+		}
+		return next, nil
+
+	case chunkData:
+		n := min(len(data), r.chunkRemaining)
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyLengthRead += n
+		r.chunkRemaining -= n
+		if r.chunkRemaining == 0 {
+			r.chunkState = chunkDataEnd
+		}
+		return n, nil
+
+	case chunkDataEnd:
+		if r.opts.Mode == headers.Lenient && len(data) > 0 && data[0] == '\n' {
+			r.opts.Report(headers.LeniencyBareLF)
+			r.chunkState = chunkSize
+			return 1, nil
+		}
+		if len(data) < 2 {
+			return 0, nil
+		}
+		if data[0] != '\r' || data[1] != '\n' {
+			return 0, errors.New("invalid chunk: missing CRLF after data")
+		}
+		r.chunkState = chunkSize
+		return 2, nil
+
+	case chunkTrailer:
+		n, done, err := r.Trailers.ParseWithOptions(data, r.opts)
+		if err != nil {
+			return 0, err
+		}
+		if done {
+			r.state = StateDone
+		}
+		return n, nil
+
+	default:
+		return 0, errors.New("unknown chunk state")
+	}
+}
+
+// parseChunkSize parses the hex size at the start of a chunk-size line,
+// ignoring any chunk extensions.
+func parseChunkSize(line []byte) (int, error) {
+	if i := bytes.IndexByte(line, ';'); i != -1 {
+		line = bytes.TrimRight(line[:i], " \t")
+	}
+	if len(line) == 0 || len(line) > maxChunkSizeDigits {
+		return 0, fmt.Errorf("invalid chunk size: %q", line)
+	}
+
+	size := 0
+	for _, c := range line {
+		var digit int
+		switch {
+		case c >= '0' && c <= '9':
+			digit = int(c - '0')
+		case c >= 'a' && c <= 'f':
+			digit = int(c-'a') + 10
+		case c >= 'A' && c <= 'F':
+			digit = int(c-'A') + 10
+		default:
+			return 0, fmt.Errorf("invalid chunk size: %q", line)
+		}
+		size = size<<4 | digit
+	}
+	return size, nil
+}
@@ -0,0 +1,79 @@
+package request
+
+import (
+	"io"
+
+	"chillhttp/internal/digest"
+)
+
+// WantContentDigest returns the digest algorithms the client asked to get a
+// Content-Digest with in Want-Content-Digest, most preferred first.
+func (r *Request) WantContentDigest() []string {
+	return digest.ParseWant(r.Headers.Get("Want-Content-Digest"))
+}
+
+// contentDigest returns the Content-Digest the body was sent with, as a
+// header or a trailer.
+func (r *Request) contentDigest() string {
+	if value := r.Headers.Get("Content-Digest"); value != "" {
+		return value
+	}
+	if r.Trailers != nil {
+		return r.Trailers.Get("Content-Digest")
+	}
+	return ""
+}
+
+// verifyDigest checks a fully read body against its Content-Digest, if it
+// has one. The digest covers the body as sent, before content codings are
+// undone.
+func (r *Request) verifyDigest() error {
+	value := r.contentDigest()
+	if value == "" {
+		return nil
+	}
+	h, err := digest.HasherFor(value)
+	if err != nil || h == nil {
+		return err
+	}
+	h.Write(r.Body)
+	return h.Verify(value)
+}
+
+// verifyingReader hashes the body as it streams from raw and checks it
+// against the Content-Digest at the end. A digest sent as a trailer has to be
+// announced in the Trailer header to be checked.
+func (r *Request) verifyingReader(raw io.Reader) (io.Reader, error) {
+	var h *digest.Hasher
+	if value := r.Headers.Get("Content-Digest"); value != "" {
+		var err error
+		if h, err = digest.HasherFor(value); err != nil {
+			return nil, err
+		}
+	} else if r.chunked && r.Headers.HasToken("Trailer", "Content-Digest") {
+		h = digest.NewHasher("sha-256", "sha-512")
+	}
+	if h == nil {
+		return raw, nil
+	}
+	return &digestReader{req: r, raw: raw, hasher: h}, nil
+}
+
+type digestReader struct {
+	req    *Request
+	raw    io.Reader
+	hasher *digest.Hasher
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.raw.Read(p)
+	d.hasher.Write(p[:n])
+	if err == io.EOF {
+		if value := d.req.contentDigest(); value != "" {
+			if verr := d.hasher.Verify(value); verr != nil {
+				return n, verr
+			}
+		}
+	}
+	return n, err
+}
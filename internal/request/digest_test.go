@@ -0,0 +1,76 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"chillhttp/internal/digest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func contentDigest(body []byte) string {
+	h := digest.NewHasher("sha-256")
+	h.Write(body)
+	return h.Value()
+}
+
+func TestVerifyContentDigest(t *testing.T) {
+	body := `{"hello": "world"}`
+	_, err := RequestFromReader(strings.NewReader(fmt.Sprintf("POST / HTTP/1.1\r\nHost: localhost\r\n"+
+		"Content-Digest: %s\r\nContent-Length: %d\r\n\r\n%s", contentDigest([]byte(body)), len(body), body)))
+	require.NoError(t, err)
+
+	_, err = RequestFromReader(strings.NewReader(fmt.Sprintf("POST / HTTP/1.1\r\nHost: localhost\r\n"+
+		"Content-Digest: %s\r\nContent-Length: %d\r\n\r\n%s", contentDigest([]byte("tampered")), len(body), body)))
+	assert.ErrorIs(t, err, digest.ErrMismatch)
+
+	_, err = RequestFromReader(strings.NewReader(fmt.Sprintf("POST / HTTP/1.1\r\nHost: localhost\r\n"+
+		"Content-Digest: sha-256=oops\r\nContent-Length: %d\r\n\r\n%s", len(body), body)))
+	assert.ErrorIs(t, err, digest.ErrMalformed)
+}
+
+func TestVerifyContentDigestTrailer(t *testing.T) {
+	chunked := func(value string) string {
+		return "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nTrailer: Content-Digest\r\n\r\n" +
+			"5\r\nhello\r\n0\r\nContent-Digest: " + value + "\r\n\r\n"
+	}
+
+	_, err := RequestFromReader(strings.NewReader(chunked(contentDigest([]byte("hello")))))
+	assert.NoError(t, err)
+	_, err = RequestFromReader(strings.NewReader(chunked(contentDigest([]byte("world")))))
+	assert.ErrorIs(t, err, digest.ErrMismatch)
+
+	// Streamed, the digest is checked when the body ends.
+	r, err := NewParser(strings.NewReader(chunked(contentDigest([]byte("world"))))).Next()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	assert.ErrorIs(t, err, digest.ErrMismatch)
+}
+
+func TestContentDigestCoversEncodedBody(t *testing.T) {
+	body := gzipped("hello")
+	data := fmt.Sprintf("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: gzip\r\n"+
+		"Content-Digest: %s\r\nContent-Length: %d\r\n\r\n%s", contentDigest(body), len(body), body)
+
+	r, err := decodingParser(data, 0).Next()
+	require.NoError(t, err)
+	decoded, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(decoded))
+
+	r, err = decodingParser(data, 0).Next()
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(decoded))
+}
+
+func TestWantContentDigest(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nWant-Content-Digest: sha-512=3, sha-256=10\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sha-256", "sha-512"}, r.WantContentDigest())
+}
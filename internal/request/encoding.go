@@ -0,0 +1,162 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrUnsupportedContentEncoding is returned, when decoding is enabled,
+	// for a body in a content coding we can't decode. Servers answer it
+	// with 415.
+	ErrUnsupportedContentEncoding = errors.New("unsupported Content-Encoding")
+	// ErrInvalidContentEncoding is returned for a body that doesn't decode
+	// in the coding it claims.
+	ErrInvalidContentEncoding = errors.New("body doesn't match its Content-Encoding")
+)
+
+// setContentCodings records the Content-Encoding to undo, if the parser
+// decodes bodies and the request has one.
+func (r *Request) setContentCodings() error {
+	if r.parser == nil || !r.parser.body.decode || (!r.chunked && r.contentLength == 0) {
+		return nil
+	}
+
+	value := r.Headers.Get("Content-Encoding")
+	for value != "" {
+		var coding string
+		coding, value, _ = strings.Cut(value, ",")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		switch coding {
+		case "", "identity":
+		case "gzip", "x-gzip", "deflate":
+			r.contentCodings = append(r.contentCodings, coding)
+		default:
+			return fmt.Errorf("%w: %q", ErrUnsupportedContentEncoding, coding)
+		}
+	}
+	return nil
+}
+
+// decodeBody replaces a fully read Body with its decoded bytes.
+func (r *Request) decodeBody() error {
+	if r.contentCodings == nil {
+		return nil
+	}
+
+	decoded, err := io.ReadAll(r.decodingReader(bytes.NewReader(r.Body)))
+	if err != nil {
+		return err
+	}
+	r.Body = decoded
+	return nil
+}
+
+// decodingReader undoes the request's content codings on the way out of
+// raw, holding the result to the body size limit. Once it is set up the
+// request no longer describes an encoded body.
+func (r *Request) decodingReader(raw io.Reader) io.Reader {
+	var rd io.Reader = &lazyReader{codings: r.contentCodings, raw: raw}
+	if r.maxBodySize > 0 {
+		rd = &maxBytesReader{r: rd, remaining: r.maxBodySize}
+	}
+
+	r.contentCodings = nil
+	r.Headers.Delete("Content-Encoding")
+	r.Headers.Delete("Content-Length")
+	return rd
+}
+
+// lazyReader sets up its decoders on the first Read, as they start by
+// reading a header from the body.
+type lazyReader struct {
+	codings []string
+	raw     io.Reader
+	rawErr  error // the last error from raw, passed on as it is
+	decoded io.Reader
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if l.decoded == nil {
+		var rd io.Reader = rawReader{l}
+		// Codings are listed in the order they were applied.
+		for i := len(l.codings) - 1; i >= 0; i-- {
+			var err error
+			if rd, err = newDecoder(l.codings[i], rd); err != nil {
+				return 0, l.decodeError(err)
+			}
+		}
+		l.decoded = rd
+	}
+
+	n, err := l.decoded.Read(p)
+	if err != nil && err != io.EOF {
+		err = l.decodeError(err)
+	}
+	return n, err
+}
+
+// decodeError passes on errors reading the body, and reports anything else
+// as a body that doesn't decode.
+func (l *lazyReader) decodeError(err error) error {
+	if l.rawErr != nil && l.rawErr != io.EOF && err == l.rawErr {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidContentEncoding, err)
+}
+
+type rawReader struct {
+	l *lazyReader
+}
+
+func (r rawReader) Read(p []byte) (int, error) {
+	n, err := r.l.raw.Read(p)
+	r.l.rawErr = err
+	return n, err
+}
+
+func newDecoder(coding string, rd io.Reader) (io.Reader, error) {
+	if coding != "deflate" {
+		return gzip.NewReader(rd)
+	}
+
+	// "deflate" is meant to be zlib-wrapped (RFC 9110 section 8.4.1.2), but
+	// some clients send a raw deflate stream. A zlib header is two bytes
+	// whose value is a multiple of 31, with compression method 8.
+	br := bufio.NewReader(rd)
+	header, err := br.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint(header[0])<<8|uint(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// maxBytesReader fails with ErrBodyTooLarge once more than remaining bytes
+// have been read through it.
+type maxBytesReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	// Ask for one byte past the limit to tell "exactly at" from "over".
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		return n + int(m.remaining), ErrBodyTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,136 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func zlibbed(s string) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func rawDeflated(s string) []byte {
+	var buf bytes.Buffer
+	zw, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	zw.Write([]byte(s))
+	zw.Close()
+	return buf.Bytes()
+}
+
+func encodedRequest(encoding string, body []byte) string {
+	return fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n%s",
+		encoding, len(body), body)
+}
+
+func decodingParser(data string, maxBodySize int64) *Parser {
+	return NewParserWithOptions(strings.NewReader(data), Options{DecodeContentEncoding: true, MaxBodySize: maxBodySize})
+}
+
+func TestDecodeContentEncoding(t *testing.T) {
+	const payload = `{"hello":"world"}`
+	tests := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"gzip", gzipped(payload)},
+		{"x-gzip", gzipped(payload)},
+		{"deflate", zlibbed(payload)},
+		{"deflate", rawDeflated(payload)},
+		{"identity", []byte(payload)},
+		{"deflate, gzip", gzipped(string(zlibbed(payload)))},
+	}
+	for _, tt := range tests {
+		r, err := decodingParser(encodedRequest(tt.encoding, tt.body), 0).Next()
+		require.NoError(t, err)
+		body, err := r.ReadBody()
+		require.NoError(t, err, tt.encoding)
+		assert.Equal(t, payload, string(body), tt.encoding)
+		if tt.encoding != "identity" {
+			assert.Empty(t, r.Headers.Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestDecodingIsOptIn(t *testing.T) {
+	body := gzipped("hello")
+	r, err := RequestFromReader(strings.NewReader(encodedRequest("gzip", body)))
+	require.NoError(t, err)
+	assert.Equal(t, body, r.Body)
+	assert.Equal(t, "gzip", r.Headers.Get("Content-Encoding"))
+}
+
+func TestStreamedDecoding(t *testing.T) {
+	payload := strings.Repeat("stream me ", 1000)
+	r, err := decodingParser(encodedRequest("gzip", gzipped(payload)), 0).Next()
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(body))
+}
+
+func TestUnsupportedContentEncoding(t *testing.T) {
+	_, err := decodingParser(encodedRequest("br", []byte("xx")), 0).Next()
+	assert.ErrorIs(t, err, ErrUnsupportedContentEncoding)
+}
+
+func TestInvalidContentEncoding(t *testing.T) {
+	r, err := decodingParser(encodedRequest("gzip", []byte("not gzip at all")), 0).Next()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrInvalidContentEncoding)
+
+	truncated := gzipped(strings.Repeat("a", 100))
+	r, err = decodingParser(encodedRequest("gzip", truncated[:len(truncated)-6]), 0).Next()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrInvalidContentEncoding)
+}
+
+func TestDecompressionBomb(t *testing.T) {
+	// 10 MiB of zeros compresses to about 10 KiB.
+	bomb := gzipped(strings.Repeat("\x00", 10<<20))
+	require.Less(t, len(bomb), 64<<10)
+
+	r, err := decodingParser(encodedRequest("gzip", bomb), 1<<20).Next()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	r, err = decodingParser(encodedRequest("gzip", bomb), 1<<20).Next()
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestMaxBodySize(t *testing.T) {
+	_, err := decodingParser(encodedRequest("identity", []byte("0123456789")), 5).Next()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	r, err := NewParserWithOptions(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"4\r\nabcd\r\n4\r\nefgh\r\n0\r\n\r\n"), Options{MaxBodySize: 6}).Next()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
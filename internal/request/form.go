@@ -0,0 +1,158 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strings"
+)
+
+// maxFormSize caps an application/x-www-form-urlencoded body, which has to be
+// held in memory whole.
+const maxFormSize = 10 << 20
+
+var (
+	ErrFormTooLarge      = errors.New("form body too large")
+	ErrNotMultipart      = errors.New("request Content-Type isn't multipart/form-data")
+	ErrMissingBoundary   = errors.New("no multipart boundary param in Content-Type")
+	errFormAlreadyParsed = errors.New("multipart form already parsed")
+)
+
+// Query parses the query string of the request target.
+func (r *Request) Query() (url.Values, error) {
+	_, query, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+	return url.ParseQuery(query)
+}
+
+// ParseForm fills in r.PostForm from an application/x-www-form-urlencoded
+// body of a POST, PUT or PATCH request, and r.Form from both the body and the
+// query string, body values first. It reads the body if it hasn't been read
+// yet, and is a no-op when called again.
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+
+	var err error
+	r.PostForm = url.Values{}
+	if r.hasFormBody() {
+		mediaType, _, _ := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+		if mediaType == "application/x-www-form-urlencoded" {
+			err = r.parsePostForm()
+		}
+	}
+
+	query, queryErr := r.Query()
+	if err == nil {
+		err = queryErr
+	}
+
+	r.Form = url.Values{}
+	for key, values := range r.PostForm {
+		r.Form[key] = append(r.Form[key], values...)
+	}
+	for key, values := range query {
+		r.Form[key] = append(r.Form[key], values...)
+	}
+	return err
+}
+
+func (r *Request) hasFormBody() bool {
+	switch r.RequestLine.Method {
+	case "POST", "PUT", "PATCH":
+		return true
+	}
+	return false
+}
+
+func (r *Request) parsePostForm() error {
+	if !r.chunked && r.contentLength > maxFormSize {
+		return ErrFormTooLarge
+	}
+	// A chunked body's size is only known once it is read, so read no
+	// more than the limit.
+	body, err := io.ReadAll(io.LimitReader(r.BodyReader(), maxFormSize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxFormSize {
+		return ErrFormTooLarge
+	}
+
+	values, err := url.ParseQuery(string(body))
+	for key, v := range values {
+		r.PostForm[key] = append(r.PostForm[key], v...)
+	}
+	return err
+}
+
+// MultipartReader returns a reader that streams the parts of a
+// multipart/form-data body straight from the connection. Each part carries
+// its own headers. Use it instead of ParseMultipartForm to process uploads
+// as they arrive.
+func (r *Request) MultipartReader() (*multipart.Reader, error) {
+	if r.MultipartForm != nil {
+		return nil, errFormAlreadyParsed
+	}
+
+	mediaType, params, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, ErrNotMultipart
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, ErrMissingBoundary
+	}
+	return multipart.NewReader(r.BodyReader(), boundary), nil
+}
+
+// ParseMultipartForm reads a multipart/form-data body into r.MultipartForm
+// and adds its values to r.Form and r.PostForm. File parts are kept in memory
+// up to maxMemory bytes in total; larger ones spill to temporary files, which
+// Release removes.
+func (r *Request) ParseMultipartForm(maxMemory int64) error {
+	if r.MultipartForm != nil {
+		return nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	form, err := mr.ReadForm(maxMemory)
+	if err != nil {
+		return fmt.Errorf("reading multipart form: %w", err)
+	}
+	r.MultipartForm = form
+
+	for key, values := range form.Value {
+		r.Form[key] = append(r.Form[key], values...)
+		r.PostForm[key] = append(r.PostForm[key], values...)
+	}
+	return nil
+}
+
+// FormValue returns the first value for key in r.Form, parsing the form
+// first if needed. Errors are ignored; call ParseForm to see them.
+func (r *Request) FormValue(key string) string {
+	if r.Form == nil {
+		r.ParseForm()
+	}
+	return r.Form.Get(key)
+}
+
+// PostFormValue returns the first value for key in the request body, parsing
+// the form first if needed.
+func (r *Request) PostFormValue(key string) string {
+	if r.PostForm == nil {
+		r.ParseForm()
+	}
+	return r.PostForm.Get(key)
+}
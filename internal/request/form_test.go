@@ -0,0 +1,163 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseForm(t *testing.T) {
+	body := "name=chill&tag=a&tag=b%20c"
+	r, err := RequestFromReader(strings.NewReader("POST /submit?tag=q&page=2 HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Content-Type: application/x-www-form-urlencoded; charset=utf-8\r\n" +
+		"Content-Length: 26\r\n" +
+		"\r\n" + body))
+	require.NoError(t, err)
+
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, []string{"a", "b c", "q"}, r.Form["tag"])
+	assert.Equal(t, []string{"a", "b c"}, r.PostForm["tag"])
+	assert.Equal(t, "chill", r.FormValue("name"))
+	assert.Equal(t, "2", r.FormValue("page"))
+	assert.Empty(t, r.PostFormValue("page"))
+}
+
+func TestParseFormIgnoresBodyOfGet(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET /search?q=go HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Content-Type: application/x-www-form-urlencoded\r\n" +
+		"Content-Length: 3\r\n" +
+		"\r\n" + "a=1"))
+	require.NoError(t, err)
+
+	require.NoError(t, r.ParseForm())
+	assert.Equal(t, "go", r.FormValue("q"))
+	assert.Empty(t, r.FormValue("a"))
+}
+
+// bigChunks is a chunked request body of four times maxFormSize, counting
+// the bytes read from it.
+type bigChunks struct {
+	read int
+}
+
+func (b *bigChunks) Read(p []byte) (int, error) {
+	chunk := "1000\r\n" + strings.Repeat("a", 0x1000) + "\r\n"
+	total := 4 * maxFormSize / 0x1000 * len(chunk)
+	if b.read >= total {
+		rest := "0\r\n\r\n"[b.read-total:]
+		if rest == "" {
+			return 0, io.EOF
+		}
+		n := copy(p, rest)
+		b.read += n
+		return n, nil
+	}
+	n := copy(p, chunk[b.read%len(chunk):])
+	b.read += n
+	return n, nil
+}
+
+func TestParseFormChunkedTooLarge(t *testing.T) {
+	body := &bigChunks{}
+	r, err := NewParser(io.MultiReader(strings.NewReader("POST /submit HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Content-Type: application/x-www-form-urlencoded\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"), body)).Next()
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, r.ParseForm(), ErrFormTooLarge)
+	assert.Less(t, body.read, 2*maxFormSize)
+}
+
+const multipartBody = "--XyZ\r\n" +
+	"Content-Disposition: form-data; name=\"title\"\r\n" +
+	"\r\n" +
+	"holiday\r\n" +
+	"--XyZ\r\n" +
+	"Content-Disposition: form-data; name=\"photo\"; filename=\"beach.txt\"\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"sand and sea, sand and sea\r\n" +
+	"--XyZ--\r\n"
+
+func multipartRequest(t *testing.T) *Request {
+	reader := &chunkReader{
+		data: "POST /upload?album=2026 HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Content-Type: multipart/form-data; boundary=XyZ\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"40\r\n" + multipartBody[:64] + "\r\n" +
+			fmt.Sprintf("%x", len(multipartBody)-64) + "\r\n" + multipartBody[64:] + "\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 7,
+	}
+	r, err := NewParser(reader).Next()
+	require.NoError(t, err)
+	return r
+}
+
+func TestMultipartReader(t *testing.T) {
+	r := multipartRequest(t)
+	mr, err := r.MultipartReader()
+	require.NoError(t, err)
+
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName())
+	value, _ := io.ReadAll(part)
+	assert.Equal(t, "holiday", string(value))
+
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "beach.txt", part.FileName())
+	assert.Equal(t, "text/plain", part.Header.Get("Content-Type"))
+	value, _ = io.ReadAll(part)
+	assert.Equal(t, "sand and sea, sand and sea", string(value))
+
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestParseMultipartFormSpillsToDisk(t *testing.T) {
+	r := multipartRequest(t)
+	require.NoError(t, r.ParseMultipartForm(0))
+
+	assert.Equal(t, "holiday", r.FormValue("title"))
+	assert.Equal(t, "holiday", r.PostFormValue("title"))
+	assert.Equal(t, "2026", r.FormValue("album"))
+
+	files := r.MultipartForm.File["photo"]
+	require.Len(t, files, 1)
+	f, err := files[0].Open()
+	require.NoError(t, err)
+	contents, _ := io.ReadAll(f)
+	assert.Equal(t, "sand and sea, sand and sea", string(contents))
+	osFile, onDisk := f.(*os.File)
+	require.True(t, onDisk)
+	f.Close()
+
+	r.Release()
+	_, err = os.Stat(osFile.Name())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMultipartReaderRequiresMultipart(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = r.MultipartReader()
+	assert.ErrorIs(t, err, ErrNotMultipart)
+
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Type: multipart/form-data\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = r.MultipartReader()
+	assert.ErrorIs(t, err, ErrMissingBoundary)
+}
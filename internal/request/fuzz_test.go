@@ -0,0 +1,340 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"chillhttp/internal/headers"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// corpusDir holds the checked-in seed corpus for FuzzRequestFromReader. Each
+// hand-written case is named after the verdict the parser must reach on it,
+// "accept-..." or "reject-...". Files the fuzzer adds have no prefix and are
+// only replayed through the differential check.
+const corpusDir = "testdata/fuzz/FuzzRequestFromReader"
+
+// parse reads one request the way the server does, including the Host
+// checks it applies before calling a handler.
+func parse(data []byte) (*Request, error) {
+	p := NewParser(bytes.NewReader(data))
+	defer p.Release()
+
+	r, err := p.Next()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.ReadBody(); err != nil {
+		return nil, err
+	}
+	if err := r.ValidateHost(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// checkAgainstNetHTTP is the differential oracle: anything we accept,
+// net/http must accept too and read the same way, save for what RFC 9112
+// allows and net/http doesn't, and anything net/http accepts, we must accept
+// too unless it is on purpose (see strictness).
+func checkAgainstNetHTTP(t *testing.T, data []byte) {
+	ours, ourErr := parse(data)
+	theirs, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	var theirBody []byte
+	if err == nil {
+		theirBody, err = io.ReadAll(theirs.Body)
+	}
+	if ourErr != nil {
+		if err == nil {
+			reason := strictAbout(data, ourErr)
+			assert.NotEmpty(t, reason, "rejected a request net/http accepts: %q: %v", data, ourErr)
+		}
+		return
+	}
+	if err != nil {
+		assert.True(t, hasBWSBeforeChunkExt(data), "accepted a request net/http rejects: %q: %v", data, err)
+		return
+	}
+
+	assert.Equal(t, theirs.Method, ours.RequestLine.Method)
+	assert.Equal(t, theirs.RequestURI, ours.RequestLine.RequestTarget)
+	wantVersion := "1.1"
+	if theirs.ProtoMinor == 0 {
+		wantVersion = "1.0"
+	}
+	assert.Equal(t, wantVersion, ours.RequestLine.HttpVersion)
+	assert.Equal(t, string(theirBody), string(ours.Body), "body of %q", data)
+
+	if theirs.URL.Host == "" {
+		assert.Equal(t, theirs.Host, ours.Headers.Get("Host"))
+	}
+
+	// net/http moves the framing headers and Host out of Header.
+	skip := map[string]bool{"host": true, "content-length": true, "transfer-encoding": true, "trailer": true}
+	theirHeaders := map[string]string{}
+	for key, values := range theirs.Header {
+		if !skip[strings.ToLower(key)] {
+			separator := ", "
+			if key == "Cookie" {
+				separator = "; "
+			}
+			theirHeaders[strings.ToLower(key)] = strings.Join(values, separator)
+		}
+	}
+	ourHeaders := map[string]string{}
+	for key, value := range ours.Headers {
+		if !skip[key] {
+			ourHeaders[key] = value
+		}
+	}
+	assert.Equal(t, theirHeaders, ourHeaders, "headers of %q", data)
+}
+
+// strictness lists what we reject on purpose although net/http accepts it,
+// each with a test of whether a rejected request is a case of it.
+var strictness = []struct {
+	name  string
+	match func(data []byte, err error) bool
+}{
+	// Bare LF and obs-fold are only read in Lenient mode.
+	{"bare LF", hasBareLF},
+	{"obs-fold", hasObsFold},
+	// RFC 9112 section 5.1 forbids it; net/http drops the whitespace.
+	{"whitespace before colon", isErr(headers.ErrWhitespaceInHeaderName)},
+	// net/http leaves them to its server.
+	{"Host checks", isErr(ErrInvalidHost)},
+	// Ambiguous framing, which net/http resolves one way and another
+	// server may resolve the other (RFC 9112 section 6.3).
+	{"duplicate Content-Length", isErr(ErrDuplicateContentLength)},
+	{"Content-Length with Transfer-Encoding", isErr(ErrContentLengthWithTransferEncoding)},
+	{"Transfer-Encoding not ending in chunked once, or in HTTP/1.0", isErr(ErrInvalidTransferEncoding)},
+	// net/http reads any HTTP/x.y; we only speak 1.x.
+	{"HTTP versions other than 1.x", isErr(ErrUnsupportedVersion)},
+	{"methods other than uppercase letters", func(data []byte, _ error) bool {
+		method, _, _ := requestLine(data)
+		return strings.IndexFunc(method, func(c rune) bool { return c < 'A' || c > 'Z' }) >= 0
+	}},
+	{"request targets outside RFC 9112 forms", func(data []byte, _ error) bool {
+		method, target, _ := requestLine(data)
+		switch {
+		case strings.HasPrefix(target, "/"),
+			target == "*" && method == "OPTIONS",
+			hasHTTPScheme(target):
+			return false
+		case method == "CONNECT":
+			host, port, err := net.SplitHostPort(target)
+			_, portErr := strconv.ParseUint(port, 10, 16)
+			return err != nil || host == "" || portErr != nil
+		}
+		return true
+	}},
+	{"non-ASCII bytes and bad escapes in targets", func(data []byte, _ error) bool {
+		_, target, _ := requestLine(data)
+		for i := 0; i < len(target); i++ {
+			c := target[i]
+			if c <= ' ' || c >= 0x7f || c == '%' && (i+2 >= len(target) || !isHex(target[i+1]) || !isHex(target[i+2])) {
+				return true
+			}
+		}
+		return false
+	}},
+	// RFC 9112 section 7.1.1 only allows whitespace before a chunk-ext.
+	{"whitespace after chunk sizes", func(data []byte, _ error) bool {
+		n := headLength(data)
+		if !bytes.Contains(bytes.ToLower(data[:n]), []byte("chunked")) {
+			return false
+		}
+		for _, line := range chunkSizeLines(data[n:]) {
+			size, _, hasExt := bytes.Cut(line, []byte(";"))
+			if hasExt {
+				size = bytes.TrimRight(size, " \t")
+			}
+			if len(bytes.TrimRight(size, " \t")) != len(size) {
+				return true
+			}
+		}
+		return false
+	}},
+}
+
+// requestLine splits the request line of data the way net/http does.
+func requestLine(data []byte) (method, target, version string) {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	method, rest, _ := strings.Cut(strings.TrimSuffix(string(line), "\r"), " ")
+	target, version, _ = strings.Cut(rest, " ")
+	return method, target, version
+}
+
+func hasHTTPScheme(target string) bool {
+	lower := strings.ToLower(target)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+func isErr(target error) func([]byte, error) bool {
+	return func(_ []byte, err error) bool {
+		return errors.Is(err, target)
+	}
+}
+
+// strictAbout returns the name of the deliberate strictness that made us
+// reject data with err, or "" if there is none.
+func strictAbout(data []byte, err error) string {
+	for _, s := range strictness {
+		if s.match(data, err) {
+			return s.name
+		}
+	}
+	return ""
+}
+
+// headLength returns the length of the head of a request, through the empty
+// line that ends it, or of all of data if it has none.
+func headLength(data []byte) int {
+	n := 0
+	for first := true; ; first = false {
+		i := bytes.IndexByte(data[n:], '\n')
+		if i < 0 {
+			return len(data)
+		}
+		line := data[n : n+i]
+		n += i + 1
+		if !first && len(bytes.TrimSuffix(line, []byte("\r"))) == 0 {
+			return n
+		}
+	}
+}
+
+// hasBareLF reports whether a line of the head, or of a chunked body, ends
+// in LF alone.
+func hasBareLF(data []byte, _ error) bool {
+	n := headLength(data)
+	if bareLF(data[:n]) {
+		return true
+	}
+	chunked := bytes.Contains(bytes.ToLower(data[:n]), []byte("chunked"))
+	return chunked && bareLF(data[n:])
+}
+
+func bareLF(b []byte) bool {
+	for i, c := range b {
+		if c == '\n' && (i == 0 || b[i-1] != '\r') {
+			return true
+		}
+	}
+	return false
+}
+
+// chunkSizeLines returns the chunk-size lines of a chunked body, without
+// their line endings, as far as net/http's reading of the body goes.
+func chunkSizeLines(body []byte) [][]byte {
+	var lines [][]byte
+	for {
+		line, rest, found := bytes.Cut(body, []byte("\n"))
+		if !found {
+			return lines
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		lines = append(lines, line)
+
+		size, _, _ := bytes.Cut(line, []byte(";"))
+		n, err := strconv.ParseUint(string(bytes.TrimRight(size, " \t")), 16, 64)
+		if err != nil || n == 0 || n > uint64(len(rest)) {
+			return lines
+		}
+		body = bytes.TrimPrefix(bytes.TrimPrefix(rest[n:], []byte("\r")), []byte("\n"))
+	}
+}
+
+// hasBWSBeforeChunkExt reports whether a chunk-size line has whitespace
+// before its chunk-ext, which RFC 9112 section 7.1.1 allows but net/http
+// rejects.
+func hasBWSBeforeChunkExt(data []byte) bool {
+	n := headLength(data)
+	if !bytes.Contains(bytes.ToLower(data[:n]), []byte("chunked")) {
+		return false
+	}
+	for _, line := range chunkSizeLines(data[n:]) {
+		size, _, hasExt := bytes.Cut(line, []byte(";"))
+		if hasExt && len(bytes.TrimRight(size, " \t")) != len(size) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasObsFold reports whether a header line continues the one before it.
+func hasObsFold(data []byte, _ error) bool {
+	lines := bytes.Split(data[:headLength(data)], []byte("\n"))
+	for i := 2; i < len(lines); i++ {
+		if len(lines[i]) > 0 && (lines[i][0] == ' ' || lines[i][0] == '\t') {
+			return true
+		}
+	}
+	return false
+}
+
+func FuzzRequestFromReader(f *testing.F) {
+	f.Add([]byte("GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"))
+	f.Add([]byte("POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 13\r\n\r\nhello world!\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkAgainstNetHTTP(t, data)
+	})
+}
+
+// TestConformanceCorpus replays the hand-written corpus and checks each case
+// reaches the verdict in its name, in addition to the differential check
+// FuzzRequestFromReader runs on it.
+func TestConformanceCorpus(t *testing.T) {
+	files, err := os.ReadDir(corpusDir)
+	require.NoError(t, err)
+
+	for _, file := range files {
+		name := file.Name()
+		accept := strings.HasPrefix(name, "accept-")
+		if !accept && !strings.HasPrefix(name, "reject-") {
+			continue
+		}
+
+		t.Run(name, func(t *testing.T) {
+			data := readCorpusFile(t, filepath.Join(corpusDir, name))
+			_, err := parse(data)
+			if accept {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+// readCorpusFile decodes a single []byte value from a file in the
+// "go test fuzz v1" format.
+func readCorpusFile(t *testing.T, path string) []byte {
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	lines := strings.SplitN(strings.TrimSpace(string(raw)), "\n", 2)
+	require.Len(t, lines, 2)
+	require.Equal(t, "go test fuzz v1", lines[0])
+
+	literal, ok := strings.CutPrefix(lines[1], "[]byte(")
+	require.True(t, ok)
+	literal, ok = strings.CutSuffix(literal, ")")
+	require.True(t, ok)
+
+	data, err := strconv.Unquote(literal)
+	require.NoError(t, err)
+	return []byte(data)
+}
@@ -0,0 +1,83 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+)
+
+// defaultMaxJSONBytes is the body limit of DecodeJSON when JSONOptions
+// doesn't set one.
+const defaultMaxJSONBytes = 1 << 20
+
+var (
+	// ErrUnsupportedMediaType is returned by DecodeJSON for a body that isn't
+	// declared as JSON. Servers answer it with 415.
+	ErrUnsupportedMediaType = errors.New("Content-Type must be application/json")
+	// ErrInvalidJSON is returned for a body that doesn't decode into the
+	// target value.
+	ErrInvalidJSON = errors.New("invalid JSON body")
+)
+
+// JSONOptions configures DecodeJSON.
+type JSONOptions struct {
+	// MaxBytes caps the size of the body; 0 means 1 MiB.
+	MaxBytes int64
+	// DisallowUnknownFields rejects objects with keys that don't match a
+	// field of the target struct.
+	DisallowUnknownFields bool
+}
+
+// DecodeJSON decodes a single JSON value from the body into v. The request
+// must have an application/json (or +json) Content-Type in UTF-8.
+func (r *Request) DecodeJSON(v any, opts JSONOptions) error {
+	if !isJSONContentType(r.Headers.Get("Content-Type")) {
+		return ErrUnsupportedMediaType
+	}
+
+	maxBytes := opts.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = defaultMaxJSONBytes
+	}
+	if !r.chunked && int64(r.contentLength) > maxBytes {
+		return ErrBodyTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(r.BodyReader(), maxBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > maxBytes {
+		return ErrBodyTooLarge
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if opts.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("%w: empty body", ErrInvalidJSON)
+		}
+		return fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("%w: unexpected data after the JSON value", ErrInvalidJSON)
+	}
+	return nil
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if charset, ok := params["charset"]; ok && !strings.EqualFold(charset, "utf-8") {
+		return false
+	}
+	return mediaType == "application/json" ||
+		(strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))
+}
@@ -0,0 +1,69 @@
+package request
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type order struct {
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+}
+
+func jsonRequest(t *testing.T, contentType, body string) *Request {
+	r, err := RequestFromReader(strings.NewReader(fmt.Sprintf("POST /orders HTTP/1.1\r\n"+
+		"Host: localhost\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", contentType, len(body), body)))
+	require.NoError(t, err)
+	return r
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var o order
+	r := jsonRequest(t, "application/json; charset=utf-8", `{"item":"tea","quantity":2}`)
+	require.NoError(t, r.DecodeJSON(&o, JSONOptions{}))
+	assert.Equal(t, order{Item: "tea", Quantity: 2}, o)
+
+	r = jsonRequest(t, "application/merge-patch+json", `{"quantity":3}`)
+	require.NoError(t, r.DecodeJSON(&o, JSONOptions{}))
+	assert.Equal(t, 3, o.Quantity)
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		opts        JSONOptions
+		err         error
+	}{
+		{"plain text", "text/plain", `{}`, JSONOptions{}, ErrUnsupportedMediaType},
+		{"latin-1", "application/json; charset=iso-8859-1", `{}`, JSONOptions{}, ErrUnsupportedMediaType},
+		{"too large", "application/json", `{"item":"a long name"}`, JSONOptions{MaxBytes: 10}, ErrBodyTooLarge},
+		{"empty", "application/json", ``, JSONOptions{}, ErrInvalidJSON},
+		{"syntax", "application/json", `{"item":`, JSONOptions{}, ErrInvalidJSON},
+		{"wrong type", "application/json", `{"quantity":"two"}`, JSONOptions{}, ErrInvalidJSON},
+		{"trailing data", "application/json", `{} {}`, JSONOptions{}, ErrInvalidJSON},
+		{"unknown field", "application/json", `{"colour":"red"}`, JSONOptions{DisallowUnknownFields: true}, ErrInvalidJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var o order
+			err := jsonRequest(t, tt.contentType, tt.body).DecodeJSON(&o, tt.opts)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestDecodeJSONLimitsChunkedBody(t *testing.T) {
+	r, err := NewParser(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\n" +
+		"Content-Type: application/json\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"8\r\n[1,2,3,4\r\n7\r\n,5,6,7]\r\n0\r\n\r\n")).Next()
+	require.NoError(t, err)
+
+	var v []int
+	assert.ErrorIs(t, r.DecodeJSON(&v, JSONOptions{MaxBytes: 10}), ErrBodyTooLarge)
+}
@@ -0,0 +1,245 @@
+package request
+
+import (
+	"chillhttp/internal/headers"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+const (
+	// bufferSize is the size of the pooled read buffers. A request line or
+	// header that doesn't fit makes the buffer grow, up to maxBufferSize.
+	bufferSize    = 4096
+	maxBufferSize = 1 << 20
+)
+
+// ErrHeaderTooLarge is returned when a single line of the request doesn't
+// fit in the largest read buffer. Servers answer it with 431.
+var ErrHeaderTooLarge = errors.New("request header too large")
+
+var errBodyEndedEarly = errors.New("incomplete request: body ended early")
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, bufferSize)
+		return &b
+	},
+}
+
+var requestPool = sync.Pool{
+	New: func() any {
+		return &Request{Headers: headers.NewHeaders()}
+	},
+}
+
+// Parser reads successive requests from one connection. Bytes read past the
+// end of one request are kept for the next, so clients may pipeline
+// requests without waiting for responses.
+//
+// Like a bufio.Reader, it reads into a buffer and parses straight out of it;
+// buffers and requests are pooled, so Release both when done with them.
+type Parser struct {
+	opts    headers.ParseOptions
+	body    bodyOptions
+	src     io.Reader
+	buf     *[]byte
+	start   int // unparsed bytes are (*buf)[start:end]
+	end     int
+	current *Request
+}
+
+// LeniencyRequestLineSpaces is reported when Lenient mode accepts a request
+// line with extra spaces or tabs around its parts.
+const LeniencyRequestLineSpaces headers.Leniency = "request-line-spaces"
+
+// Options configures a Parser.
+type Options struct {
+	// Mode is headers.Strict, the default, or headers.Lenient.
+	Mode headers.Mode
+	// OnLeniency is called each time Lenient mode accepts input that Strict
+	// mode would reject. By default the leniency is logged.
+	OnLeniency func(headers.Leniency)
+	// MaxBodySize caps request bodies, in bytes after any Content-Encoding
+	// is decoded. Larger bodies fail with ErrBodyTooLarge. 0 means no limit.
+	MaxBodySize int64
+	// DecodeContentEncoding makes the parser undo gzip and deflate
+	// Content-Encoding, so Body holds the decoded bytes. Bodies in any other
+	// coding fail with ErrUnsupportedContentEncoding.
+	DecodeContentEncoding bool
+}
+
+// bodyOptions are the Options that apply to request bodies.
+type bodyOptions struct {
+	maxSize int64
+	decode  bool
+}
+
+func NewParser(reader io.Reader) *Parser {
+	return NewParserWithOptions(reader, Options{})
+}
+
+func NewParserWithOptions(reader io.Reader, opts Options) *Parser {
+	onLeniency := opts.OnLeniency
+	if onLeniency == nil {
+		onLeniency = logLeniency
+	}
+
+	return &Parser{
+		opts: headers.ParseOptions{Mode: opts.Mode, OnLeniency: onLeniency},
+		body: bodyOptions{maxSize: opts.MaxBodySize, decode: opts.DecodeContentEncoding},
+		src:  reader,
+		buf:  bufferPool.Get().(*[]byte),
+	}
+}
+
+func logLeniency(l headers.Leniency) {
+	log.Warn("accepted non-conforming request in lenient mode", zap.String("leniency", string(l)))
+}
+
+// Release returns the parser's buffer to the pool. The parser must not be
+// used afterwards.
+func (p *Parser) Release() {
+	if p.buf == nil {
+		return
+	}
+	if cap(*p.buf) == bufferSize {
+		bufferPool.Put(p.buf)
+	}
+	p.buf = nil
+	p.current = nil
+}
+
+// Next parses the request line and headers of the next request, leaving its
+// body to Request.ReadBody. Whatever is left of the previous request's body
+// is read and discarded first. Next returns io.EOF if the connection was
+// closed cleanly between requests.
+func (p *Parser) Next() (*Request, error) {
+	if p.current != nil && !p.current.Complete() {
+		p.current.beforeBody = nil
+		if _, err := p.current.ReadBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	req := requestPool.Get().(*Request)
+	req.reset(p)
+	p.current = req
+
+	if err := p.readUntil(req, StateParsingBody); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// Buffered returns the bytes that have been read from the connection but not
+// parsed yet.
+func (p *Parser) Buffered() []byte {
+	return (*p.buf)[p.start:p.end]
+}
+
+// readUntil reads from the source until req reaches state.
+func (p *Parser) readUntil(req *Request, state ParserState) error {
+	for {
+		if err := p.advance(req, state); err != nil {
+			return err
+		}
+		if req.state >= state {
+			return nil
+		}
+
+		if err := p.fill(); err != nil {
+			if err != io.EOF {
+				return err
+			}
+			switch {
+			case req.state == StateInitialized && p.start == p.end:
+				// The peer closed the connection between requests.
+				return io.EOF
+			case req.state == StateParsingBody && req.untilEOF:
+				req.state = StateDone
+				return nil
+			case req.state == StateParsingBody:
+				return errBodyEndedEarly
+			default:
+				return errors.New("incomplete request: missing end of headers")
+			}
+		}
+	}
+}
+
+// readBody parses more of req's body, reading from the source only when
+// nothing buffered is left. It returns once the body has grown or the request
+// is complete.
+func (p *Parser) readBody(req *Request) error {
+	for {
+		before := len(req.Body)
+		if err := p.advance(req, StateDone); err != nil {
+			return err
+		}
+		if req.state == StateDone || len(req.Body) > before {
+			return nil
+		}
+
+		if err := req.runBeforeBody(); err != nil {
+			return err
+		}
+		if err := p.fill(); err != nil {
+			if err == io.EOF && req.untilEOF {
+				req.state = StateDone
+				return nil
+			}
+			if err == io.EOF {
+				return errBodyEndedEarly
+			}
+			return err
+		}
+	}
+}
+
+// fill reads more data into the buffer, first making room by moving the
+// unparsed bytes to the front or, if the buffer is full of them, growing it.
+func (p *Parser) fill() error {
+	buf := *p.buf
+	if p.end == len(buf) {
+		if p.start > 0 {
+			p.end = copy(buf, buf[p.start:p.end])
+			p.start = 0
+		} else if len(buf) >= maxBufferSize {
+			return ErrHeaderTooLarge
+		} else {
+			grown := make([]byte, 2*len(buf))
+			copy(grown, buf[:p.end])
+			if cap(buf) == bufferSize {
+				bufferPool.Put(p.buf)
+			}
+			p.buf = &grown
+			buf = grown
+		}
+	}
+
+	n, err := p.src.Read(buf[p.end:])
+	p.end += n
+	if n > 0 {
+		return nil
+	}
+	return err
+}
+
+// advance parses as much of the buffered data as it can, up to state.
+func (p *Parser) advance(req *Request, state ParserState) error {
+	consumed, err := req.parse((*p.buf)[p.start:p.end], state)
+	if err != nil {
+		return err
+	}
+
+	p.start += consumed
+	if p.start == p.end {
+		p.start, p.end = 0, 0
+	}
+	return nil
+}
@@ -1,14 +1,31 @@
 package request
 
 import (
+	"bytes"
+	"chillhttp/internal/cookie"
 	"chillhttp/internal/headers"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
-	"strconv"
+	"mime/multipart"
+	"net/url"
 	"strings"
 )
 
+// ErrUnsupportedVersion is returned for a well-formed request line whose HTTP
+// major version this server does not speak. Servers answer it with 505.
+var ErrUnsupportedVersion = errors.New("unsupported HTTP version")
+
+// ErrInvalidHost is returned by ValidateHost for an HTTP/1.1 request that has
+// no Host header, more than one, or one that isn't a valid authority.
+var ErrInvalidHost = errors.New("missing, duplicate or malformed Host header")
+
+// ErrNoCookie is returned by Cookie when the request has no cookie with the
+// given name.
+var ErrNoCookie = errors.New("named cookie not present")
+
 type ParserState int
 
 const (
@@ -22,8 +39,56 @@ type Request struct {
 	RequestLine RequestLine
 	state       ParserState
 	Headers     headers.Headers
-	Body 		[]byte
+	// Body holds the request body once it has been read, by RequestFromReader
+	// or ReadBody.
+	Body []byte
+	// Trailers holds the trailer fields of a chunked body.
+	Trailers headers.Headers
+
+	// TLS describes the connection of a request received over TLS, including
+	// the client's certificates under mutual TLS. It is nil otherwise.
+	TLS *tls.ConnectionState
+	// RemoteAddr is the client's network address, "host:port", as the
+	// server saw it. It is empty for requests that didn't come from one.
+	RemoteAddr string
+
+	// Form holds the query parameters and form body values, and PostForm
+	// only the body values, once ParseForm or ParseMultipartForm has run.
+	Form     url.Values
+	PostForm url.Values
+	// MultipartForm holds the parsed multipart/form-data body, including
+	// uploaded files, once ParseMultipartForm has run.
+	MultipartForm *multipart.Form
+
+	// ctx is canceled when the client goes away, the server shuts down or
+	// a deadline passes.
+	ctx context.Context
+
 	bodyLengthRead int
+
+	// Framing of the body, decided once the headers are in.
+	contentLength int
+	chunked       bool
+	// untilEOF marks a body that ends with the stream it is read from,
+	// for requests made with NewRequest without a Content-Length.
+	untilEOF       bool
+	chunkState     chunkState
+	chunkRemaining int
+
+	// Content codings of the body still to be undone, outermost last, when
+	// the parser decodes Content-Encoding.
+	contentCodings []string
+	maxBodySize    int64
+	// bodyFinished is set once the body has been checked and decoded, or
+	// handed to BodyReader to do so as it streams.
+	bodyFinished bool
+
+	parser *Parser
+	// ownsParser is set when the request was made by NewRequest and its
+	// parser goes when it does.
+	ownsParser bool
+	opts       headers.ParseOptions
+	beforeBody func() error
 }
 
 type RequestLine struct {
@@ -33,51 +98,242 @@ type RequestLine struct {
 }
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	req := &Request{
-		state:   StateInitialized,
-		Headers: headers.NewHeaders(),
-		Body:   make([]byte, 0),
+	p := NewParser(reader)
+	defer p.Release()
+
+	req, err := p.Next()
+	if err != nil {
+		return nil, err
 	}
 
-	buffer := make([]byte, 8)
-	parsedBytes := 0
+	if _, err := req.ReadBody(); err != nil {
+		return nil, err
+	}
 
-	for req.state != StateDone {
-		if parsedBytes >= len(buffer) {
-			newBuffer := make([]byte, len(buffer)*2)
-			copy(newBuffer, buffer)
-			buffer = newBuffer
-		}
+	req.parser = nil
+	return req, nil
+}
 
-		n, err := reader.Read(buffer[parsedBytes:])
-		if err != nil {
-			if err == io.EOF {
-				if req.state != StateDone {
-					return nil, errors.New("incomplete request: missing end of headers")
-				}
-				break
-			}
-			return nil, err
-		}
+// HeadersFromReader parses the request line and headers, leaving the body
+// unread so the caller can look at the headers first (e.g. to answer
+// "Expect: 100-continue"). Call ReadBody to finish the request.
+func HeadersFromReader(reader io.Reader) (*Request, error) {
+	return NewParser(reader).Next()
+}
 
-		parsedBytes += n
+// NewRequest returns a request whose request line and headers arrived by
+// other means than HTTP/1.1, such as an HTTP/2 HEADERS frame, with the body
+// to be read from body. Header keys must be lowercase. Without a
+// Content-Length the body runs until body returns io.EOF. Release the
+// request when done with it.
+func NewRequest(line RequestLine, h headers.Headers, body io.Reader, opts Options) (*Request, error) {
+	p := NewParserWithOptions(body, opts)
+	req := requestPool.Get().(*Request)
+	req.reset(p)
+	req.ownsParser = true
+	p.current = req
+
+	req.RequestLine = line
+	for key, value := range h {
+		req.Headers[key] = value
+	}
+	if err := req.setFraming(); err != nil {
+		req.Release()
+		return nil, err
+	}
+	if err := req.setContentCodings(); err != nil {
+		req.Release()
+		return nil, err
+	}
+	_, hasContentLength := req.Headers["content-length"]
+	req.untilEOF = !hasContentLength && !req.chunked
+	req.state = StateParsingBody
+	return req, nil
+}
 
-		consumed, err := req.parse(buffer[:parsedBytes])
-		if err != nil {
+// reset readies a pooled request to be parsed by p.
+func (r *Request) reset(p *Parser) {
+	if r.Headers == nil {
+		r.Headers = headers.NewHeaders()
+	}
+	clear(r.Headers)
+	*r = Request{
+		Headers:     r.Headers,
+		Body:        r.Body[:0],
+		maxBodySize: p.body.maxSize,
+		parser:      p,
+		opts:        p.opts,
+	}
+}
+
+// Release returns the request to the pool it was allocated from, removing any
+// temporary files of a multipart form. Neither the request nor its Headers
+// and Body may be used afterwards.
+func (r *Request) Release() {
+	if r.MultipartForm != nil {
+		r.MultipartForm.RemoveAll()
+	}
+	if r.parser != nil && r.parser.current == r {
+		r.parser.current = nil
+	}
+	if r.ownsParser {
+		r.parser.Release()
+	}
+	r.parser = nil
+	r.beforeBody = nil
+	r.ctx = nil
+	requestPool.Put(r)
+}
+
+// Context returns the request's context. The server cancels it when the
+// client disconnects, when it shuts down, and once the handler returned;
+// middleware may add deadlines with WithContext. It is never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext sets the request's context to ctx and returns the request.
+// Unlike net/http it doesn't copy the request: a request is tied to the
+// parser reading its body, so there is only ever one of it.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r.ctx = ctx
+	return r
+}
+
+// BeforeBodyRead registers fn to run once, the first time the body has to be
+// read from the underlying reader. Servers use it to send "100 Continue".
+func (r *Request) BeforeBodyRead(fn func() error) {
+	r.beforeBody = fn
+}
+
+// ReadBody reads the rest of the request and returns its body. It is safe to
+// call more than once.
+func (r *Request) ReadBody() ([]byte, error) {
+	if r.state == StateDone {
+		return r.Body, r.finishBody()
+	}
+
+	// Anything already buffered doesn't need the client's go-ahead.
+	if err := r.parser.advance(r, StateDone); err != nil {
+		return nil, err
+	}
+
+	if r.state != StateDone {
+		if err := r.runBeforeBody(); err != nil {
 			return nil, err
 		}
+	}
 
-		copy(buffer, buffer[consumed:])
-		parsedBytes -= consumed
+	if err := r.parser.readUntil(r, StateDone); err != nil {
+		return nil, err
 	}
 
-	return req, nil
+	if err := r.finishBody(); err != nil {
+		return nil, err
+	}
+	return r.Body, nil
+}
+
+// finishBody checks a fully read body against its Content-Digest and undoes
+// its content codings, once.
+func (r *Request) finishBody() error {
+	if r.bodyFinished {
+		return nil
+	}
+	r.bodyFinished = true
+
+	if err := r.verifyDigest(); err != nil {
+		return err
+	}
+	return r.decodeBody()
+}
+
+// runBeforeBody runs the BeforeBodyRead hook, if it hasn't run yet.
+func (r *Request) runBeforeBody() error {
+	if r.beforeBody == nil {
+		return nil
+	}
+	fn := r.beforeBody
+	r.beforeBody = nil
+	return fn()
+}
+
+// BodyReader returns a reader over the body. Whatever is already in Body is
+// returned first; the rest streams from the connection instead of being
+// collected in Body, for bodies too large to hold in memory. Once streaming
+// starts Body only holds the latest piece read, so don't mix BodyReader with
+// ReadBody.
+func (r *Request) BodyReader() io.Reader {
+	if r.bodyFinished {
+		return &bodyReader{req: r}
+	}
+	r.bodyFinished = true
+
+	raw, err := r.verifyingReader(&bodyReader{req: r})
+	if err != nil {
+		return errReader{err}
+	}
+	if r.contentCodings != nil {
+		return r.decodingReader(raw)
+	}
+	return raw
+}
+
+// errReader fails every Read with err.
+type errReader struct {
+	err error
+}
+
+func (e errReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
+
+type bodyReader struct {
+	req *Request
+	pos int // bytes of req.Body already returned
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	r := b.req
+	for b.pos == len(r.Body) {
+		if r.state == StateDone {
+			return 0, io.EOF
+		}
+		r.Body = r.Body[:0]
+		b.pos = 0
+		if err := r.parser.readBody(r); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.Body[b.pos:])
+	b.pos += n
+	return n, nil
+}
+
+// Complete reports whether the whole request, body included, has been read.
+func (r *Request) Complete() bool {
+	return r.state == StateDone
+}
+
+// ExpectsContinue reports whether the client sent "Expect: 100-continue" and
+// is waiting for an interim response before sending the body. HTTP/1.0
+// clients can't receive one, so the expectation is ignored for them.
+func (r *Request) ExpectsContinue() bool {
+	return r.RequestLine.HttpVersion != "1.0" &&
+		strings.EqualFold(r.Headers.Get("Expect"), "100-continue")
 }
 
-func (r *Request) parse(data []byte) (int, error) {
+func (r *Request) parse(data []byte, state ParserState) (int, error) {
 	totalBytesParsed := 0
 
-	for r.state != StateDone {
+	for r.state < state {
 		n, err := r.parseSingle(data[totalBytesParsed:])
 		if err != nil {
 			return 0, err
@@ -95,7 +351,7 @@ func (r *Request) parse(data []byte) (int, error) {
 func (r *Request) parseSingle(data []byte) (int, error) {
 	switch r.state {
 	case StateInitialized:
-		n, requestLine, err := parseRequestLine(data)
+		n, requestLine, err := parseRequestLine(data, r.opts)
 		if err != nil {
 			return 0, err
 		}
@@ -108,40 +364,52 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		return n, nil
 
 	case StateParsingHeaders:
-		n, done, err := r.Headers.Parse(data)
+		contentLength, hadContentLength := r.Headers["content-length"]
+		n, done, err := r.Headers.ParseWithOptions(data, r.opts)
 		if err != nil {
 			return 0, err
 		}
+		// Catch a second Content-Length as it arrives, even one with the
+		// same value, rather than leaving it to be merged into a list.
+		if hadContentLength && r.Headers["content-length"] != contentLength {
+			return 0, ErrDuplicateContentLength
+		}
 		if done {
+			if err := r.setFraming(); err != nil {
+				return 0, err
+			}
+			if err := r.setContentCodings(); err != nil {
+				return 0, err
+			}
 			r.state = StateParsingBody
 		}
 
 		return n, nil
-	
+
 	case StateParsingBody:
-		contentLength := r.Headers.Get("Content-Length")
-		if contentLength == "" {
-			r.state = StateDone
-			return len(data), nil
+		if r.chunked {
+			return r.parseChunked(data)
 		}
 
-		num, err := strconv.Atoi(contentLength)
-		if err != nil {
-			return 0, fmt.Errorf("invalid Content-Length value: %v", err)
+		if r.untilEOF {
+			if r.maxBodySize > 0 && int64(r.bodyLengthRead+len(data)) > r.maxBodySize {
+				return 0, ErrBodyTooLarge
+			}
+			r.Body = append(r.Body, data...)
+			r.bodyLengthRead += len(data)
+			return len(data), nil
 		}
 
-		r.Body = append(r.Body, data...)
-		r.bodyLengthRead += len(data)
+		// Anything past Content-Length belongs to the next request.
+		n := min(len(data), r.contentLength-r.bodyLengthRead)
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyLengthRead += n
 
-		if r.bodyLengthRead > num {
-			return 0, fmt.Errorf("data is larger than shared Content-Length")
-		}
-
-		if r.bodyLengthRead == num {
+		if r.bodyLengthRead == r.contentLength {
 			r.state = StateDone
 		}
 
-		return len(data), nil
+		return n, nil
 
 	case StateDone:
 		return 0, errors.New("trying to read error in completed state")
@@ -151,41 +419,190 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
-func parseRequestLine(data []byte) (int, RequestLine, error) {
+func parseRequestLine(data []byte, opts headers.ParseOptions) (int, RequestLine, error) {
 	// Find the end of the request line
-	end := strings.Index(string(data), "\r\n")
+	end, next, bareLF := headers.LineEnd(data, opts.Mode)
 	if end == -1 {
 		return 0, RequestLine{}, nil
 	}
 
 	// Split request line into components
-	requestParts := strings.Split(string(data[:end]), " ")
-	if len(requestParts) != 3 {
+	line := data[:end]
+	method, target, version, ok := splitRequestLine(line)
+	if opts.Mode == headers.Lenient {
+		m, t, v, lenientOK := splitRequestLineLenient(line)
+		// Only a line with extra whitespace splits differently.
+		if lenientOK && (!ok || len(m)+len(t)+len(v)+2 != len(line)) {
+			method, target, version, ok = m, t, v, true
+			opts.Report(LeniencyRequestLineSpaces)
+		}
+	}
+	if !ok {
 		return 0, RequestLine{}, errors.New("invalid request line format")
 	}
+	if bareLF {
+		opts.Report(headers.LeniencyBareLF)
+	}
 
-	method := requestParts[0]
 	if !isValidMethod(method) {
 		return 0, RequestLine{}, errors.New("invalid method")
 	}
 
-	target := requestParts[1]
-	version := requestParts[2]
+	if err := validateRequestTarget(method, target); err != nil {
+		return 0, RequestLine{}, err
+	}
 
-	// Validate version
-	if version != "HTTP/1.1" {
-		return 0, RequestLine{}, errors.New("unsupported HTTP version")
+	httpVersion, err := parseHttpVersion(version)
+	if err != nil {
+		return 0, RequestLine{}, err
 	}
 
-	// Return bytes consumed (request line + CRLF)
-	return end + 2, RequestLine{
-		Method:        method,
-		RequestTarget: target,
-		HttpVersion:   "1.1",
+	// Return bytes consumed (request line + line ending)
+	return next, RequestLine{
+		Method:        methodString(method),
+		RequestTarget: string(target),
+		HttpVersion:   httpVersion,
 	}, nil
 }
 
-func isValidMethod(method string) bool {
+// splitRequestLine splits "method SP request-target SP HTTP-version".
+func splitRequestLine(line []byte) (method, target, version []byte, ok bool) {
+	methodEnd := bytes.IndexByte(line, ' ')
+	if methodEnd == -1 {
+		return nil, nil, nil, false
+	}
+	method = line[:methodEnd]
+	target = line[methodEnd+1:]
+	targetEnd := bytes.IndexByte(target, ' ')
+	if targetEnd == -1 {
+		return nil, nil, nil, false
+	}
+	version = target[targetEnd+1:]
+	target = target[:targetEnd]
+	if len(method) == 0 || len(target) == 0 || bytes.IndexByte(version, ' ') != -1 {
+		return nil, nil, nil, false
+	}
+	return method, target, version, true
+}
+
+// splitRequestLineLenient splits the request line on runs of spaces and
+// tabs, tolerating extra whitespace between and around the parts.
+func splitRequestLineLenient(line []byte) (method, target, version []byte, ok bool) {
+	parts := bytes.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' })
+	if len(parts) != 3 {
+		return nil, nil, nil, false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+// validateRequestTarget checks the target is in one of the four forms of
+// RFC 9112 section 3.2 and made of visible ASCII with valid percent-escapes.
+func validateRequestTarget(method, target []byte) error {
+	for i := 0; i < len(target); i++ {
+		c := target[i]
+		if c <= ' ' || c >= 0x7f {
+			return errors.New("invalid character in request target")
+		}
+		if c == '%' && (i+2 >= len(target) || !isHex(target[i+1]) || !isHex(target[i+2])) {
+			return errors.New("invalid escape in request target")
+		}
+	}
+
+	switch {
+	case target[0] == '/':
+		// origin-form
+		return nil
+	case len(target) == 1 && target[0] == '*':
+		// asterisk-form, only meaningful for OPTIONS
+		if string(method) != "OPTIONS" {
+			return errors.New("invalid request target: * is only allowed for OPTIONS")
+		}
+		return nil
+	case string(method) == "CONNECT":
+		// authority-form: host:port
+		u, err := url.ParseRequestURI("http://" + string(target))
+		if err != nil || u.Host == "" || u.Port() == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+			return errors.New("invalid request target: CONNECT needs host:port")
+		}
+		return nil
+	case hasPrefixFold(target, "http://") || hasPrefixFold(target, "https://"):
+		// absolute-form
+		if _, err := url.ParseRequestURI(string(target)); err != nil {
+			return fmt.Errorf("invalid request target: %w", err)
+		}
+		return nil
+	default:
+		return errors.New("invalid request target")
+	}
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func hasPrefixFold(b []byte, prefix string) bool {
+	return len(b) >= len(prefix) && strings.EqualFold(string(b[:len(prefix)]), prefix)
+}
+
+// methodString converts a method to a string without allocating for the
+// standard methods.
+func methodString(method []byte) string {
+	switch string(method) {
+	case "GET":
+		return "GET"
+	case "HEAD":
+		return "HEAD"
+	case "POST":
+		return "POST"
+	case "PUT":
+		return "PUT"
+	case "DELETE":
+		return "DELETE"
+	case "PATCH":
+		return "PATCH"
+	case "OPTIONS":
+		return "OPTIONS"
+	case "CONNECT":
+		return "CONNECT"
+	case "TRACE":
+		return "TRACE"
+	}
+	return string(method)
+}
+
+// parseHttpVersion validates an HTTP-version token ("HTTP/1.1") and returns
+// the version this server will treat the request as. Later 1.x minors are
+// handled as 1.1; other major versions yield ErrUnsupportedVersion.
+func parseHttpVersion(version []byte) (string, error) {
+	digits, ok := bytes.CutPrefix(version, []byte("HTTP/"))
+	if !ok || len(digits) != 3 || digits[1] != '.' ||
+		digits[0] < '0' || digits[0] > '9' || digits[2] < '0' || digits[2] > '9' {
+		return "", errors.New("invalid HTTP version")
+	}
+
+	if digits[0] != '1' {
+		return "", ErrUnsupportedVersion
+	}
+	if digits[2] == '0' {
+		return "1.0", nil
+	}
+	return "1.1", nil
+}
+
+// KeepAlive reports whether the client asked for the connection to stay open
+// after this request: the default on HTTP/1.1 unless "Connection: close" was
+// sent, and only on an explicit "Connection: keep-alive" on HTTP/1.0.
+func (r *Request) KeepAlive() bool {
+	if r.RequestLine.HttpVersion == "1.0" {
+		return r.Headers.HasToken("Connection", "keep-alive")
+	}
+	return !r.Headers.HasToken("Connection", "close")
+}
+
+func isValidMethod(method []byte) bool {
+	if len(method) == 0 {
+		return false
+	}
 	for _, c := range method {
 		if c < 'A' || c > 'Z' {
 			return false
@@ -193,3 +610,60 @@ func isValidMethod(method string) bool {
 	}
 	return true
 }
+
+// ValidateHost enforces the Host header rules of RFC 9112 section 3.2: an
+// HTTP/1.1 request carries exactly one Host header. HTTP/1.0 clients may omit
+// it.
+func (r *Request) ValidateHost() error {
+	host, ok := r.Headers["host"]
+	if !ok {
+		if r.RequestLine.HttpVersion == "1.0" {
+			return nil
+		}
+		return ErrInvalidHost
+	}
+
+	// Duplicate headers are joined with ", " while parsing, and a comma is
+	// never valid inside a host.
+	if strings.ContainsAny(host, ", \t/?#@") {
+		return ErrInvalidHost
+	}
+	return nil
+}
+
+// Host returns the lowercased host the request is addressed to, without any
+// port. An absolute-form request target takes precedence over the Host
+// header.
+func (r *Request) Host() string {
+	host := r.Headers.Get("Host")
+	if rest, ok := strings.CutPrefix(r.RequestLine.RequestTarget, "http://"); ok {
+		host, _, _ = strings.Cut(rest, "/")
+	} else if rest, ok := strings.CutPrefix(r.RequestLine.RequestTarget, "https://"); ok {
+		host, _, _ = strings.Cut(rest, "/")
+	}
+
+	if strings.HasPrefix(host, "[") {
+		// IPv6 literal: "[::1]:8080"
+		if end := strings.IndexByte(host, ']'); end != -1 {
+			host = host[:end+1]
+		}
+	} else if colon := strings.LastIndexByte(host, ':'); colon != -1 {
+		host = host[:colon]
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// Cookies parses the cookies sent in the request's Cookie header.
+func (r *Request) Cookies() []*cookie.Cookie {
+	return cookie.Parse(r.Headers.Get("Cookie"))
+}
+
+// Cookie returns the first cookie named name, or ErrNoCookie.
+func (r *Request) Cookie(name string) (*cookie.Cookie, error) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, ErrNoCookie
+}
@@ -1,6 +1,8 @@
 package request
 
 import (
+	"chillhttp/internal/headers"
+	"context"
 	"io"
 	"strings"
 	"testing"
@@ -72,8 +74,46 @@ func TestInvalidMethod(t *testing.T) {
 }
 
 func TestInvalidVersion(t *testing.T) {
-	_, err := RequestFromReader(strings.NewReader("GET /coffee HTTP/1.0\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"))
+	_, err := RequestFromReader(strings.NewReader("GET /coffee HTTP/1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"))
 	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnsupportedVersion)
+
+	_, err = RequestFromReader(strings.NewReader("GET /coffee HTTP/2.0\r\nHost: localhost:42069\r\n\r\n"))
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestHttp10RequestLine(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET /coffee HTTP/1.0\r\nUser-Agent: ApacheBench/2.3\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+
+	// Later 1.x minor versions are served as 1.1.
+	r, err = RequestFromReader(strings.NewReader("GET /coffee HTTP/1.2\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.1", r.RequestLine.HttpVersion)
+}
+
+func TestKeepAlive(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+}
+
+func TestEmptyConnection(t *testing.T) {
+	_, err := RequestFromReader(strings.NewReader(""))
+	require.ErrorIs(t, err, io.EOF)
 }
 
 func GoodGetRequestLine(t *testing.T) {
@@ -215,3 +255,426 @@ func TestBodyParse(t *testing.T) {
 	require.NotNil(t, r)
 	assert.Equal(t, "", string(r.Body))
 }
+
+func TestValidateHost(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.NoError(t, r.ValidateHost())
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.ValidateHost(), ErrInvalidHost)
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a.test\r\nHost: b.test\r\n\r\n"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.ValidateHost(), ErrInvalidHost)
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: a.test/evil\r\n\r\n"))
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.ValidateHost(), ErrInvalidHost)
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.NoError(t, r.ValidateHost())
+}
+
+func TestHost(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: API.Example.test:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "api.example.test", r.Host())
+
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: [::1]:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]", r.Host())
+
+	r, err = RequestFromReader(strings.NewReader("GET http://other.test/coffee HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "other.test", r.Host())
+}
+
+func TestHeadersFromReaderDefersBody(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"Expect: 100-continue\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := HeadersFromReader(reader)
+	require.NoError(t, err)
+	assert.True(t, r.ExpectsContinue())
+	assert.False(t, r.Complete())
+	assert.Equal(t, "", string(r.Body))
+
+	calls := 0
+	r.BeforeBodyRead(func() error {
+		calls++
+		return nil
+	})
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.True(t, r.Complete())
+
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestBeforeBodyReadSkippedWithoutBody(t *testing.T) {
+	r, err := HeadersFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+
+	r.BeforeBodyRead(func() error {
+		t.Fatal("no body to wait for")
+		return nil
+	})
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+}
+
+func TestParserPipelinedRequests(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /first HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello" +
+			"GET /second HTTP/1.1\r\nHost: localhost:42069\r\n\r\n" +
+			"POST /third HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n",
+		numBytesPerRead: 7,
+	}
+	p := NewParser(reader)
+
+	targets := []string{"/first", "/second", "/third"}
+	bodies := []string{"hello", "", "abc"}
+	for i := range targets {
+		r, err := p.Next()
+		require.NoError(t, err)
+		body, err := r.ReadBody()
+		require.NoError(t, err)
+		assert.Equal(t, targets[i], r.RequestLine.RequestTarget)
+		assert.Equal(t, bodies[i], string(body))
+	}
+
+	_, err := p.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestParserDiscardsUnreadBody(t *testing.T) {
+	p := NewParser(strings.NewReader(
+		"POST /first HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello" +
+			"GET /second HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+
+	_, err := p.Next()
+	require.NoError(t, err)
+	r, err := p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+}
+
+func TestChunkedBodyParse(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6;name=value\r\nhello \r\n" +
+			"7\r\nworld!\n\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "abc", r.Trailers.Get("X-Checksum"))
+
+	// Test: Invalid chunk size
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"))
+	require.Error(t, err)
+
+	// Test: Signed chunk size
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n-1\r\n"))
+	require.Error(t, err)
+
+	// Test: Chunk size that would overflow
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nffffffffffffffffff\r\n"))
+	require.Error(t, err)
+
+	// Test: Chunk data longer than its size
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nabc\r\n0\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Body ends before the last chunk
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n"))
+	require.Error(t, err)
+}
+
+const benchRequest = "POST /coffee HTTP/1.1\r\n" +
+	"Host: localhost:42069\r\n" +
+	"User-Agent: curl/7.81.0\r\n" +
+	"Accept: */*\r\n" +
+	"Accept-Encoding: gzip, deflate\r\n" +
+	"Content-Type: application/json\r\n" +
+	"Content-Length: 25\r\n" +
+	"\r\n" +
+	`{"type": "flat white x2"}`
+
+func BenchmarkRequestFromReader(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchRequest)))
+	reader := strings.NewReader(benchRequest)
+	for i := 0; i < b.N; i++ {
+		reader.Reset(benchRequest)
+		if _, err := RequestFromReader(reader); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParserNext(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchRequest)))
+	reader := &repeatReader{data: benchRequest}
+	p := NewParser(reader)
+	for i := 0; i < b.N; i++ {
+		r, err := p.Next()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := r.ReadBody(); err != nil {
+			b.Fatal(err)
+		}
+		r.Release()
+	}
+}
+
+// repeatReader serves the same request over and over, like a client keeping
+// one connection busy.
+type repeatReader struct {
+	data string
+	pos  int
+}
+
+func (rr *repeatReader) Read(p []byte) (int, error) {
+	n := copy(p, rr.data[rr.pos:])
+	rr.pos = (rr.pos + n) % len(rr.data)
+	return n, nil
+}
+
+func TestHeaderTooLarge(t *testing.T) {
+	huge := "GET / HTTP/1.1\r\nX-Huge: " + strings.Repeat("a", 2<<20) + "\r\n\r\n"
+	_, err := RequestFromReader(strings.NewReader(huge))
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+}
+
+func TestReleasedRequestIsReset(t *testing.T) {
+	p := NewParser(strings.NewReader(
+		"POST /first HTTP/1.1\r\nHost: a.test\r\nContent-Length: 5\r\n\r\nhello" +
+			"GET /second HTTP/1.1\r\nHost: b.test\r\n\r\n"))
+	defer p.Release()
+
+	r, err := p.Next()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	r.Release()
+
+	r, err = p.Next()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Equal(t, map[string]string{"host": "b.test"}, map[string]string(r.Headers))
+	assert.Empty(t, r.Body)
+}
+
+func lenientParser(data string, applied *[]headers.Leniency) *Parser {
+	return NewParserWithOptions(&chunkReader{data: data, numBytesPerRead: 3}, Options{
+		Mode:       headers.Lenient,
+		OnLeniency: func(l headers.Leniency) { *applied = append(*applied, l) },
+	})
+}
+
+func TestLenientRequest(t *testing.T) {
+	var applied []headers.Leniency
+	p := lenientParser("GET  /coffee   HTTP/1.1 \n"+
+		"Host: localhost:42069\n"+
+		"X-Folded: one\n two\n"+
+		"\n", &applied)
+
+	r, err := p.Next()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "GET", r.RequestLine.Method)
+	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
+	assert.Equal(t, "1.1", r.RequestLine.HttpVersion)
+	assert.Equal(t, "localhost:42069", r.Headers.Get("Host"))
+	assert.Equal(t, "one two", r.Headers.Get("X-Folded"))
+
+	assert.Contains(t, applied, LeniencyRequestLineSpaces)
+	assert.Contains(t, applied, headers.LeniencyBareLF)
+	assert.Contains(t, applied, headers.LeniencyObsFold)
+}
+
+func TestLenientChunkedBody(t *testing.T) {
+	var applied []headers.Leniency
+	p := lenientParser("POST / HTTP/1.1\n"+
+		"Host: localhost:42069\n"+
+		"Transfer-Encoding: chunked\n"+
+		"\n"+
+		"5\nhello\n0\n\n", &applied)
+
+	r, err := p.Next()
+	require.NoError(t, err)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Contains(t, applied, headers.LeniencyBareLF)
+}
+
+func TestLenientTabsInRequestLine(t *testing.T) {
+	var applied []headers.Leniency
+	p := lenientParser("GET\t/coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n", &applied)
+
+	r, err := p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/coffee", r.RequestLine.RequestTarget)
+	assert.Equal(t, []headers.Leniency{LeniencyRequestLineSpaces}, applied)
+}
+
+func TestStrictIsDefault(t *testing.T) {
+	for _, data := range []string{
+		"GET  /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		"GET /coffee HTTP/1.1\nHost: localhost:42069\n\n",
+		"GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\nX-Folded: one\r\n two\r\n\r\n",
+	} {
+		_, err := RequestFromReader(strings.NewReader(data))
+		assert.Error(t, err, data)
+	}
+}
+
+func TestConformingRequestReportsNoLeniency(t *testing.T) {
+	var applied []headers.Leniency
+	p := lenientParser("POST / HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 5\r\n\r\nhello", &applied)
+
+	r, err := p.Next()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestAmbiguousFraming(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers string
+		err     error
+	}{
+		{"duplicate content-length", "Content-Length: 5\r\nContent-Length: 5\r\n", ErrDuplicateContentLength},
+		{"conflicting content-length", "Content-Length: 5\r\nContent-Length: 6\r\n", ErrDuplicateContentLength},
+		{"content-length list", "Content-Length: 5, 5\r\n", ErrInvalidContentLength},
+		{"signed content-length", "Content-Length: +5\r\n", ErrInvalidContentLength},
+		{"content-length with transfer-encoding", "Content-Length: 5\r\nTransfer-Encoding: chunked\r\n", ErrContentLengthWithTransferEncoding},
+		{"chunked not last", "Transfer-Encoding: chunked, gzip\r\n", ErrInvalidTransferEncoding},
+		{"chunked twice", "Transfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n", ErrInvalidTransferEncoding},
+		{"unknown coding only", "Transfer-Encoding: gzip\r\n", ErrInvalidTransferEncoding},
+		{"gzip then chunked", "Transfer-Encoding: gzip, chunked\r\n", ErrUnsupportedTransferEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\n" + tt.headers + "\r\n0\r\n\r\nhello"))
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+
+	_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrInvalidTransferEncoding)
+}
+
+func TestCookies(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Cookie: session=abc; theme=dark\r\n" +
+		"Cookie: lang=en\r\n" +
+		"\r\n"))
+	require.NoError(t, err)
+
+	cookies := r.Cookies()
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "lang", cookies[2].Name)
+
+	c, err := r.Cookie("theme")
+	require.NoError(t, err)
+	assert.Equal(t, "dark", c.Value)
+
+	_, err = r.Cookie("missing")
+	assert.ErrorIs(t, err, ErrNoCookie)
+}
+
+func TestBodyReaderStreams(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n" +
+			"6\r\nworld!\r\n" +
+			"0\r\n\r\n",
+		numBytesPerRead: 4,
+	}
+	p := NewParser(reader)
+	r, err := p.Next()
+	require.NoError(t, err)
+
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(body))
+	assert.True(t, r.Complete())
+	assert.Less(t, len(r.Body), len(body))
+}
+
+func TestNewRequest(t *testing.T) {
+	line := RequestLine{Method: "POST", RequestTarget: "/submit", HttpVersion: "2"}
+
+	// Without a Content-Length the body runs to EOF.
+	r, err := NewRequest(line, headers.Headers{"host": "localhost"}, strings.NewReader("hello world!"), Options{})
+	require.NoError(t, err)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(body))
+	assert.Equal(t, "localhost", r.Host())
+	assert.True(t, r.Complete())
+	r.Release()
+
+	r, err = NewRequest(line, headers.Headers{"content-length": "5"}, strings.NewReader("hello"), Options{})
+	require.NoError(t, err)
+	body, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	r.Release()
+
+	_, err = NewRequest(line, headers.Headers{"content-length": "x"}, strings.NewReader(""), Options{})
+	assert.ErrorIs(t, err, ErrInvalidContentLength)
+
+	r, err = NewRequest(line, headers.Headers{}, strings.NewReader("too long"), Options{MaxBodySize: 4})
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+	r.Release()
+}
+
+func TestContext(t *testing.T) {
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, context.Background(), r.Context())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Same(t, r, r.WithContext(ctx))
+	assert.Equal(t, ctx, r.Context())
+
+	r.Release()
+	assert.Equal(t, context.Background(), r.Context())
+}
@@ -0,0 +1,63 @@
+package response
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"chillhttp/internal/headers"
+)
+
+// WriteJSON writes a complete response with v encoded as its JSON body.
+func WriteJSON(w *Writer, statusCode StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeComplete(w, statusCode, "application/json", body)
+}
+
+// Problem is an RFC 9457 problem details object, the body of an
+// application/problem+json error response.
+type Problem struct {
+	// Type is a URI identifying the kind of problem; empty means
+	// "about:blank", a problem described by its status code alone.
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// NewProblem returns a Problem titled with the reason phrase of statusCode.
+func NewProblem(statusCode StatusCode, detail string) *Problem {
+	return &Problem{
+		Title:  StatusText(statusCode),
+		Status: int(statusCode),
+		Detail: detail,
+	}
+}
+
+// WriteProblem writes a complete application/problem+json response for p.
+func WriteProblem(w *Writer, p *Problem) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return writeComplete(w, StatusCode(p.Status), "application/problem+json", body)
+}
+
+// writeComplete writes a whole response with a body of the given type.
+func writeComplete(w *Writer, statusCode StatusCode, contentType string, body []byte) error {
+	h := headers.NewHeaders()
+	h["Content-Type"] = contentType
+	h["Content-Length"] = strconv.Itoa(len(body))
+
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	_, err := w.WriteBody(body)
+	return err
+}
@@ -1,47 +1,310 @@
 package response
 
 import (
+	"bytes"
+	"chillhttp/internal/cookie"
+	"chillhttp/internal/digest"
 	"chillhttp/internal/headers"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net"
+	"strconv"
+	"strings"
 )
 
 type StatusCode int
 
 const (
-	OK                  StatusCode = 200
-	BadRequest          StatusCode = 400
-	InternalServerError StatusCode = 500
+	Continue                    StatusCode = 100
+	SwitchingProtocols          StatusCode = 101
+	EarlyHints                  StatusCode = 103
+	OK                          StatusCode = 200
+	NoContent                   StatusCode = 204
+	NotModified                 StatusCode = 304
+	BadRequest                  StatusCode = 400
+	Forbidden                   StatusCode = 403
+	NotFound                    StatusCode = 404
+	MethodNotAllowed            StatusCode = 405
+	NotAcceptable               StatusCode = 406
+	ContentTooLarge             StatusCode = 413
+	UnsupportedMediaType        StatusCode = 415
+	ExpectationFailed           StatusCode = 417
+	UpgradeRequired             StatusCode = 426
+	RequestHeaderFieldsTooLarge StatusCode = 431
+	InternalServerError         StatusCode = 500
+	NotImplemented              StatusCode = 501
+	BadGateway                  StatusCode = 502
+	ServiceUnavailable          StatusCode = 503
+	GatewayTimeout              StatusCode = 504
+	HttpVersionNotSupported     StatusCode = 505
 )
 
+var statusText = map[StatusCode]string{
+	Continue:                    "Continue",
+	SwitchingProtocols:          "Switching Protocols",
+	EarlyHints:                  "Early Hints",
+	OK:                          "OK",
+	NoContent:                   "No Content",
+	NotModified:                 "Not Modified",
+	BadRequest:                  "Bad Request",
+	Forbidden:                   "Forbidden",
+	NotFound:                    "Not Found",
+	MethodNotAllowed:            "Method Not Allowed",
+	NotAcceptable:               "Not Acceptable",
+	ContentTooLarge:             "Content Too Large",
+	UnsupportedMediaType:        "Unsupported Media Type",
+	ExpectationFailed:           "Expectation Failed",
+	UpgradeRequired:             "Upgrade Required",
+	RequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+	InternalServerError:         "Internal Server Error",
+	NotImplemented:              "Not Implemented",
+	BadGateway:                  "Bad Gateway",
+	ServiceUnavailable:          "Service Unavailable",
+	GatewayTimeout:              "Gateway Timeout",
+	HttpVersionNotSupported:     "HTTP Version Not Supported",
+}
+
+// StatusText returns the reason phrase for code, or "" if it is unknown.
+func StatusText(code StatusCode) string {
+	return statusText[code]
+}
+
 type Writer struct {
 	Writer io.Writer
-	State WriteState
+	State  WriteState
+	// HttpVersion is the version of the request being answered. Responses to
+	// "1.0" clients never use chunked framing. Empty means "1.1".
+	HttpVersion string
+	// KeepAlive reports whether the connection may carry another response
+	// after this one. The server seeds it from the request; WriteHeaders
+	// clears it when the response has to close the connection.
+	KeepAlive bool
+	// DigestAlgorithms names the algorithms, such as "sha-256" and
+	// "sha-512", of a Content-Digest (RFC 9530) computed over the body as it
+	// is written. It goes in the headers of a fixed-length body, which are
+	// held back until WriteBody, and in the trailers of a chunked one.
+	DigestAlgorithms []string
+
+	contentLength int
+	bodyWritten   int
+	// bytesSent counts the body bytes that went out, after any content
+	// coding and without framing.
+	bytesSent  int64
+	chunked    bool
+	closeBody  bool
+	noBody     bool // for the statuses that never have a body
+	statusCode StatusCode
+	filters    []Filter
+	// encoder, when a Filter supplied one, encodes the body on its way to
+	// the chunked framing.
+	encoder io.WriteCloser
+	// digest hashes the body as sent, after any content coding.
+	digest *digest.Hasher
+	// pendingHeaders holds the header block of a fixed-length body until
+	// its Content-Digest is known.
+	pendingHeaders []byte
+
+	// transport, if set, carries the response in place of Writer.
+	transport Transport
+	// pendingFields holds the header fields of a fixed-length body sent
+	// through a Transport until its Content-Digest is known.
+	pendingFields headers.Headers
+
+	// hijacker, if set, hands the connection over to the handler, after
+	// which hijacked is set and nothing more is written.
+	hijacker Hijacker
+	hijacked bool
+	// aborted is set by Abort, after which nothing more is written.
+	aborted bool
+
+	// setCookies holds serialized cookies. Headers can only hold one value
+	// per key, and Set-Cookie lines can't be comma-joined (RFC 6265
+	// section 3), so WriteHeaders sends each on its own line.
+	setCookies []string
 }
 
 type WriteState int
 
+// A Transport carries a response over a protocol other than HTTP/1.1, such
+// as HTTP/2, which frames the status, header fields and body itself. The
+// Writer still runs its filters, digests and cookies, then hands the pieces
+// to the Transport instead of writing HTTP/1.1 bytes.
+type Transport interface {
+	// WriteInterim sends a 1xx informational response.
+	WriteInterim(statusCode StatusCode, h headers.Headers) error
+	// WriteHeader sends the final status and header fields, and the
+	// serialized cookies as separate Set-Cookie fields.
+	WriteHeader(statusCode StatusCode, h headers.Headers, cookies []string) error
+	// WriteData sends part of the body.
+	WriteData(p []byte) (int, error)
+	// WriteTrailers ends the response with trailer fields, which may be
+	// empty.
+	WriteTrailers(h headers.Headers) error
+}
+
+// An Encoder wraps the destination of a response body, e.g. to compress it.
+// Closing the returned writer flushes whatever it still buffers.
+type Encoder func(dst io.Writer) io.WriteCloser
+
+// A Filter looks at a response's headers as WriteHeaders sends them and may
+// change them. To transform the body as well it returns an Encoder; the
+// Writer then drops Content-Length and frames the encoded body as chunked.
+// It returns nil to leave the body alone.
+type Filter func(statusCode StatusCode, h headers.Headers) Encoder
+
 const (
-	StateWriteStatusLine  WriteState = iota
+	StateWriteStatusLine WriteState = iota
 	StateWriteHeaders
 	StateWriteBody
+	StateWriteTrailers
 	StateDone
 )
 
 // NewResponseWriter creates a new ResponseWriter instance
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{
-		Writer:     w,
-		State:      StateWriteStatusLine,
+		Writer:        w,
+		State:         StateWriteStatusLine,
+		contentLength: -1,
+	}
+}
+
+// NewTransportWriter returns a Writer that sends the response through t.
+func NewTransportWriter(t Transport) *Writer {
+	w := NewWriter(nil)
+	w.transport = t
+	w.KeepAlive = true
+	return w
+}
+
+// A Hijacker takes a connection away from the server, returning it with the
+// bytes the server read from it but didn't parse yet.
+type Hijacker func() (net.Conn, []byte, error)
+
+var (
+	// ErrNotHijackable is returned by Hijack when the response isn't
+	// carried by a connection of its own, as on HTTP/2.
+	ErrNotHijackable = errors.New("connection can't be hijacked")
+	// ErrHijacked is returned by writes after Hijack, and by Hijack itself
+	// the second time.
+	ErrHijacked = errors.New("connection has been hijacked")
+	// ErrAborted is returned by writes after Abort.
+	ErrAborted = errors.New("response has been aborted")
+)
+
+// SetHijacker lets Hijack take the connection with h, or stops it with nil.
+// The server sets it for HTTP/1.x connections while the handler runs.
+func (w *Writer) SetHijacker(h Hijacker) {
+	w.hijacker = h
+}
+
+// Hijack takes over the connection the response would have been written to,
+// e.g. to speak another protocol after a 101 Switching Protocols or to
+// tunnel a CONNECT request. It returns the connection with the bytes the
+// server had read from it but not parsed yet, such as the start of the next
+// pipelined request; reading goes on from the connection after those. The
+// server then neither writes to the connection nor closes it: that is up to
+// the caller. It must be called before the handler returns.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.hijacker == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	conn, buffered, err := w.hijacker()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	return conn, buffered, nil
+}
+
+// Status returns the status code of the response, or 0 before
+// WriteStatusLine.
+func (w *Writer) Status() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten returns how many body bytes have been sent, as they went on
+// the wire after any content coding, but without chunked framing.
+func (w *Writer) BytesWritten() int64 {
+	return w.bytesSent
+}
+
+// Abort gives up on a response that was started but can't be completed,
+// e.g. when its source fails halfway through the body. Nothing more is
+// written, not even the end of a chunked or encoded body, and Finish reports
+// that the connection can't be reused, so the client sees the response fail
+// instead of taking what it got for the whole body. Over HTTP/2 the stream
+// is reset.
+func (w *Writer) Abort() {
+	w.aborted = true
+	w.encoder = nil
+	w.KeepAlive = false
+}
+
+// Aborted reports whether Abort was called.
+func (w *Writer) Aborted() bool {
+	return w.aborted
+}
+
+// unusable returns the error writes fail with after Hijack or Abort.
+func (w *Writer) unusable() error {
+	switch {
+	case w.hijacked:
+		return ErrHijacked
+	case w.aborted:
+		return ErrAborted
 	}
+	return nil
+}
+
+// AddFilter registers f to run when the headers are written. It must be
+// called before WriteHeaders.
+func (w *Writer) AddFilter(f Filter) {
+	w.filters = append(w.filters, f)
 }
 
 func (w *Writer) WriteBody(p []byte) (int, error) {
+	if err := w.unusable(); err != nil {
+		return 0, err
+	}
 	if w.State != StateWriteBody {
 		return 0, fmt.Errorf("invalid state: expected StateWriteHeaders, got %v", w.State)
 	}
+	if w.noBody {
+		if len(p) > 0 {
+			return 0, ErrBodyNotAllowed
+		}
+		w.State = StateDone
+		return 0, nil
+	}
 
-	length, err := w.Writer.Write([]byte(p))
+	if w.encoder != nil {
+		// WriteBody sends the whole body, so the encoding ends here too.
+		length, err := w.encoder.Write(p)
+		w.bodyWritten += length
+		if err != nil {
+			return length, err
+		}
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return length, err
+		}
+		return length, w.WriteTrailers(nil)
+	}
+
+	if w.headersPending() {
+		w.digest.Write(p)
+		if err := w.flushHeaders(); err != nil {
+			return 0, err
+		}
+	}
+
+	length, err := w.write(p)
+	w.bodyWritten += length
 	if err != nil {
 		return length, err
 	}
@@ -51,29 +314,76 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	if err := w.unusable(); err != nil {
+		return err
+	}
 	if w.State != StateWriteStatusLine {
 		return fmt.Errorf("invalid state: expected StateInitialized, got %v", w.State)
 	}
 
-	statusLine := ""
-	switch statusCode {
-		case OK:
-			statusLine = "HTTP/1.1 200 OK\r\n"
-		case BadRequest:
-			statusLine = "HTTP/1.1 400 Bad Request\r\n"
-		case InternalServerError:
-			statusLine = "HTTP/1.1 500 Internal Server Error\r\n"
-		default:
-			statusLine = fmt.Sprintf("HTTP/1.1 %d \r\n", statusCode)
+	if w.transport == nil {
+		statusLine := fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCode, StatusText(statusCode))
+		_, err := w.Writer.Write([]byte(statusLine))
+		if err != nil {
+			return err
+		}
 	}
 
-	_, err := w.Writer.Write([]byte(statusLine))
-	if err != nil {
+	w.statusCode = statusCode
+	w.State = StateWriteHeaders
+
+	return nil
+}
+
+// WriteInterim sends a 1xx informational response ahead of the final one. It
+// may be called any number of times before WriteStatusLine. HTTP/1.0 clients
+// don't understand 1xx responses, so for them it does nothing.
+func (w *Writer) WriteInterim(statusCode StatusCode, h headers.Headers) error {
+	if err := w.unusable(); err != nil {
 		return err
 	}
+	if w.State != StateWriteStatusLine {
+		return fmt.Errorf("invalid state: expected StateWriteStatusLine, got %v", w.State)
+	}
+	if statusCode < 100 || statusCode > 199 || statusCode == SwitchingProtocols {
+		return fmt.Errorf("invalid interim status code: %d", statusCode)
+	}
+	if w.HttpVersion == "1.0" {
+		return nil
+	}
+	if w.transport != nil {
+		return w.transport.WriteInterim(statusCode, h)
+	}
 
-	w.State = StateWriteHeaders
-	
+	var b strings.Builder
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", statusCode, StatusText(statusCode))
+	for key, value := range h {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+	}
+	b.WriteString("\r\n")
+
+	_, err := w.Writer.Write([]byte(b.String()))
+	return err
+}
+
+// WriteEarlyHints sends a 103 Early Hints response carrying the given Link
+// header values, e.g. "</style.css>; rel=preload; as=style".
+func (w *Writer) WriteEarlyHints(links ...string) error {
+	h := headers.NewHeaders()
+	h["Link"] = strings.Join(links, ", ")
+	return w.WriteInterim(EarlyHints, h)
+}
+
+// SetCookie adds a Set-Cookie header line for c to the response. It must be
+// called before WriteHeaders.
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if w.State != StateWriteStatusLine && w.State != StateWriteHeaders {
+		return fmt.Errorf("invalid state: headers already written, got %v", w.State)
+	}
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	w.setCookies = append(w.setCookies, c.String())
 	return nil
 }
 
@@ -81,10 +391,14 @@ func GetDefaultHeaders(contentLen int) headers.Headers {
 	h := headers.NewHeaders()
 	h["Content-Type"] = "text/plain"
 	h["Content-Length"] = fmt.Sprintf("%d", contentLen)
-	h["Connection"] = "close"
 	return h
 }
 
+// GetDefaultTrailerHeaders returns the custom X-Content-Sha256 and
+// X-Content-Length trailers.
+//
+// Deprecated: set Writer.DigestAlgorithms to send a standard Content-Digest
+// trailer instead.
 func GetDefaultTrailerHeaders(contentLen int, sha string) headers.Headers {
 	h := headers.NewHeaders()
 	h["X-Content-Sha256"] = sha
@@ -93,26 +407,300 @@ func GetDefaultTrailerHeaders(contentLen int, sha string) headers.Headers {
 }
 
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
+	if err := w.unusable(); err != nil {
+		return err
+	}
 	if w.State != StateWriteHeaders {
 		return fmt.Errorf("invalid state: expected StateWriteStatusLine, got %v", w.State)
 	}
+
+	headers = w.filter(headers)
+	if w.statusCode == SwitchingProtocols {
+		return w.writeSwitchingProtocols(headers)
+	}
+	headers = w.frame(headers)
+	headers = w.setupDigest(headers)
+
+	if w.transport != nil {
+		w.State = StateWriteBody
+		if w.digest != nil && !w.chunked {
+			w.pendingFields = headers
+			return nil
+		}
+		return w.transport.WriteHeader(w.statusCode, headers, w.setCookies)
+	}
+
+	var b bytes.Buffer
 	for key, value := range headers {
-		_, err := w.Writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, value)))
-		if err != nil {
-			return err
+		fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+	}
+	for _, c := range w.setCookies {
+		b.WriteString("Set-Cookie: " + c + "\r\n")
+	}
+	w.State = StateWriteBody
+
+	if w.digest != nil && !w.chunked {
+		// The Content-Digest header depends on the body.
+		w.pendingHeaders = append(make([]byte, 0, b.Len()+128), b.Bytes()...)
+		return nil
+	}
+	b.WriteString("\r\n")
+	_, err := w.Writer.Write(b.Bytes())
+	return err
+}
+
+// writeSwitchingProtocols ends a 101 response at its headers: it has no
+// body, and the connection speaks another protocol afterwards, so it is
+// never reused for HTTP.
+func (w *Writer) writeSwitchingProtocols(h headers.Headers) error {
+	if w.transport != nil {
+		return errors.New("can't switch protocols over this transport")
+	}
+	w.KeepAlive = false
+	w.State = StateDone
+
+	var b bytes.Buffer
+	for key, value := range h {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+	}
+	for _, c := range w.setCookies {
+		b.WriteString("Set-Cookie: " + c + "\r\n")
+	}
+	b.WriteString("\r\n")
+	_, err := w.Writer.Write(b.Bytes())
+	return err
+}
+
+// setupDigest starts hashing the body if DigestAlgorithms asks for it and
+// the framing leaves a way to send the result: in the held-back headers of
+// a fixed-length body or the trailers of a chunked one.
+func (w *Writer) setupDigest(h headers.Headers) headers.Headers {
+	if len(w.DigestAlgorithms) == 0 || w.closeBody || w.noBody {
+		return h
+	}
+	w.digest = digest.NewHasher(w.DigestAlgorithms...)
+	if w.digest == nil || !w.chunked {
+		return h
+	}
+
+	if !h.HasToken("Trailer", "Content-Digest") {
+		if trailer := h.Get("Trailer"); trailer != "" {
+			h.Set("Trailer", trailer+", Content-Digest")
+		} else {
+			h.Set("Trailer", "Content-Digest")
 		}
 	}
-	_, err := w.Writer.Write([]byte("\r\n"))
-	if err != nil {
-		return err
+	return h
+}
+
+// headersPending reports whether the headers of a fixed-length body are
+// held back for its Content-Digest.
+func (w *Writer) headersPending() bool {
+	return w.pendingHeaders != nil || w.pendingFields != nil
+}
+
+// flushHeaders sends the held-back header block of a fixed-length body with
+// the Content-Digest of what has been hashed.
+func (w *Writer) flushHeaders() error {
+	if w.transport != nil {
+		h := w.pendingFields
+		w.pendingFields = nil
+		h.Set("Content-Digest", w.digest.Value())
+		return w.transport.WriteHeader(w.statusCode, h, w.setCookies)
 	}
 
-	w.State = StateWriteBody
+	b := append(w.pendingHeaders, "Content-Digest: "+w.digest.Value()+"\r\n\r\n"...)
+	w.pendingHeaders = nil
+	_, err := w.Writer.Write(b)
+	return err
+}
+
+// filter runs the registered filters over a copy of h, setting up the body
+// encoder if any of them asks for one.
+func (w *Writer) filter(h headers.Headers) headers.Headers {
+	if len(w.filters) == 0 {
+		return h
+	}
+	h = maps.Clone(h)
+
+	var encoders []Encoder
+	for _, f := range w.filters {
+		if enc := f(w.statusCode, h); enc != nil {
+			encoders = append(encoders, enc)
+		}
+	}
+	if len(encoders) == 0 || bodiless(w.statusCode) {
+		return h
+	}
+
+	h.Delete("Content-Length")
+	if !h.HasToken("Transfer-Encoding", "chunked") {
+		h.Set("Transfer-Encoding", "chunked")
+	}
+
+	var dst io.Writer = chunkSink{w}
+	var closers []io.Closer
+	for _, enc := range encoders {
+		wc := enc(dst)
+		closers = append(closers, wc)
+		dst = wc
+	}
+	w.encoder = &encoderChain{Writer: dst, closers: closers}
+	return h
+}
+
+// chunkSink writes encoded body bytes as chunks.
+type chunkSink struct {
+	w *Writer
+}
+
+func (s chunkSink) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		// An empty chunk would end the body.
+		return 0, nil
+	}
+	return s.w.writeChunk(p)
+}
+
+// encoderChain writes through a stack of encoders and closes them
+// outermost first, so each one flushes into the next.
+type encoderChain struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (c *encoderChain) Close() error {
+	for i := len(c.closers) - 1; i >= 0; i-- {
+		if err := c.closers[i].Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flusher is implemented by encoders that can push out buffered data without
+// ending the stream, like gzip.Writer.
+type flusher interface {
+	Flush() error
+}
+
+func (c *encoderChain) Flush() error {
+	for i := len(c.closers) - 1; i >= 0; i-- {
+		if f, ok := c.closers[i].(flusher); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-// WriteChunkedBody writes a single chunk in chunked transfer encoding.
+// frame works out how the body of the response will be delimited and sets
+// the Connection header to match. HTTP/1.0 clients don't understand chunked
+// encoding, so such responses are sent close-delimited instead.
+func (w *Writer) frame(h headers.Headers) headers.Headers {
+	h = maps.Clone(h)
+
+	if bodiless(w.statusCode) {
+		// The response ends at its headers, whatever they say, so it needs
+		// no framing and the connection stays usable.
+		w.noBody = true
+		w.contentLength = 0
+		h.Delete("Transfer-Encoding")
+		h.Delete("Trailer")
+		if w.statusCode != NotModified {
+			// A 304's Content-Length describes the representation it
+			// stands for; other bodiless statuses must not send one.
+			h.Delete("Content-Length")
+		}
+	} else if h.HasToken("Transfer-Encoding", "chunked") {
+		w.chunked = true
+		if w.HttpVersion == "1.0" {
+			h.Delete("Transfer-Encoding")
+			h.Delete("Trailer")
+			w.chunked = false
+			w.closeBody = true
+		}
+	} else if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil {
+		w.contentLength = n
+	} else if w.transport == nil {
+		w.closeBody = true
+	}
+
+	if w.transport != nil {
+		// The Transport delimits the body and manages the connection.
+		return h
+	}
+
+	if w.closeBody || h.HasToken("Connection", "close") {
+		w.KeepAlive = false
+	}
+
+	if !w.KeepAlive {
+		h.Set("Connection", "close")
+	} else if w.HttpVersion == "1.0" {
+		h.Set("Connection", "keep-alive")
+	}
+	return h
+}
+
+// ErrBodyNotAllowed is returned by writes of body bytes in a 1xx, 204 or 304
+// response, which has none.
+var ErrBodyNotAllowed = errors.New("response status doesn't allow a body")
+
+// bodiless reports whether responses with statusCode have no body (RFC 9110
+// sections 15.2, 15.3.5 and 15.4.5).
+func bodiless(statusCode StatusCode) bool {
+	return statusCode < OK || statusCode == NoContent || statusCode == NotModified
+}
+
+// write sends body bytes as they are, to the Transport if there is one.
+func (w *Writer) write(p []byte) (int, error) {
+	var n int
+	var err error
+	if w.transport != nil {
+		n, err = w.transport.WriteData(p)
+	} else {
+		n, err = w.Writer.Write(p)
+	}
+	w.bytesSent += int64(n)
+	return n, err
+}
+
+// WriteChunkedBody writes a single chunk in chunked transfer encoding. When
+// a Filter encodes the body, p goes through the encoder, which is flushed so
+// the chunk reaches the client without waiting for more.
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if err := w.unusable(); err != nil {
+		return 0, err
+	}
+	if w.encoder != nil {
+		n, err := w.encoder.Write(p)
+		if err != nil {
+			return n, err
+		}
+		return n, w.encoder.(flusher).Flush()
+	}
+	return w.writeChunk(p)
+}
+
+// writeChunk frames p as one chunk of the body.
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	if w.noBody {
+		if len(p) > 0 {
+			return 0, ErrBodyNotAllowed
+		}
+		return 0, nil
+	}
+	if w.digest != nil {
+		w.digest.Write(p)
+	}
+	if w.closeBody || w.transport != nil {
+		// Downgraded for an HTTP/1.0 client, the body ends when we close;
+		// a Transport frames it itself.
+		return w.write(p)
+	}
+
 	// Write chunk size in hex followed by \r\n
 	sizeLine := fmt.Sprintf("%x\r\n", len(p))
 	if _, err := w.Writer.Write([]byte(sizeLine)); err != nil {
@@ -120,6 +708,7 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	}
 	// Write chunk data
 	n, err := w.Writer.Write(p)
+	w.bytesSent += int64(n)
 	if err != nil {
 		return n, err
 	}
@@ -132,17 +721,97 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 
 // WriteChunkedBodyDone writes the final zero-length chunk.
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if err := w.unusable(); err != nil {
+		return 0, err
+	}
+	if w.encoder != nil {
+		encoder := w.encoder
+		w.encoder = nil
+		if err := encoder.Close(); err != nil {
+			return 0, err
+		}
+	}
+
+	w.State = StateWriteTrailers
+	if w.closeBody || w.noBody || w.transport != nil {
+		return 0, nil
+	}
 	return w.Writer.Write([]byte("0\r\n"))
 }
 
 func (w *Writer) WriteTrailers(headers headers.Headers) error {
+	if err := w.unusable(); err != nil {
+		return err
+	}
+	w.State = StateDone
+	if w.closeBody || w.noBody {
+		// There is no chunked framing to carry trailers in.
+		return nil
+	}
+	if w.transport != nil {
+		if w.digest != nil {
+			headers = maps.Clone(headers)
+			if headers == nil {
+				headers = make(map[string]string)
+			}
+			headers["Content-Digest"] = w.digest.Value()
+		}
+		return w.transport.WriteTrailers(headers)
+	}
+
 	for key, value := range headers {
 		_, err := w.Writer.Write([]byte(fmt.Sprintf("%s: %s\r\n", key, value)))
 		if err != nil {
 			return err
 		}
 	}
+	if w.digest != nil {
+		_, err := w.Writer.Write([]byte("Content-Digest: " + w.digest.Value() + "\r\n"))
+		if err != nil {
+			return err
+		}
+	}
 
-	w.Writer.Write([]byte("\r\n"))
-	return nil
+	_, err := w.Writer.Write([]byte("\r\n"))
+	return err
+}
+
+// Finish completes the response after the handler has returned, ending a
+// chunked body whose trailers were never written. It reports whether the
+// response was fully framed and the connection can be reused.
+func (w *Writer) Finish() bool {
+	if w.hijacked || w.aborted {
+		return false
+	}
+	switch w.State {
+	case StateWriteTrailers:
+		if err := w.WriteTrailers(nil); err != nil {
+			return false
+		}
+	case StateWriteBody:
+		if w.encoder != nil {
+			// The handler gave up on the body before WriteChunkedBodyDone.
+			// Ending the encoded stream would pass the truncated body off
+			// as complete, so leave it unterminated, as below.
+			w.encoder = nil
+			return false
+		}
+		if w.headersPending() {
+			if err := w.flushHeaders(); err != nil {
+				return false
+			}
+		}
+		if w.chunked || w.contentLength != 0 {
+			return false
+		}
+		w.State = StateDone
+	case StateDone:
+	default:
+		return false
+	}
+
+	if !w.chunked && w.bodyWritten != w.contentLength {
+		return false
+	}
+	return w.KeepAlive
 }
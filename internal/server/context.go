@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+)
+
+// Timeout returns middleware that gives requests d to finish: their
+// context's deadline, which outbound calls made with it honor too. Wrap the
+// handlers of the routes that need one. The request gets its own context
+// back once next returns.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			parent := req.Context()
+			ctx, cancel := context.WithTimeout(parent, d)
+			defer cancel()
+			defer req.WithContext(parent)
+			next(w, req.WithContext(ctx))
+		}
+	}
+}
+
+// aLongTimeAgo is a read deadline that interrupts a blocked Read at once.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// connReader reads an HTTP/1.x connection for the parser. While a handler
+// runs with nothing left to read for its request, it keeps a read pending in
+// the background, the only way to notice that the client went away.
+type connReader struct {
+	conn net.Conn
+
+	mu sync.Mutex
+	// pending holds bytes read ahead, by sniffing or in the background,
+	// and err what ended a background read.
+	pending []byte
+	err     error
+	// done is closed when the background read in flight, if any, returns.
+	done chan struct{}
+}
+
+func (cr *connReader) Read(p []byte) (int, error) {
+	cr.mu.Lock()
+	if len(cr.pending) > 0 {
+		n := copy(p, cr.pending)
+		cr.pending = cr.pending[n:]
+		cr.mu.Unlock()
+		return n, nil
+	}
+	err := cr.err
+	cr.err = nil
+	cr.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return cr.conn.Read(p)
+}
+
+// startBackgroundRead reads ahead until stopBackgroundRead, calling
+// disconnected if the client closes the connection meanwhile. Bytes that
+// arrive, like a pipelined request, are kept for the parser.
+func (cr *connReader) startBackgroundRead(disconnected func()) {
+	cr.done = make(chan struct{})
+	// The idle timeout doesn't apply while the handler runs.
+	cr.conn.SetReadDeadline(time.Time{})
+	go func() {
+		defer close(cr.done)
+		var b [1]byte
+		n, err := cr.conn.Read(b[:])
+
+		cr.mu.Lock()
+		defer cr.mu.Unlock()
+		cr.pending = append(cr.pending, b[:n]...)
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			cr.err = err
+			disconnected()
+		}
+	}()
+}
+
+// stopBackgroundRead interrupts the background read and waits for it.
+func (cr *connReader) stopBackgroundRead() {
+	if cr.done == nil {
+		return
+	}
+	cr.conn.SetReadDeadline(aLongTimeAgo)
+	<-cr.done
+	cr.done = nil
+	cr.conn.SetReadDeadline(time.Time{})
+}
+
+// buffered returns and forgets the bytes read ahead.
+func (cr *connReader) buffered() []byte {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	b := cr.pending
+	cr.pending = nil
+	return b
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitHandler reports the request's context error once it is done, or nil
+// if that doesn't happen in time.
+func waitHandler(started chan<- struct{}, result chan<- error) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		close(started)
+		select {
+		case <-req.Context().Done():
+			result <- req.Context().Err()
+		case <-time.After(3 * time.Second):
+			result <- nil
+		}
+	}
+}
+
+func TestContextCanceledOnDisconnect(t *testing.T) {
+	started := make(chan struct{})
+	result := make(chan error, 1)
+	conn := startServer(t, waitHandler(started, result))
+
+	fmt.Fprint(conn, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+	conn.Close()
+	assert.ErrorIs(t, <-result, context.Canceled)
+}
+
+func TestContextCanceledOnClose(t *testing.T) {
+	started := make(chan struct{})
+	result := make(chan error, 1)
+	s, err := Serve(0, waitHandler(started, result))
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	fmt.Fprint(conn, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+	s.Close()
+	assert.ErrorIs(t, <-result, context.Canceled)
+}
+
+func TestTimeout(t *testing.T) {
+	started := make(chan struct{})
+	result := make(chan error, 1)
+	handler := Timeout(10 * time.Millisecond)(func(w *response.Writer, req *request.Request) {
+		waitHandler(started, result)(w, req)
+		helloHandler(w, req)
+	})
+	conn := startServer(t, handler)
+
+	fmt.Fprint(conn, "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	assert.ErrorIs(t, <-result, context.DeadlineExceeded)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+}
+
+func TestTimeoutRestoresContext(t *testing.T) {
+	result := make(chan error, 1)
+	inner := Timeout(time.Hour)(helloHandler)
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		inner(w, req)
+		result <- req.Context().Err()
+	})
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.NoError(t, <-result)
+}
+
+func TestPipelinedRequestWhileHandlerRuns(t *testing.T) {
+	arrived := make(chan struct{})
+	errs := make(chan error, 2)
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/first" {
+			// The second request arrives while the first is answered,
+			// which must neither cancel it nor get lost.
+			<-arrived
+			time.Sleep(20 * time.Millisecond)
+		}
+		errs <- req.Context().Err()
+		helloHandler(w, req)
+	})
+	r := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, "GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	fmt.Fprint(conn, "GET /second HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	close(arrived)
+	for i := 0; i < 2; i++ {
+		resp, body := readResponse(t, r)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "hello", body)
+	}
+	assert.NoError(t, <-errs)
+	assert.NoError(t, <-errs)
+}
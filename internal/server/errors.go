@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+
+	"chillhttp/internal/negotiate"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// ErrorHandler is a handler that returns its failures instead of answering
+// them itself. HandleErrors turns it into a Handler.
+type ErrorHandler func(w *response.Writer, req *request.Request) error
+
+// StatusHandler is an ErrorHandler whose only failures are HandlerErrors. A
+// nil *HandlerError returned as an error isn't nil, so a helper returning
+// one is safest called from a StatusHandler. HandleStatusErrors turns it into
+// a Handler.
+type StatusHandler func(w *response.Writer, req *request.Request) *HandlerError
+
+// errorOffers are the representations of an error response. JSON clients
+// get RFC 9457 problem details either way.
+var errorOffers = []negotiate.Offer{
+	{ContentType: "text/html"},
+	{ContentType: "application/problem+json"},
+	{ContentType: "application/json"},
+	{ContentType: "text/plain"},
+}
+
+// HandleErrors adapts h to a Handler. An error h returns is logged and, if h
+// hasn't started the response, answered as an HTML page or, for clients
+// that prefer JSON, as application/problem+json. A *HandlerError, wrapped or
+// not, sets the status and message; a deadline from the request's context
+// gives 503, and any other error 500 without its text, which stays in the
+// log. A *HandlerError whose Code isn't a 4xx or 5xx status gives 500, and
+// a nil one is no error. A response h started but didn't finish is aborted,
+// so the client doesn't take it for a complete one.
+func HandleErrors(h ErrorHandler) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		err := h(w, req)
+		if err == nil {
+			return
+		}
+
+		herr := handlerError(err)
+		if herr == nil {
+			return
+		}
+		started := w.State != response.StateWriteStatusLine
+		logHandlerError(req, herr, err, started)
+		switch {
+		case !started:
+			renderError(w, req, herr)
+		case w.State != response.StateDone:
+			w.Abort()
+		}
+	}
+}
+
+// HandleStatusErrors adapts h to a Handler, answering the errors it returns
+// as HandleErrors does.
+func HandleStatusErrors(h StatusHandler) Handler {
+	return HandleErrors(func(w *response.Writer, req *request.Request) error {
+		if herr := h(w, req); herr != nil {
+			return herr
+		}
+		return nil
+	})
+}
+
+// handlerError returns the response err calls for, nil if err is a nil
+// *HandlerError.
+func handlerError(err error) *HandlerError {
+	var herr *HandlerError
+	switch {
+	case errors.As(err, &herr):
+		if herr != nil && (herr.Code < 400 || herr.Code > 599) {
+			return &HandlerError{Code: int(response.InternalServerError), Err: herr.Err}
+		}
+		return herr
+	case errors.Is(err, context.DeadlineExceeded):
+		return &HandlerError{Code: int(response.ServiceUnavailable), Err: "The request took too long."}
+	default:
+		return &HandlerError{Code: int(response.InternalServerError)}
+	}
+}
+
+func logHandlerError(req *request.Request, herr *HandlerError, err error, started bool) {
+	fields := []zap.Field{
+		zap.String("method", req.RequestLine.Method),
+		zap.String("target", req.RequestLine.RequestTarget),
+		zap.String("host", req.Host()),
+		zap.String("remote", req.RemoteAddr),
+		zap.String("request_id", GetRequestID(req)),
+		zap.Int("status", herr.Code),
+		zap.Error(err),
+	}
+	if ctxErr := req.Context().Err(); ctxErr != nil {
+		fields = append(fields, zap.NamedError("context", ctxErr))
+	}
+
+	switch {
+	case started:
+		log.Warn("handler failed after starting the response", fields...)
+	case herr.Code >= int(response.InternalServerError):
+		log.Error("handler failed", fields...)
+	default:
+		log.Info("handler answered with an error", fields...)
+	}
+}
+
+// renderError writes the error page for herr in the representation req
+// prefers, plain text if it accepts none of them.
+func renderError(w *response.Writer, req *request.Request, herr *HandlerError) {
+	statusCode := response.StatusCode(herr.Code)
+	title := response.StatusText(statusCode)
+	if title == "" {
+		title = fmt.Sprintf("Error %d", herr.Code)
+	}
+
+	offer, _ := negotiate.Best(req.Headers, errorOffers...)
+	var body []byte
+	switch offer.ContentType {
+	case "application/problem+json", "application/json":
+		response.WriteProblem(w, response.NewProblem(statusCode, herr.Err))
+		return
+	case "text/html":
+		body = fmt.Appendf(nil, "<html>\n<head><title>%d %s</title></head>\n<body>\n<h1>%s</h1>\n",
+			herr.Code, html.EscapeString(title), html.EscapeString(title))
+		if herr.Err != "" {
+			body = fmt.Appendf(body, "<p>%s</p>\n", html.EscapeString(herr.Err))
+		}
+		body = append(body, "</body>\n</html>\n"...)
+	default:
+		offer.ContentType = "text/plain"
+		body = fmt.Appendf(nil, "%s\n", title)
+		if herr.Err != "" {
+			body = fmt.Appendf(body, "\n%s\n", herr.Err)
+		}
+	}
+
+	h := response.GetDefaultHeaders(len(body))
+	h["Content-Type"] = offer.ContentType
+	h["Vary"] = "Accept"
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"chillhttp/internal/headers"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"handler error as html", &HandlerError{Code: 404, Err: "No <such> page."}, "text/html",
+			404, "text/html", "<html>\n<head><title>404 Not Found</title></head>\n<body>\n<h1>Not Found</h1>\n<p>No &lt;such&gt; page.</p>\n</body>\n</html>\n"},
+		{"handler error as problem", &HandlerError{Code: 404, Err: "No such page."}, "application/json",
+			404, "application/problem+json", `{"title":"Not Found","status":404,"detail":"No such page."}`},
+		{"handler error as text", &HandlerError{Code: 403}, "text/plain",
+			403, "text/plain", "Forbidden\n"},
+		{"wrapped handler error", fmt.Errorf("loading: %w", &HandlerError{Code: 400, Err: "Bad id."}), "text/plain",
+			400, "text/plain", "Bad Request\n\nBad id.\n"},
+		{"deadline", fmt.Errorf("querying: %w", context.DeadlineExceeded), "text/plain",
+			503, "text/plain", "Service Unavailable\n\nThe request took too long.\n"},
+		{"other errors hide their text", errors.New("secret database password"), "image/png",
+			500, "text/plain", "Internal Server Error\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := startServer(t, HandleErrors(func(w *response.Writer, req *request.Request) error {
+				return tt.err
+			}))
+
+			fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nAccept: %s\r\n\r\n", tt.accept)
+			resp, body := readResponse(t, bufio.NewReader(conn))
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.contentType, resp.Header.Get("Content-Type"))
+			assert.Equal(t, tt.body, body)
+		})
+	}
+}
+
+func TestHandleErrorsAfterResponseStarted(t *testing.T) {
+	conn := startServer(t, HandleErrors(func(w *response.Writer, req *request.Request) error {
+		helloHandler(w, req)
+		return &HandlerError{Code: 500, Err: "too late"}
+	}))
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+}
+
+func TestHandleErrorsAbortsUnfinishedResponse(t *testing.T) {
+	conn := startServer(t, HandleErrors(func(w *response.Writer, req *request.Request) error {
+		// Encode the body as compression middleware would.
+		w.AddFilter(func(_ response.StatusCode, h headers.Headers) response.Encoder {
+			h["Content-Encoding"] = "gzip"
+			return func(dst io.Writer) io.WriteCloser { return gzip.NewWriter(dst) }
+		})
+		h := response.GetDefaultHeaders(0)
+		delete(h, "Content-Length")
+		h["Transfer-Encoding"] = "chunked"
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("first"))
+		return errors.New("upstream went away")
+	}))
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "\r\n0\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), nil)
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestHandleErrorsNil(t *testing.T) {
+	conn := startServer(t, HandleErrors(func(w *response.Writer, req *request.Request) error {
+		helloHandler(w, req)
+		return nil
+	}))
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+}
+
+func TestHandleErrorsTypedNil(t *testing.T) {
+	find := func(string) *HandlerError { return nil }
+	conn := startServer(t, HandleErrors(func(w *response.Writer, req *request.Request) error {
+		helloHandler(w, req)
+		return find("page")
+	}))
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+}
+
+func TestHandleErrorsInvalidCode(t *testing.T) {
+	for _, code := range []int{0, 200, 302, 600} {
+		t.Run(fmt.Sprint(code), func(t *testing.T) {
+			conn := startServer(t, HandleErrors(func(w *response.Writer, req *request.Request) error {
+				return &HandlerError{Code: code, Err: "nope"}
+			}))
+
+			fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nAccept: text/plain\r\n\r\n")
+			resp, body := readResponse(t, bufio.NewReader(conn))
+			assert.Equal(t, 500, resp.StatusCode)
+			assert.Equal(t, "Internal Server Error\n\nnope\n", body)
+		})
+	}
+}
+
+func TestHandleStatusErrors(t *testing.T) {
+	handler := HandleStatusErrors(func(w *response.Writer, req *request.Request) *HandlerError {
+		if req.RequestLine.RequestTarget != "/" {
+			return &HandlerError{Code: 404}
+		}
+		helloHandler(w, req)
+		return nil
+	})
+	conn := startServer(t, handler)
+	r := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, "GET /missing HTTP/1.1\r\nHost: localhost\r\nAccept: text/plain\r\n\r\n")
+	resp, body := readResponse(t, r)
+	assert.Equal(t, 404, resp.StatusCode)
+	assert.Equal(t, "Not Found\n", body)
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, body = readResponse(t, r)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"chillhttp/internal/http2"
+	"chillhttp/internal/request"
+)
+
+// WithH2C serves HTTP/2 over cleartext connections too, to clients that
+// either start with the HTTP/2 preface ("prior knowledge") or ask to switch
+// with "Upgrade: h2c" (RFC 7540 section 3.2). Over TLS, HTTP/2 is always
+// offered through ALPN.
+func WithH2C() Option {
+	return func(s *Server) {
+		s.h2c = true
+	}
+}
+
+// sniffPreface reads from r for as long as what arrives matches the HTTP/2
+// client preface. It returns what it read, and whether that was the whole
+// preface; otherwise the bytes belong to an HTTP/1.x request.
+func sniffPreface(r io.Reader) ([]byte, bool) {
+	buf := make([]byte, 0, len(http2.ClientPreface))
+	for len(buf) < cap(buf) {
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if !strings.HasPrefix(http2.ClientPreface, string(buf)) || err != nil {
+			return buf, false
+		}
+	}
+	return buf, true
+}
+
+// h2cUpgrade reports whether req asks to switch to HTTP/2, returning the
+// settings it carries. A request with anything but exactly one valid
+// HTTP2-Settings header is served over HTTP/1.1 instead.
+func h2cUpgrade(req *request.Request) ([]http2.Setting, bool) {
+	if req.RequestLine.HttpVersion != "1.1" ||
+		!req.Headers.HasToken("Upgrade", "h2c") ||
+		!req.Headers.HasToken("Connection", "Upgrade") ||
+		!req.Headers.HasToken("Connection", "HTTP2-Settings") {
+		return nil, false
+	}
+
+	// Repeated headers are joined with ", ", which base64url never contains.
+	value, ok := req.Headers["http2-settings"]
+	if !ok || strings.Contains(value, ",") {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return nil, false
+	}
+	settings, err := http2.ParseSettings(payload)
+	if err != nil {
+		return nil, false
+	}
+	return settings, true
+}
+
+// upgradeH2C switches conn to HTTP/2 and answers req on its first stream.
+// The body is read first: once the 101 is out, the connection only carries
+// HTTP/2 frames.
+func (s *Server) upgradeH2C(conn net.Conn, parser *request.Parser, req *request.Request, settings []http2.Setting) {
+	if _, err := req.ReadBody(); err != nil {
+		WriteError(conn, &HandlerError{Code: int(statusForParseError(err))})
+		req.Release()
+		return
+	}
+	if _, err := fmt.Fprint(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"); err != nil {
+		req.Release()
+		return
+	}
+
+	buffered := bytes.Clone(parser.Buffered())
+	s.h2.ServeConn(conn, http2.ConnOptions{
+		Reader:          io.MultiReader(bytes.NewReader(buffered), conn),
+		Upgrade:         req,
+		UpgradeSettings: settings,
+		Context:         s.ctx,
+	})
+}
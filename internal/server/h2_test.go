@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"chillhttp/internal/http2"
+	"chillhttp/internal/http2/hpack"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func port(s *Server) int {
+	return s.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestHTTP2OverTLS(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.writeCert(t, t.TempDir(), "localhost", "localhost")
+	s := startTLSServer(t, echoHandler, certFile, keyFile, WithHSTS(time.Hour, false, false))
+
+	transport := &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: ca.pool},
+		ForceAttemptHTTP2: true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	url := fmt.Sprintf("https://localhost:%d/", port(s))
+	for _, body := range []string{"hello", "world"} {
+		resp, err := client.Post(url, "text/plain", strings.NewReader(body))
+		require.NoError(t, err)
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, resp.ProtoMajor)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, body, string(got))
+		assert.Equal(t, "max-age=3600", resp.Header.Get("Strict-Transport-Security"))
+		assert.Empty(t, resp.Header.Get("Connection"))
+	}
+}
+
+func TestH2CPriorKnowledge(t *testing.T) {
+	conn := startServer(t, helloHandler, WithH2C())
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, http2.ClientPreface)
+	fr := http2.NewFramer(conn, conn)
+	require.NoError(t, fr.WriteSettings())
+	block := hpack.NewEncoder().AppendBlock(nil,
+		hpack.HeaderField{Name: ":method", Value: "GET"},
+		hpack.HeaderField{Name: ":scheme", Value: "http"},
+		hpack.HeaderField{Name: ":path", Value: "/"},
+		hpack.HeaderField{Name: ":authority", Value: "localhost"})
+	require.NoError(t, fr.WriteHeaders(1, true, block, 16384))
+
+	status, body := readStream(t, fr, 1)
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "hello", body)
+}
+
+// readStream reads frames until stream id ends, returning its status and
+// body.
+func readStream(t *testing.T, fr *http2.Framer, id uint32) (status, body string) {
+	t.Helper()
+	dec := hpack.NewDecoder(hpack.DefaultTableSize)
+	for {
+		f, err := fr.ReadFrame()
+		require.NoError(t, err)
+		if f.StreamID != id {
+			continue
+		}
+		switch f.Type {
+		case http2.FrameHeaders:
+			fields, err := dec.Decode(f.Payload)
+			require.NoError(t, err)
+			for _, field := range fields {
+				if field.Name == ":status" {
+					status = field.Value
+				}
+			}
+		case http2.FrameData:
+			body += string(f.Payload)
+		}
+		if f.Flags.Has(http2.FlagEndStream) {
+			return status, body
+		}
+	}
+}
+
+func TestH2CStillServesHTTP1(t *testing.T) {
+	conn := startServer(t, helloHandler, WithH2C())
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	conn := startServer(t, echoHandler, WithH2C())
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	// The settings payload is SETTINGS_MAX_CONCURRENT_STREAMS = 100.
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n"+
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABk\r\n\r\nhello")
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	require.Equal(t, 101, resp.StatusCode)
+	assert.Equal(t, "h2c", resp.Header.Get("Upgrade"))
+
+	fmt.Fprint(conn, http2.ClientPreface)
+	fr := http2.NewFramer(conn, r)
+	require.NoError(t, fr.WriteSettings())
+
+	// The upgraded request is answered on stream 1.
+	status, body := readStream(t, fr, 1)
+	assert.Equal(t, "200", status)
+	assert.Equal(t, "hello", body)
+}
+
+func TestH2CUpgradeIgnoredWithoutOption(t *testing.T) {
+	conn := startServer(t, helloHandler)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n"+
+		"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABk\r\n\r\n")
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+}
+
+func TestHijackRefusedOverHTTP2(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, _ *request.Request) {
+		_, _, err := w.Hijack()
+		body := err.Error()
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	}, WithH2C())
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, http2.ClientPreface)
+	fr := http2.NewFramer(conn, conn)
+	require.NoError(t, fr.WriteSettings())
+	block := hpack.NewEncoder().AppendBlock(nil,
+		hpack.HeaderField{Name: ":method", Value: "GET"},
+		hpack.HeaderField{Name: ":scheme", Value: "http"},
+		hpack.HeaderField{Name: ":path", Value: "/"},
+		hpack.HeaderField{Name: ":authority", Value: "localhost"})
+	require.NoError(t, fr.WriteHeaders(1, true, block, 16384))
+
+	status, body := readStream(t, fr, 1)
+	assert.Equal(t, "200", status)
+	assert.Equal(t, response.ErrNotHijackable.Error(), body)
+}
+
+func TestAbortResetsStream(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, _ *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		delete(h, "Content-Length")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("first"))
+		w.Abort()
+	}, WithH2C())
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, http2.ClientPreface)
+	fr := http2.NewFramer(conn, conn)
+	require.NoError(t, fr.WriteSettings())
+	block := hpack.NewEncoder().AppendBlock(nil,
+		hpack.HeaderField{Name: ":method", Value: "GET"},
+		hpack.HeaderField{Name: ":scheme", Value: "http"},
+		hpack.HeaderField{Name: ":path", Value: "/"},
+		hpack.HeaderField{Name: ":authority", Value: "localhost"})
+	require.NoError(t, fr.WriteHeaders(1, true, block, 16384))
+
+	for {
+		f, err := fr.ReadFrame()
+		require.NoError(t, err)
+		if f.StreamID != 1 {
+			continue
+		}
+		assert.False(t, f.Flags.Has(http2.FlagEndStream), "stream ended cleanly")
+		if f.Type == http2.FrameRSTStream {
+			break
+		}
+	}
+}
+
+func TestNoContentOverHTTP2(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.NoContent)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		w.WriteBody(nil)
+	}, WithH2C())
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, http2.ClientPreface)
+	fr := http2.NewFramer(conn, conn)
+	require.NoError(t, fr.WriteSettings())
+	block := hpack.NewEncoder().AppendBlock(nil,
+		hpack.HeaderField{Name: ":method", Value: "GET"},
+		hpack.HeaderField{Name: ":scheme", Value: "http"},
+		hpack.HeaderField{Name: ":path", Value: "/"},
+		hpack.HeaderField{Name: ":authority", Value: "localhost"})
+	require.NoError(t, fr.WriteHeaders(1, true, block, 16384))
+
+	status, body := readStream(t, fr, 1)
+	assert.Equal(t, "204", status)
+	assert.Empty(t, body)
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"errors"
+
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+)
+
+// DecodeJSON decodes the JSON body of req into v. If it can't, it answers
+// the request with an application/problem+json error and returns false: 415
+// for a body that isn't JSON, 413 for one over the size limit and 400 for
+// anything else.
+func DecodeJSON(w *response.Writer, req *request.Request, v any, opts request.JSONOptions) bool {
+	err := req.DecodeJSON(v, opts)
+	if err == nil {
+		return true
+	}
+
+	status := statusForParseError(err)
+	if errors.Is(err, request.ErrUnsupportedMediaType) {
+		status = response.UnsupportedMediaType
+	}
+	response.WriteProblem(w, response.NewProblem(status, err.Error()))
+	return false
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"chillhttp/internal/metrics"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+)
+
+// serverMetrics are what a server records with WithMetrics.
+type serverMetrics struct {
+	path    string
+	handler Handler
+
+	requests    *metrics.Counter
+	duration    *metrics.Histogram
+	inFlight    *metrics.Gauge
+	connections *metrics.Gauge
+	bytesIn     *metrics.Counter
+	bytesOut    *metrics.Counter
+	parseErrors *metrics.Counter
+}
+
+// WithMetrics records the server's metrics in reg: requests by method,
+// route and status, their latency, requests in flight, open connections,
+// bytes received and sent, and requests rejected as malformed, by type. If
+// path isn't empty, GET requests for it are answered with everything in reg
+// in the Prometheus text format.
+func WithMetrics(reg *metrics.Registry, path string) Option {
+	return func(s *Server) {
+		s.metrics = &serverMetrics{
+			path:    path,
+			handler: reg.Handler(),
+			requests: reg.NewCounter("http_requests_total",
+				"Requests answered, by method, route and status code.", "method", "route", "status"),
+			duration: reg.NewHistogram("http_request_duration_seconds",
+				"Time from a request's headers to its handler's return.", nil, "method", "route"),
+			inFlight: reg.NewGauge("http_requests_in_flight",
+				"Requests being answered."),
+			connections: reg.NewGauge("http_open_connections",
+				"Client connections open."),
+			bytesIn: reg.NewCounter("http_received_bytes_total",
+				"Bytes read from client connections, including TLS and HTTP framing."),
+			bytesOut: reg.NewCounter("http_sent_bytes_total",
+				"Bytes written to client connections, including TLS and HTTP framing."),
+			parseErrors: reg.NewCounter("http_parse_errors_total",
+				"Requests rejected as malformed, by type of error.", "type"),
+		}
+	}
+}
+
+// knownMethods are the methods that get a method label of their own; the
+// rest share "OTHER", so clients can't add series at will.
+var knownMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true,
+	"CONNECT": true, "OPTIONS": true, "TRACE": true, "PATCH": true,
+}
+
+// track records that req is being answered, returning the function that
+// records how it went once the handler is done.
+func (m *serverMetrics) track(w *response.Writer, req *request.Request) func() {
+	start := time.Now()
+	m.inFlight.Inc()
+
+	return func() {
+		m.inFlight.Dec()
+		method := req.RequestLine.Method
+		if !knownMethods[method] {
+			method = "OTHER"
+		}
+		route := Route(req)
+		m.requests.Inc(method, route, strconv.Itoa(int(w.Status())))
+		m.duration.Observe(time.Since(start).Seconds(), method, route)
+	}
+}
+
+// parseError counts a request rejected because of err.
+func (m *serverMetrics) parseError(err error) {
+	if m != nil {
+		m.parseErrors.Inc(parseErrorType(err))
+	}
+}
+
+func parseErrorType(err error) string {
+	switch {
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return "unsupported_version"
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return "header_too_large"
+	case errors.Is(err, request.ErrInvalidHost):
+		return "invalid_host"
+	case errors.Is(err, request.ErrDuplicateContentLength),
+		errors.Is(err, request.ErrInvalidContentLength),
+		errors.Is(err, request.ErrContentLengthWithTransferEncoding),
+		errors.Is(err, request.ErrInvalidTransferEncoding):
+		return "ambiguous_framing"
+	case errors.Is(err, request.ErrUnsupportedTransferEncoding):
+		return "unsupported_transfer_encoding"
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return "body_too_large"
+	case errors.Is(err, request.ErrUnsupportedContentEncoding),
+		errors.Is(err, request.ErrInvalidContentEncoding):
+		return "content_encoding"
+	default:
+		return "malformed"
+	}
+}
+
+// isMetricsPath reports whether req asks for the metrics page.
+func (m *serverMetrics) isMetricsPath(req *request.Request) bool {
+	if m == nil || m.path == "" {
+		return false
+	}
+	path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	return path == m.path
+}
+
+// countBytes wraps l so its connections count the bytes they carry, if
+// metrics are on.
+func (s *Server) countBytes(l net.Listener) net.Listener {
+	if s.metrics == nil {
+		return l
+	}
+	return countingListener{l, s.metrics}
+}
+
+type countingListener struct {
+	net.Listener
+	m *serverMetrics
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{conn, l.m}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	m *serverMetrics
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.m.bytesIn.Add(float64(n))
+	}
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.m.bytesOut.Add(float64(n))
+	}
+	return n, err
+}
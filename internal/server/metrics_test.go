@@ -0,0 +1,72 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+
+	"chillhttp/internal/metrics"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/users/7" {
+			SetRoute(req, "/users/{id}")
+		}
+		helloHandler(w, req)
+	}, WithMetrics(reg, "/metrics"))
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", s.Listener.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn, bufio.NewReader(conn)
+	}
+
+	conn, r := dial()
+	fmt.Fprint(conn, "GET /users/7 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	readResponse(t, r)
+	fmt.Fprint(conn, "GET /users/7 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	readResponse(t, r)
+	fmt.Fprint(conn, "BREW /pot HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	readResponse(t, r)
+
+	bad, badR := dial()
+	fmt.Fprint(bad, "GET / HTTP/1.1\r\n\r\n")
+	resp, _ := readResponse(t, badR)
+	assert.Equal(t, 400, resp.StatusCode)
+	bad, badR = dial()
+	fmt.Fprint(bad, "GET / HTTP/1.1\r\nContent-Length: 1\r\nContent-Length: 2\r\nHost: localhost\r\n\r\n")
+	resp, _ = readResponse(t, badR)
+	assert.Equal(t, 400, resp.StatusCode)
+
+	fmt.Fprint(conn, "GET /metrics HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, body := readResponse(t, r)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, metrics.ContentType, resp.Header.Get("Content-Type"))
+
+	assert.Contains(t, body, `http_requests_total{method="GET",route="/users/{id}",status="200"} 2`+"\n")
+	assert.Contains(t, body, `http_requests_total{method="OTHER",route="",status="200"} 1`+"\n")
+	assert.Contains(t, body, `http_requests_total{method="GET",route="",status="400"} 1`+"\n")
+	assert.Contains(t, body, `http_request_duration_seconds_count{method="GET",route="/users/{id}"} 2`+"\n")
+	assert.Contains(t, body, "http_requests_in_flight 1\n")
+	assert.Contains(t, body, `http_parse_errors_total{type="invalid_host"} 1`+"\n")
+	assert.Contains(t, body, `http_parse_errors_total{type="ambiguous_framing"} 1`+"\n")
+	assert.Regexp(t, `http_open_connections [1-3]\n`, body)
+	assert.Regexp(t, `http_received_bytes_total [1-9]\d*\n`, body)
+	assert.Regexp(t, `http_sent_bytes_total [1-9]\d*\n`, body)
+
+	fmt.Fprint(conn, "POST /metrics HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n")
+	resp, _ = readResponse(t, r)
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "GET", resp.Header.Get("Allow"))
+}
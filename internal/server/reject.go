@@ -0,0 +1,52 @@
+package server
+
+import (
+	"time"
+
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+)
+
+// A Rejection is a request the server answered with an error itself, without
+// running its Handler: one it couldn't parse, with a missing or bad Host, an
+// Expect it can't meet or a body it couldn't read.
+type Rejection struct {
+	// Request is nil if the request line and headers couldn't be parsed.
+	// Otherwise it is only valid until the hook returns, as for a Handler.
+	Request    *request.Request
+	RemoteAddr string
+	Start      time.Time
+	Duration   time.Duration
+	Status     response.StatusCode
+	// Bytes is the size of the error body sent.
+	Bytes int64
+	// Err is why the request was rejected; nil for an unmet Expect.
+	Err error
+}
+
+// WithRejectHook has fn called with each request the server rejects, once
+// the error response is sent, e.g. to log it alongside the requests that
+// reach the Handler.
+func WithRejectHook(fn func(Rejection)) Option {
+	return func(s *Server) {
+		s.onReject = fn
+	}
+}
+
+// reject answers req with herr and reports it to the reject hook. req is nil
+// if it couldn't be parsed.
+func (s *Server) reject(w *response.Writer, req *request.Request, remoteAddr string, start time.Time, herr *HandlerError, err error) {
+	writeError(w, herr)
+	if s.onReject == nil {
+		return
+	}
+	s.onReject(Rejection{
+		Request:    req,
+		RemoteAddr: remoteAddr,
+		Start:      start,
+		Duration:   time.Since(start),
+		Status:     w.Status(),
+		Bytes:      w.BytesWritten(),
+		Err:        err,
+	})
+}
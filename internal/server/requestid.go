@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"chillhttp/internal/headers"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+)
+
+// RequestIDHeader carries a request's ID, from a client or proxy that
+// assigned one, and back in the response.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength bounds the IDs taken from clients, which end up in logs.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID returns middleware that gives every request an ID, so its log
+// lines can be told apart and matched with the client's: the one in the
+// X-Request-Id header if it is reasonable, or a random one. The ID goes in
+// the request's context and the response's X-Request-Id header.
+func RequestID() Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			if GetRequestID(req) != "" {
+				next(w, req)
+				return
+			}
+
+			id := req.Headers.Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+			w.AddFilter(func(_ response.StatusCode, h headers.Headers) response.Encoder {
+				h.Set(RequestIDHeader, id)
+				return nil
+			})
+			next(w, req)
+		}
+	}
+}
+
+// GetRequestID returns the ID RequestID gave req, or "" if it gave none.
+func GetRequestID(req *request.Request) string {
+	id, _ := req.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns 16 random bytes in hex.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID reports whether id is short and made of characters that
+// can't forge log lines or fields.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == ':') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		kept     bool
+	}{
+		{"none", "", false},
+		{"kept", "req-42.a:b_c", true},
+		{"forged log line", `a" 200 0 "x`, false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := make(chan string, 1)
+			conn := startServer(t, RequestID()(func(w *response.Writer, req *request.Request) {
+				ids <- GetRequestID(req)
+				helloHandler(w, req)
+			}))
+
+			fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n")
+			if tt.incoming != "" {
+				fmt.Fprintf(conn, "X-Request-Id: %s\r\n", tt.incoming)
+			}
+			fmt.Fprint(conn, "\r\n")
+			resp, _ := readResponse(t, bufio.NewReader(conn))
+
+			id := <-ids
+			assert.Equal(t, id, resp.Header.Get("X-Request-Id"))
+			if tt.kept {
+				assert.Equal(t, tt.incoming, id)
+			} else {
+				assert.Regexp(t, `^[0-9a-f]{32}$`, id)
+			}
+		})
+	}
+}
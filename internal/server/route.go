@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+
+	"chillhttp/internal/request"
+)
+
+type routeKey struct{}
+
+// withRoute gives req somewhere for SetRoute to put its route.
+func withRoute(req *request.Request) {
+	req.WithContext(context.WithValue(req.Context(), routeKey{}, new(string)))
+}
+
+// SetRoute names the route that answers req, such as "/users/{id}", for the
+// route label of the request metrics and the name of its trace span.
+// Requests without one get an empty label: their targets would add a series
+// for every URL.
+func SetRoute(req *request.Request, route string) {
+	if r, ok := req.Context().Value(routeKey{}).(*string); ok {
+		*r = route
+	}
+}
+
+// Route returns the route SetRoute gave req, or "".
+func Route(req *request.Request) string {
+	if r, ok := req.Context().Value(routeKey{}).(*string); ok {
+		return *r
+	}
+	return ""
+}
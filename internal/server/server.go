@@ -1,88 +1,352 @@
 package server
 
 import (
+	"bytes"
+	"chillhttp/internal/http2"
 	"chillhttp/internal/request"
 	"chillhttp/internal/response"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net"
 	"sync/atomic"
+	"time"
 )
 
+// idleTimeout bounds how long a kept-alive connection may sit between
+// requests before the server closes it.
+const idleTimeout = 2 * time.Minute
+
 type Server struct {
 	Listener net.Listener
 	Handler  Handler
-	Closed  atomic.Bool
+	Closed   atomic.Bool
+	// ParserOptions configures how requests are parsed, strictly by default.
+	ParserOptions request.Options
+	// TLSConfig is the configuration of a server started with ServeTLS.
+	TLSConfig *tls.Config
+	// Certs holds the certificates of a server started with ServeTLS from
+	// certificate files. Call Certs.Reload to pick up renewed ones at once.
+	Certs *CertStore
+
+	extraCerts [][2]string
+	clientCAs  *x509.CertPool
+	clientAuth tls.ClientAuthType
+	hsts       string
+	h2c        bool
+	h2         *http2.Server
+	metrics    *serverMetrics
+	onReject   func(Rejection)
+	// ctx is the parent of every request's context, canceled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// An Option configures a Server before it starts accepting connections.
+type Option func(*Server)
+
+// WithParserOptions sets the request parser options, e.g. to accept bare LF
+// line endings from legacy clients with headers.Lenient.
+func WithParserOptions(opts request.Options) Option {
+	return func(s *Server) {
+		s.ParserOptions = opts
+	}
 }
+
+// HandlerError is an error response: its status code and a message for the
+// client. An ErrorHandler returns one to answer with it.
 type HandlerError struct {
 	Code int
 	Err  string
 }
 
+func (e *HandlerError) Error() string {
+	if e.Err == "" {
+		return response.StatusText(response.StatusCode(e.Code))
+	}
+	return e.Err
+}
+
+// Handler answers a request. The request, with its Headers and Body, is only
+// valid until the handler returns: the server then recycles it for a later
+// request. Goroutines that outlive the handler, such as a WebSocket reader or
+// an SSE producer, must copy what they use of it first, e.g. with maps.Clone
+// and bytes.Clone.
 type Handler func(w *response.Writer, req *request.Request)
 
+// Middleware wraps a Handler to add behaviour around it, such as compressing
+// its responses. The request it sees is recycled once the Handler it returns
+// has returned, as for any Handler.
+type Middleware func(Handler) Handler
+
 func WriteError(w io.Writer, err *HandlerError) {
+	writeError(response.NewWriter(w), err)
+}
+
+// writeError answers with err through w, whichever protocol w speaks.
+func writeError(w *response.Writer, err *HandlerError) {
 	if err == nil {
 		return
 	}
 
 	body := err.Err
 	statusCode := response.StatusCode(err.Code)
-	writer := response.NewWriter(w)
-	writer.WriteStatusLine(statusCode)
-	writer.WriteHeaders(response.GetDefaultHeaders(len(body)))// Assuming buff.Len() is not available in this context
-	w.Write([]byte(body))
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body))) // Assuming buff.Len() is not available in this context
+	w.WriteBody([]byte(body))
 }
 
-
-func Serve(port int, handler Handler) (*Server, error) {
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
 	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, fmt.Errorf("error creating listener: %w", err)
 	}
 
+	s := newServer(handler, opts)
+	s.Listener = s.countBytes(l)
+	go s.listen()
+	return s, nil
+}
+
+func newServer(handler Handler, opts []Option) *Server {
 	s := &Server{
-		Listener: l,
-		Handler:  handler,
+		Handler: handler,
 		Closed:  atomic.Bool{},
 	}
-	go s.listen()
-	return s, nil
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.h2 = &http2.Server{
+		Handler: func(w *response.Writer, req *request.Request) {
+			s.serve(w, req, nil)
+		},
+		RequestOptions: s.ParserOptions,
+	}
+	return s
 }
 
 func (s *Server) Close() error {
 	if s.Listener != nil {
 		s.Closed.Store(true)
+		s.cancel()
+		s.h2.Shutdown()
 		return s.Listener.Close()
 	}
 	return nil
 }
 
 func (s *Server) handle(conn net.Conn) {
-	if s.Closed.Load() {
+	if s.metrics != nil {
+		s.metrics.connections.Inc()
+		defer s.metrics.connections.Dec()
+	}
+	// A hijacked connection belongs to the handler that took it.
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			conn.Close()
+		}
+	}()
+
+	var tlsState *tls.ConnectionState
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn.SetDeadline(time.Now().Add(idleTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		conn.SetDeadline(time.Time{})
+		state := tlsConn.ConnectionState()
+		tlsState = &state
+		if state.NegotiatedProtocol == "h2" {
+			s.h2.ServeConn(conn, http2.ConnOptions{TLS: tlsState, Context: s.ctx})
+			return
+		}
+	}
+
+	cr := &connReader{conn: conn}
+	if s.h2c && tlsState == nil {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		sniffed, ok := sniffPreface(conn)
+		if ok {
+			s.h2.ServeConn(conn, http2.ConnOptions{PrefaceRead: true, Context: s.ctx})
+			return
+		}
+		cr.pending = sniffed
+	}
+
+	// Requests on a connection are handled one at a time, so pipelined
+	// requests are answered strictly in the order they arrived.
+	parser := request.NewParserWithOptions(cr, s.ParserOptions)
+	defer parser.Release()
+	for !s.Closed.Load() {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		req, err := parser.Next()
+		if err != nil {
+			var netErr net.Error
+			if errors.Is(err, io.EOF) || (errors.As(err, &netErr) && netErr.Timeout()) {
+				return
+			}
+			s.metrics.parseError(err)
+			writer := response.NewWriter(conn)
+			if tlsState != nil && s.hsts != "" {
+				writer.AddFilter(s.hstsFilter)
+			}
+			s.reject(writer, nil, conn.RemoteAddr().String(), time.Now(),
+				&HandlerError{Code: int(statusForParseError(err))}, err)
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+		req.TLS = tlsState
+		req.RemoteAddr = conn.RemoteAddr().String()
+
+		if s.h2c && tlsState == nil {
+			if settings, ok := h2cUpgrade(req); ok {
+				s.upgradeH2C(conn, parser, req, settings)
+				return
+			}
+		}
+
+		writer := response.NewWriter(conn)
+		writer.HttpVersion = req.RequestLine.HttpVersion
+		writer.KeepAlive = req.KeepAlive()
+		writer.SetHijacker(func() (net.Conn, []byte, error) {
+			hijacked = true
+			cr.stopBackgroundRead()
+			conn.SetDeadline(time.Time{})
+			return conn, append(bytes.Clone(parser.Buffered()), cr.buffered()...), nil
+		})
+
+		ctx, cancel := context.WithCancel(s.ctx)
+		req.WithContext(ctx)
+		s.serve(writer, req, func() {
+			if req.Complete() {
+				cr.startBackgroundRead(cancel)
+			}
+		})
+		cr.stopBackgroundRead()
+		cancel()
+		if hijacked {
+			req.Release()
+			return
+		}
+		writer.SetHijacker(nil)
+
+		reuse := writer.Finish() && req.Complete()
+		req.Release()
+		if !reuse {
+			return
+		}
+	}
+}
+
+// serve answers one request over HTTP/1.x or HTTP/2. A request it answers
+// itself with an error ends an HTTP/1.x connection. ready, if set, is called
+// just before the handler runs.
+func (s *Server) serve(writer *response.Writer, req *request.Request, ready func()) {
+	start := time.Now()
+	withRoute(req)
+	if s.metrics != nil {
+		defer s.metrics.track(writer, req)()
+	}
+
+	// Even the server's own error responses carry HSTS.
+	if req.TLS != nil && s.hsts != "" {
+		writer.AddFilter(s.hstsFilter)
+	}
+
+	if err := req.ValidateHost(); err != nil {
+		s.metrics.parseError(err)
+		writer.KeepAlive = false
+		s.reject(writer, req, req.RemoteAddr, start, &HandlerError{Code: int(response.BadRequest), Err: err.Error()}, err)
 		return
 	}
-	defer conn.Close()
 
-	writer := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		writer.WriteStatusLine(response.BadRequest)
-		writer.WriteHeaders(response.GetDefaultHeaders(0))
+	if want := req.WantContentDigest(); len(want) > 0 {
+		writer.DigestAlgorithms = want[:1]
+	}
+
+	if expect := req.Headers.Get("Expect"); req.ExpectsContinue() {
+		// The client holds the body back until we say "100 Continue",
+		// which we only do once the handler asks for the body. If the
+		// handler answers without reading it, the connection can't be
+		// reused.
+		keepAlive := writer.KeepAlive
+		writer.KeepAlive = false
+		req.BeforeBodyRead(func() error {
+			if writer.State != response.StateWriteStatusLine {
+				return nil
+			}
+			writer.KeepAlive = keepAlive
+			return writer.WriteInterim(response.Continue, nil)
+		})
+	} else if expect != "" && req.RequestLine.HttpVersion != "1.0" {
+		writer.KeepAlive = false
+		s.reject(writer, req, req.RemoteAddr, start, &HandlerError{Code: int(response.ExpectationFailed)}, nil)
 		return
+	} else if !streamsBody(req) {
+		if _, err := req.ReadBody(); err != nil {
+			s.metrics.parseError(err)
+			writer.KeepAlive = false
+			s.reject(writer, req, req.RemoteAddr, start, &HandlerError{Code: int(statusForParseError(err))}, err)
+			return
+		}
 	}
 
-	writer = response.NewWriter(conn)
+	if ready != nil {
+		ready()
+	}
+	if s.metrics.isMetricsPath(req) {
+		SetRoute(req, s.metrics.path)
+		s.metrics.handler(writer, req)
+		return
+	}
 	s.Handler(writer, req)
 }
 
+// streamsBody reports whether the body is left unread for the handler to
+// stream: multipart uploads can be too large to buffer, so handlers read them
+// with Request.MultipartReader or Request.ParseMultipartForm.
+func streamsBody(req *request.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(req.Headers.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// statusForParseError picks the status to answer a request the parser
+// rejected with. Anything not listed is a plain 400, which includes every
+// ambiguous-framing error.
+func statusForParseError(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return response.HttpVersionNotSupported
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return response.RequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrUnsupportedTransferEncoding):
+		return response.NotImplemented
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.ContentTooLarge
+	case errors.Is(err, request.ErrUnsupportedContentEncoding):
+		return response.UnsupportedMediaType
+	default:
+		return response.BadRequest
+	}
+}
+
 func (s *Server) listen() {
 	for {
 		conn, err := s.Listener.Accept()
 		if err != nil {
+			if s.Closed.Load() {
+				return
+			}
 			fmt.Println("Error accepting connection: %w", err)
+			continue
 		}
 
 		go s.handle(conn)
 	}
-}
\ No newline at end of file
+}
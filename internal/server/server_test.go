@@ -0,0 +1,522 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"chillhttp/internal/cookie"
+	"chillhttp/internal/headers"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, handler Handler, opts ...Option) net.Conn {
+	t.Helper()
+	s, err := Serve(0, handler, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func helloHandler(w *response.Writer, _ *request.Request) {
+	body := []byte("hello")
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func chunkedHandler(w *response.Writer, _ *request.Request) {
+	h := response.GetDefaultHeaders(0)
+	delete(h, "Content-Length")
+	h["Transfer-Encoding"] = "chunked"
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(h)
+	w.WriteChunkedBody([]byte("hel"))
+	w.WriteChunkedBody([]byte("lo"))
+	w.WriteChunkedBodyDone()
+}
+
+func readResponse(t *testing.T, r *bufio.Reader) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestKeepAliveHttp11(t *testing.T) {
+	conn := startServer(t, helloHandler)
+	r := bufio.NewReader(conn)
+
+	for i := 0; i < 3; i++ {
+		fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		resp, body := readResponse(t, r)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "hello", body)
+		assert.False(t, resp.Close)
+	}
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	resp, _ := readResponse(t, r)
+	assert.True(t, resp.Close)
+	_, err := r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestHttp10ClosesByDefault(t *testing.T) {
+	conn := startServer(t, helloHandler)
+	r := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, "GET / HTTP/1.0\r\n\r\n")
+	resp, body := readResponse(t, r)
+	assert.Equal(t, "hello", body)
+	assert.True(t, resp.Close)
+	_, err := r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestHttp10KeepAlive(t *testing.T) {
+	conn := startServer(t, helloHandler)
+	r := bufio.NewReader(conn)
+
+	for i := 0; i < 2; i++ {
+		fmt.Fprint(conn, "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+		resp, body := readResponse(t, r)
+		assert.Equal(t, "hello", body)
+		assert.Equal(t, "keep-alive", resp.Header.Get("Connection"))
+	}
+}
+
+func TestHttp10NoChunkedEncoding(t *testing.T) {
+	conn := startServer(t, chunkedHandler)
+
+	fmt.Fprint(conn, "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+	raw, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "Transfer-Encoding")
+	assert.Contains(t, string(raw), "Connection: close\r\n")
+	assert.Contains(t, string(raw), "\r\n\r\nhello")
+}
+
+func TestChunkedKeepAliveHttp11(t *testing.T) {
+	conn := startServer(t, chunkedHandler)
+	r := bufio.NewReader(conn)
+
+	for i := 0; i < 2; i++ {
+		fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		resp, body := readResponse(t, r)
+		assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+		assert.Equal(t, "hello", body)
+	}
+}
+
+func TestBodilessKeepAlive(t *testing.T) {
+	errs := make(chan error, 1)
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		delete(h, "Content-Length")
+		switch req.RequestLine.RequestTarget {
+		case "/204":
+			w.WriteStatusLine(response.NoContent)
+		case "/204-chunked":
+			h["Transfer-Encoding"] = "chunked"
+			w.WriteStatusLine(response.NoContent)
+		case "/304":
+			h["Content-Length"] = "42"
+			w.WriteStatusLine(response.NotModified)
+		default:
+			helloHandler(w, req)
+			return
+		}
+		w.WriteHeaders(h)
+		_, err := w.WriteBody([]byte("stray"))
+		errs <- err
+	})
+	r := bufio.NewReader(conn)
+
+	for _, target := range []string{"/204", "/204-chunked", "/304"} {
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: localhost\r\n\r\n", target)
+		resp, body := readResponse(t, r)
+		assert.False(t, resp.Close, target)
+		assert.Empty(t, body, target)
+		assert.Empty(t, resp.TransferEncoding, target)
+		assert.ErrorIs(t, <-errs, response.ErrBodyNotAllowed, target)
+		if target == "/304" {
+			assert.Equal(t, "42", resp.Header.Get("Content-Length"))
+		} else {
+			assert.Empty(t, resp.Header.Get("Content-Length"), target)
+		}
+	}
+
+	// Nothing stray was left on the connection.
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, body := readResponse(t, r)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	conn := startServer(t, helloHandler)
+
+	fmt.Fprint(conn, "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 505, resp.StatusCode)
+}
+
+func TestSetCookieLines(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", Path: "/"})
+		w.SetCookie(&cookie.Cookie{Name: "b", Value: "2", Expires: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)})
+		assert.Error(t, w.SetCookie(&cookie.Cookie{Name: "c", Value: "x;y"}))
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		assert.Error(t, w.SetCookie(&cookie.Cookie{Name: "late", Value: "1"}))
+	})
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, []string{"a=1; Path=/", "b=2; Expires=Tue, 01 Jan 2030 00:00:00 GMT"}, resp.Header.Values("Set-Cookie"))
+}
+
+func TestMultipartUploadStreamsToHandler(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		assert.False(t, req.Complete())
+		require.NoError(t, req.ParseMultipartForm(1<<20))
+		body := req.FormValue("title") + ":" + req.MultipartForm.File["doc"][0].Filename
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+	})
+
+	body := "--b\r\nContent-Disposition: form-data; name=\"title\"\r\n\r\nnotes\r\n" +
+		"--b\r\nContent-Disposition: form-data; name=\"doc\"; filename=\"a.txt\"\r\n\r\nhi\r\n--b--\r\n"
+	fmt.Fprintf(conn, "POST /upload HTTP/1.1\r\nHost: localhost\r\n"+
+		"Content-Type: multipart/form-data; boundary=b\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	resp, got := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "notes:a.txt", got)
+}
+
+func TestDecodeJSON(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		var v struct {
+			Name string `json:"name"`
+		}
+		if !DecodeJSON(w, req, &v, request.JSONOptions{MaxBytes: 32, DisallowUnknownFields: true}) {
+			return
+		}
+		response.WriteJSON(w, response.OK, map[string]string{"hello": v.Name})
+	}
+
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+		respType    string
+		respBody    string
+	}{
+		{"application/json", `{"name":"chill"}`, 200, "application/json", `{"hello":"chill"}`},
+		{"text/plain", `{"name":"chill"}`, 415, "application/problem+json", ""},
+		{"application/json", `{"name":"a name that is far too long"}`, 413, "application/problem+json", ""},
+		{"application/json", `{"nickname":"chill"}`, 400, "application/problem+json", ""},
+	}
+	for _, tt := range tests {
+		conn := startServer(t, handler)
+		fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s",
+			tt.contentType, len(tt.body), tt.body)
+		resp, body := readResponse(t, bufio.NewReader(conn))
+		assert.Equal(t, tt.status, resp.StatusCode, tt.body)
+		assert.Equal(t, tt.respType, resp.Header.Get("Content-Type"))
+		if tt.respBody != "" {
+			assert.Equal(t, tt.respBody, body)
+			continue
+		}
+
+		var problem response.Problem
+		require.NoError(t, json.Unmarshal([]byte(body), &problem))
+		assert.Equal(t, tt.status, problem.Status)
+		assert.Equal(t, response.StatusText(response.StatusCode(tt.status)), problem.Title)
+		assert.NotEmpty(t, problem.Detail)
+	}
+}
+
+func TestAmbiguousFramingRejected(t *testing.T) {
+	conn := startServer(t, helloHandler)
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nhello")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.True(t, resp.Close)
+
+	conn = startServer(t, helloHandler)
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n")
+	resp, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 501, resp.StatusCode)
+}
+
+func TestHostRequiredOnlyOnHttp11(t *testing.T) {
+	conn := startServer(t, helloHandler)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\n\r\n")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 400, resp.StatusCode)
+
+	conn = startServer(t, helloHandler)
+	fmt.Fprint(conn, "GET / HTTP/1.0\r\n\r\n")
+	resp, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestDuplicateHost(t *testing.T) {
+	conn := startServer(t, helloHandler)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: a.test\r\nHost: b.test\r\n\r\n")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	body, err := req.ReadBody()
+	if err != nil {
+		return
+	}
+	w.WriteStatusLine(response.OK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestExpectContinue(t *testing.T) {
+	conn := startServer(t, echoHandler)
+	r := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n")
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", line)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", line)
+
+	fmt.Fprint(conn, "hello")
+	resp, body := readResponse(t, r)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+	assert.False(t, resp.Close)
+}
+
+func TestExpectContinueRejected(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.ExpectationFailed)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	})
+
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 417, resp.StatusCode)
+	assert.True(t, resp.Close)
+}
+
+func TestUnknownExpectation(t *testing.T) {
+	conn := startServer(t, echoHandler)
+
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: teapot\r\n\r\nhello")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 417, resp.StatusCode)
+}
+
+func TestEarlyHints(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		require.NoError(t, w.WriteEarlyHints("</style.css>; rel=preload; as=style"))
+		assert.Error(t, w.WriteInterim(response.SwitchingProtocols, nil))
+		helloHandler(w, req)
+	})
+	r := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	hints, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 103, hints.StatusCode)
+	assert.Equal(t, "</style.css>; rel=preload; as=style", hints.Header.Get("Link"))
+
+	resp, body := readResponse(t, r)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		body := []byte(req.RequestLine.RequestTarget + ":" + string(req.Body))
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+	r := bufio.NewReader(conn)
+
+	// All three requests go out in one write, before any response is read.
+	fmt.Fprint(conn,
+		"POST /1 HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\none"+
+			"GET /2 HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+			"POST /3 HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nthree\r\n0\r\n\r\n")
+
+	for _, want := range []string{"/1:one", "/2:", "/3:three"} {
+		_, body := readResponse(t, r)
+		assert.Equal(t, want, body)
+	}
+}
+
+func TestLenientParserOption(t *testing.T) {
+	s, err := Serve(0, helloHandler, WithParserOptions(request.Options{Mode: headers.Lenient}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\nHost: localhost\n\n")
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+}
+
+func TestContentEncodingDecoding(t *testing.T) {
+	opts := WithParserOptions(request.Options{DecodeContentEncoding: true, MaxBodySize: 1 << 10})
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("hello"))
+	zw.Close()
+	conn := startServer(t, echoHandler, opts)
+	fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", buf.Len(), buf.Bytes())
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+
+	conn = startServer(t, echoHandler, opts)
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: br\r\nContent-Length: 2\r\n\r\nxx")
+	resp, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 415, resp.StatusCode)
+
+	buf.Reset()
+	zw = gzip.NewWriter(&buf)
+	zw.Write(make([]byte, 1<<20))
+	zw.Close()
+	conn = startServer(t, echoHandler, opts)
+	fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", buf.Len(), buf.Bytes())
+	resp, _ = readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 413, resp.StatusCode)
+}
+
+func TestContentDigest(t *testing.T) {
+	sum := sha256.Sum256([]byte("hello"))
+	want := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+
+	conn := startServer(t, helloHandler)
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nWant-Content-Digest: sha-256=5\r\n\r\n")
+	resp, body := readResponse(t, r)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, want, resp.Header.Get("Content-Digest"))
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, _ = readResponse(t, r)
+	assert.Empty(t, resp.Header.Get("Content-Digest"))
+
+	conn = startServer(t, chunkedHandler)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nWant-Content-Digest: sha-256=5\r\n\r\n")
+	resp, body = readResponse(t, bufio.NewReader(conn))
+	chunkedSum := sha256.Sum256([]byte(body))
+	assert.Equal(t, "sha-256=:"+base64.StdEncoding.EncodeToString(chunkedSum[:])+":", resp.Trailer.Get("Content-Digest"))
+}
+
+func TestContentDigestMismatchRejected(t *testing.T) {
+	conn := startServer(t, echoHandler)
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Digest: sha-256=:AAAA:\r\nContent-Length: 5\r\n\r\nhello")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
+func TestHijack(t *testing.T) {
+	hijacked := make(chan error, 1)
+	conn := startServer(t, func(w *response.Writer, _ *request.Request) {
+		c, buffered, err := w.Hijack()
+		if err != nil {
+			hijacked <- err
+			return
+		}
+		_, _, err = w.Hijack()
+		assert.ErrorIs(t, err, response.ErrHijacked)
+		assert.ErrorIs(t, w.WriteStatusLine(response.OK), response.ErrHijacked)
+
+		// Speak a line protocol, starting with what the client pipelined
+		// behind its request.
+		r := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), c))
+		line, _ := r.ReadString('\n')
+		fmt.Fprintf(c, "echo %s", line)
+		go func() {
+			// The server leaves the connection open after the handler
+			// returns.
+			time.Sleep(50 * time.Millisecond)
+			fmt.Fprint(c, "still open\n")
+			c.Close()
+		}()
+		hijacked <- nil
+	})
+
+	fmt.Fprint(conn, "GET /raw HTTP/1.1\r\nHost: localhost\r\n\r\nping\n")
+	require.NoError(t, <-hijacked)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "echo ping\nstill open\n", string(rest))
+}
+
+func TestRejectHook(t *testing.T) {
+	rejections := make(chan Rejection, 1)
+	conn := startServer(t, helloHandler, WithRejectHook(func(r Rejection) {
+		rejections <- r
+	}))
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\n\r\n")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 400, resp.StatusCode)
+
+	r := <-rejections
+	assert.Equal(t, response.BadRequest, r.Status)
+	assert.ErrorIs(t, r.Err, request.ErrInvalidHost)
+	require.NotNil(t, r.Request)
+	assert.Equal(t, conn.LocalAddr().String(), r.RemoteAddr)
+}
+
+func TestRejectHookUnparsed(t *testing.T) {
+	rejections := make(chan Rejection, 1)
+	conn := startServer(t, helloHandler, WithRejectHook(func(r Rejection) {
+		rejections <- r
+	}))
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, "GET / HTTP/9.9\r\nHost: localhost\r\n\r\n")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 505, resp.StatusCode)
+
+	r := <-rejections
+	assert.Nil(t, r.Request)
+	assert.Equal(t, response.HttpVersionNotSupported, r.Status)
+	assert.ErrorIs(t, r.Err, request.ErrUnsupportedVersion)
+	assert.Equal(t, conn.LocalAddr().String(), r.RemoteAddr)
+}
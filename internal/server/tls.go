@@ -0,0 +1,281 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"chillhttp/internal/headers"
+	"chillhttp/internal/response"
+)
+
+// certCheckInterval is how often a CertStore looks at its files for changes.
+const certCheckInterval = 10 * time.Second
+
+// CertStore holds the server's certificates and picks one per connection by
+// SNI server name. It reloads certificates whose files change on disk, so
+// renewed certificates are picked up without a restart.
+type CertStore struct {
+	mu        sync.RWMutex
+	pairs     []*certPair
+	byName    map[string]*tls.Certificate
+	lastCheck time.Time
+	// checkInterval is how often GetCertificate looks for changed files.
+	checkInterval time.Duration
+}
+
+// certPair is a certificate and key loaded from files.
+type certPair struct {
+	certFile, keyFile string
+	modTime           time.Time
+	cert              *tls.Certificate
+}
+
+// NewCertStore returns a store with the certificate in certFile and keyFile,
+// which is also used for clients that don't send a known server name.
+func NewCertStore(certFile, keyFile string) (*CertStore, error) {
+	s := &CertStore{checkInterval: certCheckInterval}
+	if err := s.Add(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add loads another certificate, served to clients asking for one of the
+// names it is valid for.
+func (s *CertStore) Add(certFile, keyFile string) error {
+	pair := &certPair{certFile: certFile, keyFile: keyFile}
+	if err := pair.load(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pairs = append(s.pairs, pair)
+	s.index()
+	return nil
+}
+
+func (p *certPair) load() error {
+	info, err := os.Stat(p.certFile)
+	if err != nil {
+		return fmt.Errorf("error loading certificate: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading certificate: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("error loading certificate: %w", err)
+		}
+	}
+
+	p.cert = &cert
+	p.modTime = info.ModTime()
+	return nil
+}
+
+// changed reports whether the certificate file was modified since it was
+// loaded.
+func (p *certPair) changed() bool {
+	info, err := os.Stat(p.certFile)
+	return err == nil && !info.ModTime().Equal(p.modTime)
+}
+
+// index maps every name the certificates are valid for to the certificate.
+// Earlier certificates win when two cover the same name.
+func (s *CertStore) index() {
+	s.byName = make(map[string]*tls.Certificate)
+	for _, pair := range s.pairs {
+		leaf := pair.cert.Leaf
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, ok := s.byName[name]; !ok {
+				s.byName[name] = pair.cert
+			}
+		}
+	}
+}
+
+// Reload reads every certificate from disk again. If any fails to load, the
+// store keeps serving the certificates it had.
+func (s *CertStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reload(func(*certPair) bool { return true })
+}
+
+func (s *CertStore) reload(should func(*certPair) bool) error {
+	fresh := make([]*certPair, len(s.pairs))
+	reloaded := false
+	for i, pair := range s.pairs {
+		fresh[i] = pair
+		if !should(pair) {
+			continue
+		}
+		p := &certPair{certFile: pair.certFile, keyFile: pair.keyFile}
+		if err := p.load(); err != nil {
+			return err
+		}
+		fresh[i] = p
+		reloaded = true
+	}
+
+	if reloaded {
+		s.pairs = fresh
+		s.index()
+	}
+	return nil
+}
+
+// reloadChanged reloads certificates whose files changed, at most once per
+// checkInterval. A half-written file fails to load and is tried again at the
+// next check.
+func (s *CertStore) reloadChanged() {
+	s.mu.RLock()
+	due := time.Since(s.lastCheck) >= s.checkInterval
+	s.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.lastCheck) < s.checkInterval {
+		return
+	}
+	s.lastCheck = time.Now()
+	s.reload((*certPair).changed)
+}
+
+// GetCertificate picks the certificate for a handshake, for use as
+// tls.Config.GetCertificate: an exact match for the SNI server name, then a
+// wildcard one, then the first certificate added.
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.reloadChanged()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := s.byName[name]; ok {
+		return cert, nil
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		if cert, ok := s.byName["*."+parent]; ok {
+			return cert, nil
+		}
+	}
+	if len(s.pairs) == 0 {
+		return nil, errors.New("no certificates configured")
+	}
+	return s.pairs[0].cert, nil
+}
+
+// WithTLSConfig sets the base TLS configuration for ServeTLS. It is cloned,
+// and ServeTLS fills in the certificates.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(s *Server) {
+		s.TLSConfig = config.Clone()
+	}
+}
+
+// WithCertificate adds a certificate for ServeTLS to serve to clients
+// asking, through SNI, for a name it is valid for.
+func WithCertificate(certFile, keyFile string) Option {
+	return func(s *Server) {
+		s.extraCerts = append(s.extraCerts, [2]string{certFile, keyFile})
+	}
+}
+
+// WithClientAuth turns on mutual TLS: clients present certificates signed by
+// one of cas, and handlers find them in Request.TLS.PeerCertificates. With
+// tls.VerifyClientCertIfGiven, clients without one are still served.
+func WithClientAuth(cas *x509.CertPool, auth tls.ClientAuthType) Option {
+	return func(s *Server) {
+		s.clientCAs = cas
+		s.clientAuth = auth
+	}
+}
+
+// WithHSTS makes responses over TLS carry a Strict-Transport-Security header
+// (RFC 6797) telling browsers to use HTTPS only, for maxAge.
+func WithHSTS(maxAge time.Duration, includeSubDomains, preload bool) Option {
+	return func(s *Server) {
+		s.hsts = fmt.Sprintf("max-age=%d", int(maxAge.Seconds()))
+		if includeSubDomains {
+			s.hsts += "; includeSubDomains"
+		}
+		if preload {
+			s.hsts += "; preload"
+		}
+	}
+}
+
+// ServeTLS is Serve over TLS, serving the certificate in certFile and
+// keyFile. Both may be empty if WithCertificate or WithTLSConfig supplies
+// the certificates.
+func ServeTLS(port int, handler Handler, certFile, keyFile string, opts ...Option) (*Server, error) {
+	s := newServer(handler, opts)
+
+	config := s.TLSConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config.MinVersion = max(config.MinVersion, tls.VersionTLS12)
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+	if s.clientCAs != nil {
+		config.ClientCAs = s.clientCAs
+		config.ClientAuth = s.clientAuth
+	}
+
+	pairs := s.extraCerts
+	if certFile != "" {
+		pairs = append([][2]string{{certFile, keyFile}}, pairs...)
+	}
+	if len(pairs) > 0 {
+		certs, err := NewCertStore(pairs[0][0], pairs[0][1])
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range pairs[1:] {
+			if err := certs.Add(pair[0], pair[1]); err != nil {
+				return nil, err
+			}
+		}
+		s.Certs = certs
+		config.GetCertificate = certs.GetCertificate
+	} else if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return nil, errors.New("error creating listener: no TLS certificate configured")
+	}
+	s.TLSConfig = config
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("error creating listener: %w", err)
+	}
+	s.Listener = tls.NewListener(s.countBytes(l), config)
+	go s.listen()
+	return s, nil
+}
+
+// hstsFilter adds the Strict-Transport-Security header to a response.
+func (s *Server) hstsFilter(_ response.StatusCode, h headers.Headers) response.Encoder {
+	if h.Get("Strict-Transport-Security") == "" {
+		h.Set("Strict-Transport-Security", s.hsts)
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "chillhttp test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+var serial int64 = 1
+
+// issue creates a certificate for names, or a client certificate if client
+// is set, and returns its PEM-encoded certificate and key.
+func (ca *testCA) issue(t *testing.T, commonName string, names []string, client bool) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if client {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+// writeCert issues a server certificate for names into dir.
+func (ca *testCA) writeCert(t *testing.T, dir, name string, names ...string) (certFile, keyFile string) {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, names[0], names, false)
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+	return certFile, keyFile
+}
+
+// dialTLS connects to s asking for serverName.
+func dialTLS(t *testing.T, s *Server, ca *testCA, serverName string, clientCerts ...tls.Certificate) *tls.Conn {
+	t.Helper()
+	conn, err := tls.Dial("tcp", s.Listener.Addr().String(), &tls.Config{
+		RootCAs:      ca.pool,
+		ServerName:   serverName,
+		Certificates: clientCerts,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func startTLSServer(t *testing.T, handler Handler, certFile, keyFile string, opts ...Option) *Server {
+	t.Helper()
+	s, err := ServeTLS(0, handler, certFile, keyFile, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestServeTLS(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.writeCert(t, t.TempDir(), "localhost", "localhost")
+	s := startTLSServer(t, helloHandler, certFile, keyFile, WithHSTS(365*24*time.Hour, true, false))
+
+	conn := dialTLS(t, s, ca, "localhost")
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "max-age=31536000; includeSubDomains", resp.Header.Get("Strict-Transport-Security"))
+}
+
+func TestHSTSOnRejections(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.writeCert(t, t.TempDir(), "localhost", "localhost")
+	s := startTLSServer(t, helloHandler, certFile, keyFile, WithHSTS(time.Hour, false, false))
+
+	for _, raw := range []string{
+		"GET / HTTP/1.1\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\n",
+	} {
+		conn := dialTLS(t, s, ca, "localhost")
+		fmt.Fprint(conn, raw)
+		resp, _ := readResponse(t, bufio.NewReader(conn))
+		assert.Equal(t, 400, resp.StatusCode)
+		assert.Equal(t, "max-age=3600", resp.Header.Get("Strict-Transport-Security"), raw)
+	}
+}
+
+func TestNoHSTSOverPlainConnections(t *testing.T) {
+	conn := startServer(t, helloHandler, WithHSTS(time.Hour, false, false))
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Empty(t, resp.Header.Get("Strict-Transport-Security"))
+}
+
+func TestSNICertificateSelection(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	defaultCert, defaultKey := ca.writeCert(t, dir, "default", "default.test")
+	aCert, aKey := ca.writeCert(t, dir, "a", "a.test")
+	wildCert, wildKey := ca.writeCert(t, dir, "wild", "*.b.test")
+	s := startTLSServer(t, helloHandler, defaultCert, defaultKey,
+		WithCertificate(aCert, aKey), WithCertificate(wildCert, wildKey))
+
+	for serverName, want := range map[string]string{
+		"a.test":       "a.test",
+		"www.b.test":   "*.b.test",
+		"default.test": "default.test",
+	} {
+		conn := dialTLS(t, s, ca, serverName)
+		assert.Equal(t, want, conn.ConnectionState().PeerCertificates[0].DNSNames[0], serverName)
+	}
+
+	// Unknown names get the default certificate, which doesn't verify.
+	_, err := tls.Dial("tcp", s.Listener.Addr().String(), &tls.Config{RootCAs: ca.pool, ServerName: "other.test"})
+	assert.Error(t, err)
+}
+
+func TestCertificatesWithoutDefault(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.writeCert(t, t.TempDir(), "a", "a.test")
+	s := startTLSServer(t, helloHandler, "", "", WithCertificate(certFile, keyFile))
+
+	conn := dialTLS(t, s, ca, "a.test")
+	assert.Equal(t, "a.test", conn.ConnectionState().PeerCertificates[0].DNSNames[0])
+	require.NotNil(t, s.Certs)
+}
+
+func TestCertificateHotReload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.writeCert(t, dir, "site", "localhost")
+	s := startTLSServer(t, helloHandler, certFile, keyFile)
+
+	serialOf := func() *big.Int {
+		conn := dialTLS(t, s, ca, "localhost")
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber
+	}
+	first := serialOf()
+
+	// Renew the certificate in place; Reload picks it up at once.
+	ca.writeCert(t, dir, "site", "localhost")
+	require.NoError(t, s.Certs.Reload())
+	second := serialOf()
+	assert.NotEqual(t, first, second)
+
+	// Changes are also noticed on their own.
+	s.Certs.mu.Lock()
+	s.Certs.checkInterval = 0
+	s.Certs.mu.Unlock()
+	ca.writeCert(t, dir, "site", "localhost")
+	// Filesystems may keep modification times to the second only.
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	assert.NotEqual(t, second, serialOf())
+
+	// A broken file doesn't take the site down.
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	assert.Error(t, s.Certs.Reload())
+	serialOf()
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile := ca.writeCert(t, t.TempDir(), "localhost", "localhost")
+	handler := func(w *response.Writer, req *request.Request) {
+		body := []byte("anonymous")
+		if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+			body = []byte(req.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+	s := startTLSServer(t, handler, certFile, keyFile, WithClientAuth(ca.pool, tls.RequireAndVerifyClientCert))
+
+	certPEM, keyPEM := ca.issue(t, "alice", nil, true)
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	conn := dialTLS(t, s, ca, "localhost", clientCert)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "alice", body)
+
+	// Without a client certificate the handshake fails. In TLS 1.3 the
+	// client only learns when it first reads.
+	raw, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer raw.Close()
+	anon := tls.Client(raw, &tls.Config{RootCAs: ca.pool, ServerName: "localhost"})
+	if err := anon.Handshake(); err == nil {
+		fmt.Fprint(anon, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		_, err = bufio.NewReader(anon).ReadByte()
+		assert.Error(t, err)
+	}
+}
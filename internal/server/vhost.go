@@ -0,0 +1,69 @@
+package server
+
+import (
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+	"strings"
+)
+
+// VirtualHosts dispatches requests to a Handler chosen by the request's host,
+// so that one server can front several applications. Names are matched
+// exactly first, then against wildcard patterns such as "*.example.test",
+// most specific first, and finally fall back to Default.
+type VirtualHosts struct {
+	Default Handler
+
+	exact    map[string]Handler
+	wildcard map[string]Handler // keyed by suffix, e.g. ".example.test"
+}
+
+func NewVirtualHosts(defaultHandler Handler) *VirtualHosts {
+	return &VirtualHosts{
+		Default:  defaultHandler,
+		exact:    make(map[string]Handler),
+		wildcard: make(map[string]Handler),
+	}
+}
+
+// Handle registers handler for pattern, either an exact host name
+// ("api.example.test") or a wildcard ("*.example.test") matching any
+// subdomain but not the bare domain itself.
+func (v *VirtualHosts) Handle(pattern string, handler Handler) {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		v.wildcard[suffix] = handler
+		return
+	}
+	v.exact[pattern] = handler
+}
+
+// Lookup returns the handler for host, or Default if nothing matches.
+func (v *VirtualHosts) Lookup(host string) Handler {
+	if handler, ok := v.exact[host]; ok {
+		return handler
+	}
+
+	// Walk up the labels so "a.b.example.test" tries ".b.example.test"
+	// before ".example.test".
+	for i := strings.IndexByte(host, '.'); i != -1; {
+		if handler, ok := v.wildcard[host[i:]]; ok {
+			return handler
+		}
+		next := strings.IndexByte(host[i+1:], '.')
+		if next == -1 {
+			break
+		}
+		i += next + 1
+	}
+	return v.Default
+}
+
+// Dispatch is a Handler, so a VirtualHosts can be passed straight to Serve.
+func (v *VirtualHosts) Dispatch(w *response.Writer, req *request.Request) {
+	handler := v.Lookup(req.Host())
+	if handler == nil {
+		writeError(w, &HandlerError{Code: int(response.NotFound), Err: "unknown host"})
+		return
+	}
+	handler(w, req)
+}
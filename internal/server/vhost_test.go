@@ -0,0 +1,52 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"testing"
+
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func namedHandler(name string) Handler {
+	return func(w *response.Writer, _ *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(name)))
+		w.WriteBody([]byte(name))
+	}
+}
+
+func TestVirtualHostsLookup(t *testing.T) {
+	vh := NewVirtualHosts(namedHandler("default"))
+	vh.Handle("api.example.test", namedHandler("api"))
+	vh.Handle("*.example.test", namedHandler("wildcard"))
+	vh.Handle("*.eu.example.test", namedHandler("eu"))
+
+	tests := map[string]string{
+		"api.example.test":      "api",
+		"www.example.test":      "wildcard",
+		"a.b.example.test":      "wildcard",
+		"paris.eu.example.test": "eu",
+		"example.test":          "default",
+		"other.test":            "default",
+	}
+	for host, want := range tests {
+		conn := startServer(t, vh.Dispatch)
+		fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s:42069\r\n\r\n", host)
+		_, body := readResponse(t, bufio.NewReader(conn))
+		assert.Equal(t, want, body, host)
+	}
+}
+
+func TestVirtualHostsNoDefault(t *testing.T) {
+	vh := NewVirtualHosts(nil)
+	vh.Handle("api.example.test", namedHandler("api"))
+
+	conn := startServer(t, vh.Dispatch)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: other.test\r\n\r\n")
+	resp, _ := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 404, resp.StatusCode)
+}
@@ -0,0 +1,251 @@
+// Package sse streams Server-Sent Events (the text/event-stream format of
+// the HTML Living Standard) over a response.Writer.
+package sse
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+)
+
+// DefaultHeartbeat is how often a Stream sends a comment when Options
+// doesn't say otherwise, well within the idle timeouts of common proxies.
+const DefaultHeartbeat = 15 * time.Second
+
+var (
+	// ErrClosed is returned by writes to a Stream after Close, once the
+	// client has gone away or once the handler has returned.
+	ErrClosed = errors.New("sse: stream closed")
+
+	errInvalidField = errors.New("sse: event and id fields can't contain line breaks")
+)
+
+// Event is one event of a stream. Data may span several lines; Event and ID
+// may not.
+type Event struct {
+	// Event names the event type; empty means "message".
+	Event string
+	// ID becomes the client's last event ID, which it sends back in the
+	// Last-Event-ID header when it reconnects.
+	ID   string
+	Data string
+	// Retry, if set, tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+type Options struct {
+	// Heartbeat is how often a comment is sent to keep the connection open
+	// through proxies and to notice clients that went away. 0 means
+	// DefaultHeartbeat; a negative value sends none.
+	Heartbeat time.Duration
+	// Retry, if set, is sent first as the client's reconnection delay.
+	Retry time.Duration
+}
+
+// Stream writes events to a client. Its methods may be called from several
+// goroutines.
+type Stream struct {
+	w   *response.Writer
+	ctx context.Context
+
+	mu     sync.Mutex
+	closed bool
+	// done is closed when the stream ends, by Close, with the request's
+	// context or because a write failed.
+	done chan struct{}
+	stop chan struct{}
+}
+
+// NewStream starts an event stream in answer to req: it writes the status
+// and headers, then sends heartbeats until Close. The stream also ends when
+// req's context is done, which the server does as soon as the client goes
+// away and once the handler returns, so nothing is written after that. The
+// handler should still call Close before it returns, to end the response
+// cleanly.
+func NewStream(w *response.Writer, req *request.Request, opts Options) (*Stream, error) {
+	h := response.GetDefaultHeaders(0)
+	delete(h, "Content-Length")
+	h["Content-Type"] = "text/event-stream"
+	h["Cache-Control"] = "no-cache"
+	h["Transfer-Encoding"] = "chunked"
+	// Ask buffering reverse proxies such as nginx to pass events on at once.
+	h["X-Accel-Buffering"] = "no"
+
+	if err := w.WriteStatusLine(response.OK); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	s := &Stream{
+		w:    w,
+		ctx:  req.Context(),
+		done: make(chan struct{}),
+		stop: make(chan struct{}),
+	}
+	if opts.Retry > 0 {
+		if err := s.write(appendRetry(nil, opts.Retry)); err != nil {
+			return nil, err
+		}
+	}
+
+	heartbeat := opts.Heartbeat
+	if heartbeat == 0 {
+		heartbeat = DefaultHeartbeat
+	}
+	if heartbeat > 0 {
+		go s.heartbeat(heartbeat)
+	}
+	go s.watch()
+	return s, nil
+}
+
+// LastEventID returns the ID of the last event a reconnecting client saw,
+// so the handler can resume after it.
+func LastEventID(req *request.Request) string {
+	return req.Headers.Get("Last-Event-ID")
+}
+
+// Send writes an event and flushes it to the client.
+func (s *Stream) Send(e Event) error {
+	if strings.ContainsAny(e.Event, "\r\n") || strings.ContainsAny(e.ID, "\r\n\x00") {
+		return errInvalidField
+	}
+	return s.write(appendEvent(nil, e))
+}
+
+// Comment writes a comment line, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var b []byte
+	for _, line := range splitLines(text) {
+		b = append(b, ':')
+		if line != "" {
+			b = append(b, ' ')
+			b = append(b, line...)
+		}
+		b = append(b, '\n')
+	}
+	return s.write(append(b, '\n'))
+}
+
+// Done is closed when the stream ends: after Close, once the request's
+// context is done because the client went away or the handler returned, or
+// once a write failed.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close stops the heartbeats and ends the response. It is safe to call more
+// than once.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended() {
+		return nil
+	}
+	s.end()
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return s.w.WriteTrailers(nil)
+}
+
+// end marks the stream closed. Callers hold mu.
+func (s *Stream) end() {
+	s.closed = true
+	close(s.stop)
+	close(s.done)
+}
+
+// ended reports whether the stream is over, ending it first if the
+// request's context is done. Callers hold mu.
+func (s *Stream) ended() bool {
+	if !s.closed && s.ctx.Err() != nil {
+		s.end()
+	}
+	return s.closed
+}
+
+func (s *Stream) write(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended() {
+		return ErrClosed
+	}
+	// Each write is its own chunk, so it reaches the client at once.
+	if _, err := s.w.WriteChunkedBody(b); err != nil {
+		s.end()
+		return err
+	}
+	return nil
+}
+
+// watch ends the stream when the request's context is done.
+func (s *Stream) watch() {
+	select {
+	case <-s.stop:
+	case <-s.ctx.Done():
+		s.mu.Lock()
+		s.ended()
+		s.mu.Unlock()
+	}
+}
+
+func (s *Stream) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if s.write([]byte(":\n\n")) != nil {
+				return
+			}
+		}
+	}
+}
+
+// appendEvent serializes e. Every line of Data becomes a data field of its
+// own, so line breaks in it survive.
+func appendEvent(b []byte, e Event) []byte {
+	if e.Event != "" {
+		b = append(b, "event: "...)
+		b = append(b, e.Event...)
+		b = append(b, '\n')
+	}
+	if e.ID != "" {
+		b = append(b, "id: "...)
+		b = append(b, e.ID...)
+		b = append(b, '\n')
+	}
+	if e.Retry > 0 {
+		b = appendRetry(b, e.Retry)
+	}
+	for _, line := range splitLines(e.Data) {
+		b = append(b, "data: "...)
+		b = append(b, line...)
+		b = append(b, '\n')
+	}
+	return append(b, '\n')
+}
+
+func appendRetry(b []byte, retry time.Duration) []byte {
+	b = append(b, "retry: "...)
+	b = strconv.AppendInt(b, retry.Milliseconds(), 10)
+	return append(b, '\n')
+}
+
+// splitLines splits s at CRLF, CR and LF, the line breaks of the format.
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
@@ -0,0 +1,182 @@
+package sse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+	"chillhttp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{"data only", Event{Data: "hello"}, "data: hello\n\n"},
+		{"all fields", Event{Event: "progress", ID: "7", Retry: 3 * time.Second, Data: "50%"},
+			"event: progress\nid: 7\nretry: 3000\ndata: 50%\n\n"},
+		{"multi-line data", Event{Data: "one\ntwo\r\nthree\rfour"},
+			"data: one\ndata: two\ndata: three\ndata: four\n\n"},
+		{"empty data", Event{Event: "ping"}, "event: ping\ndata: \n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, string(appendEvent(nil, tt.event)))
+		})
+	}
+}
+
+func startServer(t *testing.T, handler server.Handler) net.Conn {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestStream(t *testing.T) {
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, Options{Heartbeat: -1, Retry: time.Second})
+		if err != nil {
+			return
+		}
+		defer s.Close()
+		s.Send(Event{ID: LastEventID(req) + "+1", Data: "line one\nline two"})
+		assert.ErrorIs(t, s.Send(Event{Event: "bad\nname"}), errInvalidField)
+		s.Comment("bye")
+	})
+
+	fmt.Fprint(conn, "GET /events HTTP/1.1\r\nHost: localhost\r\nLast-Event-ID: 41\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "retry: 1000\nid: 41+1\ndata: line one\ndata: line two\n\n: bye\n\n", string(body))
+}
+
+func TestHeartbeatAndDisconnect(t *testing.T) {
+	done := make(chan struct{})
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, Options{Heartbeat: 10 * time.Millisecond})
+		if err != nil {
+			return
+		}
+		defer s.Close()
+		select {
+		case <-s.Done():
+			close(done)
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	fmt.Fprint(conn, "GET /events HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, ":\n", line)
+
+	// Once the client goes away, a heartbeat fails and the stream ends.
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("disconnect not detected")
+	}
+}
+
+func TestDisconnectWithoutHeartbeat(t *testing.T) {
+	done := make(chan struct{})
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, Options{Heartbeat: -1})
+		if err != nil {
+			return
+		}
+		defer s.Close()
+		select {
+		case <-s.Done():
+			close(done)
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	fmt.Fprint(conn, "GET /events HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	_, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+
+	// The request's context ends the stream with no write to fail.
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("disconnect not detected")
+	}
+}
+
+func TestStreamEndsWithHandler(t *testing.T) {
+	streams := make(chan *Stream, 1)
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, Options{Heartbeat: -1})
+		if err != nil {
+			return
+		}
+		streams <- s
+	})
+
+	fmt.Fprint(conn, "GET /events HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	// Without Close the body is left unterminated.
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	s := <-streams
+	select {
+	case <-s.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("stream outlived its handler")
+	}
+	assert.ErrorIs(t, s.Send(Event{Data: "late"}), ErrClosed)
+}
+
+func TestWritesAfterClose(t *testing.T) {
+	result := make(chan error, 1)
+	conn := startServer(t, func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, Options{Heartbeat: -1})
+		if err != nil {
+			return
+		}
+		s.Close()
+		s.Close()
+		result <- s.Send(Event{Data: "late"})
+	})
+
+	fmt.Fprint(conn, "GET /events HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+	assert.ErrorIs(t, <-result, ErrClosed)
+}
@@ -0,0 +1,190 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// DefaultOTLPEndpoint is where an OpenTelemetry Collector on the same host
+// takes traces over OTLP/HTTP.
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// instrumentationScope names this package in exported spans.
+const instrumentationScope = "chillhttp/internal/tracing"
+
+type OTLPOptions struct {
+	// Endpoint is the URL spans are POSTed to; empty means
+	// DefaultOTLPEndpoint.
+	Endpoint string
+	// ServiceName is the service.name resource attribute; empty means
+	// "chillhttp".
+	ServiceName string
+	// Headers are added to every export request, e.g. for authentication.
+	Headers map[string]string
+	// Client sends the requests; nil means http.DefaultClient.
+	Client *http.Client
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector with OTLP/HTTP,
+// encoded as JSON.
+type OTLPExporter struct {
+	opts OTLPOptions
+}
+
+func NewOTLPExporter(opts OTLPOptions) *OTLPExporter {
+	if opts.Endpoint == "" {
+		opts.Endpoint = DefaultOTLPEndpoint
+	}
+	if opts.ServiceName == "" {
+		opts.ServiceName = "chillhttp"
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	return &OTLPExporter{opts: opts}
+}
+
+// ExportSpans sends spans in one ExportTraceServiceRequest.
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", e.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.opts.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// A partial success still comes with 200; the rejected spans are
+	// lost either way.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("tracing: collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Shutdown does nothing: requests are only made while exporting.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// The OTLP JSON encoding of an ExportTraceServiceRequest. IDs are hex, not
+// base64 as elsewhere in protobuf JSON, and 64-bit integers are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		TraceState        string         `json:"traceState,omitempty"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Flags             uint32         `json:"flags"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code StatusCode `json:"code,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+		BoolValue   *bool   `json:"boolValue,omitempty"`
+	}
+)
+
+func (e *OTLPExporter) request(spans []*Span) otlpRequest {
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		out[i] = otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			TraceState:        s.TraceState,
+			Flags:             uint32(s.Flags),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: s.Status},
+		}
+		if s.ParentSpanID.IsValid() {
+			out[i].ParentSpanID = s.ParentSpanID.String()
+		}
+		for _, a := range s.Attributes {
+			out[i].Attributes = append(out[i].Attributes, otlpAttribute(a.Key, a.Value))
+		}
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			otlpAttribute("service.name", e.opts.ServiceName),
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: instrumentationScope},
+			Spans: out,
+		}},
+	}}}
+}
+
+// otlpAttribute encodes an attribute; values other than strings, integers
+// and bools are sent as their fmt representation.
+func otlpAttribute(key string, value any) otlpKeyValue {
+	var v otlpAnyValue
+	switch value := value.(type) {
+	case string:
+		v.StringValue = &value
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.IntValue = &s
+	case int:
+		s := strconv.Itoa(value)
+		v.IntValue = &s
+	case bool:
+		v.BoolValue = &value
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+	return otlpKeyValue{Key: key, Value: v}
+}
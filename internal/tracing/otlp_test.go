@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	var got map[string]any
+	var contentType, auth string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		contentType = r.Header.Get("Content-Type")
+		auth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &got))
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "{}")
+	}))
+	defer collector.Close()
+
+	exp := NewOTLPExporter(OTLPOptions{
+		Endpoint:    collector.URL + "/v1/traces",
+		ServiceName: "edge",
+		Headers:     map[string]string{"Authorization": "Bearer token"},
+	})
+	sc, _ := ParseTraceparent(parent)
+	start := time.Unix(1700000000, 5)
+	span := &Span{
+		Name:         "GET /users/{id}",
+		SpanContext:  SpanContext{TraceID: sc.TraceID, SpanID: SpanID{0xab, 1}, Flags: FlagSampled, TraceState: "v=1"},
+		ParentSpanID: sc.SpanID,
+		Kind:         SpanKindServer,
+		Start:        start,
+		End:          start.Add(time.Millisecond),
+		Status:       StatusError,
+	}
+	span.SetAttribute("http.request.method", "GET")
+	span.SetAttribute("http.response.status_code", int64(500))
+	span.SetAttribute("cached", false)
+	require.NoError(t, exp.ExportSpans(context.Background(), []*Span{span}))
+	require.NoError(t, exp.Shutdown(context.Background()))
+
+	assert.Equal(t, "application/json", contentType)
+	assert.Equal(t, "Bearer token", auth)
+
+	var want map[string]any
+	require.NoError(t, json.Unmarshal([]byte(`{"resourceSpans": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "edge"}}]},
+		"scopeSpans": [{
+			"scope": {"name": "chillhttp/internal/tracing"},
+			"spans": [{
+				"traceId": "4bf92f3577b34da6a3ce929d0e0e4736",
+				"spanId": "ab01000000000000",
+				"parentSpanId": "00f067aa0ba902b7",
+				"traceState": "v=1",
+				"flags": 1,
+				"name": "GET /users/{id}",
+				"kind": 2,
+				"startTimeUnixNano": "1700000000000000005",
+				"endTimeUnixNano": "1700000000001000005",
+				"attributes": [
+					{"key": "http.request.method", "value": {"stringValue": "GET"}},
+					{"key": "http.response.status_code", "value": {"intValue": "500"}},
+					{"key": "cached", "value": {"boolValue": false}}
+				],
+				"status": {"code": 2}
+			}]
+		}]
+	}]}`), &want))
+	assert.Equal(t, want, got)
+}
+
+func TestOTLPExporterCollectorError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	exp := NewOTLPExporter(OTLPOptions{Endpoint: collector.URL})
+	err := exp.ExportSpans(context.Background(), []*Span{{Name: "x"}})
+	assert.ErrorContains(t, err, "503")
+}
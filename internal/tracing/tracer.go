@@ -0,0 +1,252 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+	"chillhttp/internal/server"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// exportTimeout bounds each call to Exporter.ExportSpans.
+const exportTimeout = 10 * time.Second
+
+// An Exporter sends finished spans somewhere, such as a tracing backend.
+// The Tracer calls ExportSpans from one goroutine at a time.
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []*Span) error
+	// Shutdown releases what the exporter holds once no more spans
+	// will come.
+	Shutdown(ctx context.Context) error
+}
+
+type Options struct {
+	// BatchSize is how many spans are exported at once; 0 means 512.
+	BatchSize int
+	// Interval is how long a span waits at most to be exported; 0 means
+	// 5 seconds.
+	Interval time.Duration
+	// MaxQueueSize is how many spans may wait to be exported; spans
+	// beyond it are dropped. 0 means 4 times BatchSize.
+	MaxQueueSize int
+}
+
+// Tracer records the spans of sampled traces and exports them in batches in
+// the background.
+type Tracer struct {
+	exporter Exporter
+	opts     Options
+
+	// exportMu keeps exports, from the loop and ForceFlush, one at a
+	// time.
+	exportMu sync.Mutex
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+	stopped bool
+
+	// flush asks the export loop for a batch before the interval is up,
+	// and stop ends it; done is closed once it has.
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func NewTracer(exporter Exporter, opts Options) *Tracer {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 512
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Second
+	}
+	if opts.MaxQueueSize <= 0 {
+		opts.MaxQueueSize = 4 * opts.BatchSize
+	}
+	t := &Tracer{
+		exporter: exporter,
+		opts:     opts,
+		flush:    make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.loop()
+	return t
+}
+
+// Middleware returns middleware that gives each request a server span. It
+// continues the trace of the request's traceparent, if it has a valid one,
+// or starts a new trace. The span is in the request's context, where
+// Inject, and so proxy.NewRequest, finds it; it is named after the route
+// the handler gives with server.SetRoute, and ends when the handler
+// returns.
+func (t *Tracer) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			span := &Span{
+				Kind:  SpanKindServer,
+				Start: time.Now(),
+			}
+			if parent, ok := Extract(req.Headers); ok {
+				span.SpanContext = parent
+				span.Flags = parent.Flags & FlagSampled
+				span.ParentSpanID = parent.SpanID
+			} else {
+				span.TraceID = newTraceID()
+				span.Flags = FlagSampled
+			}
+			span.SpanID = newSpanID()
+			req.WithContext(ContextWithSpan(req.Context(), span))
+
+			next(w, req)
+
+			span.End = time.Now()
+			t.describe(span, w, req)
+			t.end(span)
+		}
+	}
+}
+
+// describe names the span and sets its attributes, after the OpenTelemetry
+// semantic conventions for HTTP servers.
+func (t *Tracer) describe(span *Span, w *response.Writer, req *request.Request) {
+	method := req.RequestLine.Method
+	path, query, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	route := server.Route(req)
+
+	span.Name = method
+	if route != "" {
+		span.Name += " " + route
+		span.SetAttribute("http.route", route)
+	}
+	span.SetAttribute("http.request.method", method)
+	span.SetAttribute("url.path", path)
+	if query != "" {
+		span.SetAttribute("url.query", query)
+	}
+	span.SetAttribute("server.address", req.Host())
+	span.SetAttribute("network.protocol.version", req.RequestLine.HttpVersion)
+	if req.RemoteAddr != "" {
+		span.SetAttribute("client.address", req.RemoteAddr)
+	}
+	if ua := req.Headers.Get("User-Agent"); ua != "" {
+		span.SetAttribute("user_agent.original", ua)
+	}
+	if id := server.GetRequestID(req); id != "" {
+		span.SetAttribute("http.request.header.x-request-id", id)
+	}
+	if status := w.Status(); status != 0 {
+		span.SetAttribute("http.response.status_code", int64(status))
+		if status >= 500 {
+			span.Status = StatusError
+		}
+	}
+	span.SetAttribute("http.response.body.size", w.BytesWritten())
+}
+
+// end queues a finished span for export, if its trace is sampled.
+func (t *Tracer) end(span *Span) {
+	if !span.Sampled() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+	if len(t.queue) >= t.opts.MaxQueueSize {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, span)
+	if len(t.queue) >= t.opts.BatchSize {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) loop() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		case <-t.flush:
+		}
+		t.export(context.Background())
+	}
+}
+
+// export sends everything queued, a batch at a time, returning the first
+// error.
+func (t *Tracer) export(ctx context.Context) error {
+	t.exportMu.Lock()
+	defer t.exportMu.Unlock()
+
+	var firstErr error
+	for {
+		t.mu.Lock()
+		n := min(len(t.queue), t.opts.BatchSize)
+		batch := t.queue[:n:n]
+		t.queue = t.queue[n:]
+		dropped := t.dropped
+		t.dropped = 0
+		t.mu.Unlock()
+
+		if dropped > 0 {
+			log.Warn("tracing: export queue full, spans dropped", zap.Int("dropped", dropped))
+		}
+		if n == 0 {
+			return firstErr
+		}
+
+		exportCtx, cancel := context.WithTimeout(ctx, exportTimeout)
+		err := t.exporter.ExportSpans(exportCtx, batch)
+		cancel()
+		if err != nil {
+			log.Warn("tracing: exporting spans failed", zap.Int("spans", n), zap.Error(err))
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				return firstErr
+			}
+		}
+	}
+}
+
+// ForceFlush exports the spans waiting to be, without waiting for the
+// interval.
+func (t *Tracer) ForceFlush(ctx context.Context) error {
+	return t.export(ctx)
+}
+
+// Shutdown stops the tracer: spans ending afterwards are dropped, those
+// waiting are exported, and then the exporter is shut down too.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return nil
+	}
+	t.stopped = true
+	t.mu.Unlock()
+
+	close(t.stop)
+	<-t.done
+	if err := t.export(ctx); err != nil {
+		return err
+	}
+	return t.exporter.Shutdown(ctx)
+}
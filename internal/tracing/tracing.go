@@ -0,0 +1,234 @@
+// Package tracing records a span for every request and carries traces
+// across services with the W3C Trace Context headers, traceparent and
+// tracestate. Spans go to a pluggable Exporter, such as the OTLP/HTTP one
+// of NewOTLPExporter.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"chillhttp/internal/headers"
+)
+
+// maxTraceStateLength is how long a tracestate we pass on may be (W3C Trace
+// Context section 3.3.1.5).
+const maxTraceStateLength = 512
+
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether id isn't all zeros, which means no trace.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether id isn't all zeros, which means no span.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// FlagSampled in SpanContext.Flags means the trace is being recorded.
+const FlagSampled byte = 0x01
+
+// SpanContext identifies a span across services: what traceparent and
+// tracestate carry.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+}
+
+// Sampled reports whether the trace is being recorded.
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// Traceparent returns sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	b := make([]byte, 0, 55)
+	b = append(b, "00-"...)
+	b = hex.AppendEncode(b, sc.TraceID[:])
+	b = append(b, '-')
+	b = hex.AppendEncode(b, sc.SpanID[:])
+	b = append(b, '-')
+	b = hex.AppendEncode(b, []byte{sc.Flags})
+	return string(b)
+}
+
+// ParseTraceparent parses a traceparent header value. Versions after 00
+// are read as far as version 00 goes, as the specification asks; version ff
+// and all-zero IDs are invalid.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return sc, false
+	}
+	version, ok := decodeHex(value[0:2])
+	if !ok || version[0] == 0xff {
+		return sc, false
+	}
+	if version[0] == 0 && len(value) != 55 || len(value) > 55 && value[55] != '-' {
+		return sc, false
+	}
+
+	traceID, ok1 := decodeHex(value[3:35])
+	spanID, ok2 := decodeHex(value[36:52])
+	flags, ok3 := decodeHex(value[53:55])
+	if !ok1 || !ok2 || !ok3 {
+		return sc, false
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0]
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// decodeHex decodes lowercase hex, the only case traceparent allows.
+func decodeHex(s string) ([]byte, bool) {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return nil, false
+		}
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// Extract returns the span context a request carries in its traceparent
+// and tracestate headers, and false if it has none or it is invalid. A
+// repeated traceparent is invalid, having been joined into one value.
+func Extract(h headers.Headers) (SpanContext, bool) {
+	sc, ok := ParseTraceparent(h.Get("traceparent"))
+	if !ok {
+		return SpanContext{}, false
+	}
+	sc.TraceState = validTraceState(h.Get("tracestate"))
+	return sc, true
+}
+
+// validTraceState returns state if it can be passed on as it is, and ""
+// otherwise: we don't add to it, so we don't need to read it.
+func validTraceState(state string) string {
+	if len(state) > maxTraceStateLength {
+		return ""
+	}
+	for i := 0; i < len(state); i++ {
+		if c := state[i]; c < 0x20 || c > 0x7e {
+			return ""
+		}
+	}
+	return state
+}
+
+// Inject sets the traceparent and tracestate headers of an outbound request
+// to continue the trace of the span in ctx. Without one, h is left alone.
+func Inject(ctx context.Context, h http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	h.Set("Traceparent", span.SpanContext.Traceparent())
+	if span.SpanContext.TraceState != "" {
+		h.Set("Tracestate", span.SpanContext.TraceState)
+	} else {
+		h.Del("Tracestate")
+	}
+}
+
+type SpanKind int
+
+// Span kinds, numbered as in OTLP.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+type StatusCode int
+
+// Span statuses, numbered as in OTLP. Spans are left Unset unless they
+// failed: server spans for 5xx responses.
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Attribute is a key and a string, int64 or bool value describing a span.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Span is one timed operation of a trace, such as answering a request.
+type Span struct {
+	Name string
+	SpanContext
+	// ParentSpanID is the span this one is part of, invalid for the root
+	// span of a trace.
+	ParentSpanID SpanID
+	Kind         SpanKind
+	Start        time.Time
+	End          time.Time
+	Attributes   []Attribute
+	Status       StatusCode
+}
+
+// SetAttribute adds an attribute to the span, replacing one with the same
+// key.
+func (s *Span) SetAttribute(key string, value any) {
+	for i := range s.Attributes {
+		if s.Attributes[i].Key == key {
+			s.Attributes[i].Value = value
+			return
+		}
+	}
+	s.Attributes = append(s.Attributes, Attribute{key, value})
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// newTraceID and newSpanID return random, valid IDs.
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
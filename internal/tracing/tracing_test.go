@@ -0,0 +1,225 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"chillhttp/internal/headers"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+	"chillhttp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := ParseTraceparent(parent)
+	require.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, parent, sc.Traceparent())
+
+	tests := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"future version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"future version without extra", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"version 00 with extra", parent + "-extra", false},
+		{"future version with bad extra", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01x", false},
+		{"version ff", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"zero trace ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"zero span ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"short", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1", false},
+		{"repeated", parent + ", " + parent, false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := ParseTraceparent(tt.value)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
+
+func TestExtractAndInject(t *testing.T) {
+	h := headers.Headers{"traceparent": parent, "tracestate": "vendor=abc,other=1"}
+	sc, ok := Extract(h)
+	require.True(t, ok)
+	assert.Equal(t, "vendor=abc,other=1", sc.TraceState)
+
+	_, ok = Extract(headers.Headers{"tracestate": "vendor=abc"})
+	assert.False(t, ok)
+
+	out := http.Header{"Tracestate": {"stale=1"}}
+	Inject(context.Background(), out)
+	assert.Equal(t, "stale=1", out.Get("Tracestate"), "left alone without a span")
+
+	span := &Span{SpanContext: SpanContext{TraceID: sc.TraceID, SpanID: SpanID{1}, Flags: FlagSampled}}
+	Inject(ContextWithSpan(context.Background(), span), out)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-0100000000000000-01", out.Get("Traceparent"))
+	assert.Empty(t, out.Get("Tracestate"))
+}
+
+// recorder is an Exporter that keeps what it is given.
+type recorder struct {
+	mu       sync.Mutex
+	batches  [][]*Span
+	shutdown bool
+}
+
+func (r *recorder) ExportSpans(_ context.Context, spans []*Span) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, spans)
+	return nil
+}
+
+func (r *recorder) Shutdown(context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shutdown = true
+	return nil
+}
+
+func (r *recorder) spans() []*Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var all []*Span
+	for _, b := range r.batches {
+		all = append(all, b...)
+	}
+	return all
+}
+
+// get sends a request with the given extra header lines to a server with
+// handler behind the tracer's middleware.
+func get(t *testing.T, tracer *Tracer, handler server.Handler, extra string) *http.Response {
+	t.Helper()
+	s, err := server.Serve(0, tracer.Middleware()(handler))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET /users/7?full=1 HTTP/1.1\r\nHost: localhost\r\nUser-Agent: test/1.0\r\n%s\r\n", extra)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+	return resp
+}
+
+func TestMiddlewareContinuesTrace(t *testing.T) {
+	rec := &recorder{}
+	tracer := NewTracer(rec, Options{Interval: time.Hour})
+	var inHandler *Span
+	get(t, tracer, func(w *response.Writer, req *request.Request) {
+		server.SetRoute(req, "/users/{id}")
+		inHandler = SpanFromContext(req.Context())
+		body := []byte("oops")
+		w.WriteStatusLine(response.InternalServerError)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, "traceparent: "+parent+"\r\ntracestate: vendor=abc\r\n")
+	require.NoError(t, tracer.Shutdown(context.Background()))
+	assert.True(t, rec.shutdown)
+
+	spans := rec.spans()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Same(t, inHandler, span)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", span.ParentSpanID.String())
+	assert.NotEqual(t, span.ParentSpanID, span.SpanID)
+	assert.Equal(t, "vendor=abc", span.TraceState)
+	assert.Equal(t, "GET /users/{id}", span.Name)
+	assert.Equal(t, SpanKindServer, span.Kind)
+	assert.Equal(t, StatusError, span.Status)
+	assert.False(t, span.End.Before(span.Start))
+
+	attrs := make(map[string]any)
+	for _, a := range span.Attributes {
+		attrs[a.Key] = a.Value
+	}
+	assert.Equal(t, "GET", attrs["http.request.method"])
+	assert.Equal(t, "/users/{id}", attrs["http.route"])
+	assert.Equal(t, "/users/7", attrs["url.path"])
+	assert.Equal(t, "full=1", attrs["url.query"])
+	assert.Equal(t, int64(500), attrs["http.response.status_code"])
+	assert.Equal(t, int64(4), attrs["http.response.body.size"])
+	assert.Equal(t, "test/1.0", attrs["user_agent.original"])
+}
+
+func TestMiddlewareStartsTrace(t *testing.T) {
+	rec := &recorder{}
+	tracer := NewTracer(rec, Options{Interval: time.Hour})
+	get(t, tracer, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}, "traceparent: 00-bad\r\n")
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	spans := rec.spans()
+	require.Len(t, spans, 1)
+	assert.True(t, spans[0].TraceID.IsValid())
+	assert.False(t, spans[0].ParentSpanID.IsValid())
+	assert.True(t, spans[0].Sampled())
+	assert.Equal(t, "GET", spans[0].Name)
+	assert.Equal(t, StatusUnset, spans[0].Status)
+}
+
+func TestUnsampledTraceNotExported(t *testing.T) {
+	rec := &recorder{}
+	tracer := NewTracer(rec, Options{Interval: time.Hour})
+	var traceparent string
+	get(t, tracer, func(w *response.Writer, req *request.Request) {
+		out := http.Header{}
+		Inject(req.Context(), out)
+		traceparent = out.Get("Traceparent")
+		w.WriteStatusLine(response.OK)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}, "traceparent: "+strings.TrimSuffix(parent, "01")+"00\r\n")
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	assert.Empty(t, rec.spans())
+	assert.True(t, strings.HasPrefix(traceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-"))
+	assert.True(t, strings.HasSuffix(traceparent, "-00"))
+}
+
+func TestTracerBatches(t *testing.T) {
+	rec := &recorder{}
+	tracer := NewTracer(rec, Options{BatchSize: 2, MaxQueueSize: 3, Interval: time.Hour})
+	for i := 0; i < 4; i++ {
+		tracer.end(&Span{SpanContext: SpanContext{Flags: FlagSampled}})
+	}
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	// A full batch is exported at once, in the background; whatever is
+	// still queued goes at shutdown. The queue holds 3 at most.
+	total := 0
+	for _, b := range rec.batches {
+		assert.LessOrEqual(t, len(b), 2)
+		total += len(b)
+	}
+	assert.GreaterOrEqual(t, total, 3)
+
+	tracer.end(&Span{SpanContext: SpanContext{Flags: FlagSampled}})
+	assert.Equal(t, total, len(rec.spans()), "spans after shutdown are dropped")
+}
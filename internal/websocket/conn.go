@@ -0,0 +1,475 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the type of a data message.
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// Opcodes (RFC 6455 section 5.2). Text and binary are the MessageTypes.
+const (
+	opContinuation = 0x0
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes (RFC 6455 section 7.4.1).
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// maxControlPayload is the largest payload of a control frame.
+const maxControlPayload = 125
+
+// closeTimeout bounds how long writing a close frame may take.
+const closeTimeout = 5 * time.Second
+
+var (
+	// ErrCloseSent is returned by writes after the close frame went out.
+	ErrCloseSent = errors.New("websocket: close sent")
+
+	errProtocol       = errors.New("websocket: protocol error")
+	errMessageTooBig  = errors.New("websocket: message too big")
+	errInvalidPayload = errors.New("websocket: invalid UTF-8 in text message")
+)
+
+// CloseError is returned by ReadMessage once the client closed the
+// connection.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed with code %d: %s", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection. One goroutine may read from it while
+// others write.
+type Conn struct {
+	conn           net.Conn
+	r              *bufio.Reader
+	subprotocol    string
+	compress       bool
+	maxMessageSize int64
+	fragmentSize   int
+
+	// readErr fails every read after the first failure.
+	readErr error
+	onPong  func(data []byte)
+
+	wmu        sync.Mutex
+	closeSent  bool
+	compressor compressor
+	// wbuf holds the header of the frame being written.
+	wbuf []byte
+}
+
+func newConn(conn net.Conn, buffered []byte, opts Options, subprotocol string, compress bool) *Conn {
+	maxMessageSize := opts.MaxMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = defaultMaxMessageSize
+	}
+	return &Conn{
+		conn:           conn,
+		r:              bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn)),
+		subprotocol:    subprotocol,
+		compress:       compress,
+		maxMessageSize: maxMessageSize,
+		fragmentSize:   opts.FragmentSize,
+	}
+}
+
+// Subprotocol returns the subprotocol selected during the handshake, if any.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the client's address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline bounds how long ReadMessage waits for the client, e.g. to
+// drop clients that stop answering pings.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline bounds how long writes may block.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetPongHandler sets a function called from ReadMessage with the payload of
+// each pong the client sends.
+func (c *Conn) SetPongHandler(fn func(data []byte)) {
+	c.onPong = fn
+}
+
+// frameHeader is a decoded frame header (RFC 6455 section 5.2).
+type frameHeader struct {
+	fin    bool
+	rsv1   bool
+	opcode byte
+	length int64
+	mask   [4]byte
+}
+
+func (h frameHeader) control() bool {
+	return h.opcode&0x8 != 0
+}
+
+// ReadMessage returns the next data message, answering pings and handling
+// pongs on the way. Once the client closes the connection it returns a
+// *CloseError; the close is echoed, and the caller should then Close.
+// Messages that break the protocol fail the connection with a close frame
+// and an error.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+
+	var (
+		msgType    MessageType
+		compressed bool
+		message    []byte
+	)
+	for {
+		h, err := c.readFrameHeader()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		if h.control() {
+			payload, err := c.readPayload(h)
+			if err != nil {
+				return 0, nil, c.fail(err)
+			}
+			if err := c.handleControl(h.opcode, payload); err != nil {
+				return 0, nil, c.fail(err)
+			}
+			continue
+		}
+
+		switch {
+		case h.opcode == opContinuation && msgType == 0,
+			h.opcode != opContinuation && msgType != 0,
+			h.opcode == opContinuation && h.rsv1:
+			// Continuations only continue a message, and only its first
+			// frame says whether it is compressed.
+			return 0, nil, c.fail(errProtocol)
+		case h.opcode != opContinuation:
+			msgType = MessageType(h.opcode)
+			compressed = h.rsv1
+		}
+
+		if int64(len(message))+h.length > c.maxMessageSize {
+			return 0, nil, c.fail(errMessageTooBig)
+		}
+		payload, err := c.readPayload(h)
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		message = append(message, payload...)
+		if !h.fin {
+			continue
+		}
+
+		if compressed {
+			if message, err = decompress(message, c.maxMessageSize); err != nil {
+				if !errors.Is(err, errMessageTooBig) {
+					err = fmt.Errorf("%w: %v", errInvalidPayload, err)
+				}
+				return 0, nil, c.fail(err)
+			}
+		}
+		if msgType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(errInvalidPayload)
+		}
+		return msgType, message, nil
+	}
+}
+
+// readFrameHeader reads and checks the header of the next frame.
+func (c *Conn) readFrameHeader() (frameHeader, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(c.r, b[:]); err != nil {
+		return frameHeader{}, err
+	}
+
+	h := frameHeader{
+		fin:    b[0]&0x80 != 0,
+		rsv1:   b[0]&0x40 != 0,
+		opcode: b[0] & 0x0f,
+		length: int64(b[1] & 0x7f),
+	}
+	switch {
+	case b[0]&0x30 != 0, h.rsv1 && !c.compress:
+		// No extension we negotiated uses the other bits.
+		return h, errProtocol
+	case h.opcode > 0x2 && h.opcode < opClose, h.opcode > opPong:
+		return h, errProtocol
+	case b[1]&0x80 == 0:
+		// Clients must mask every frame (section 5.1).
+		return h, errProtocol
+	}
+
+	switch h.length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(ext[:]))
+		if h.length < 126 {
+			return h, errProtocol
+		}
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return h, err
+		}
+		n := binary.BigEndian.Uint64(ext[:])
+		if n>>63 != 0 || n <= 0xffff {
+			return h, errProtocol
+		}
+		if n > uint64(c.maxMessageSize) {
+			return h, errMessageTooBig
+		}
+		h.length = int64(n)
+	}
+
+	if h.control() && (!h.fin || h.rsv1 || h.length > maxControlPayload) {
+		return h, errProtocol
+	}
+	if _, err := io.ReadFull(c.r, h.mask[:]); err != nil {
+		return h, err
+	}
+	return h, nil
+}
+
+// readPayload reads and unmasks the payload of a frame.
+func (c *Conn) readPayload(h frameHeader) ([]byte, error) {
+	payload := make([]byte, h.length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		payload[i] ^= h.mask[i%4]
+	}
+	return payload, nil
+}
+
+// handleControl answers a control frame.
+func (c *Conn) handleControl(opcode byte, payload []byte) error {
+	switch opcode {
+	case opPing:
+		c.wmu.Lock()
+		defer c.wmu.Unlock()
+		if c.closeSent {
+			return nil
+		}
+		return c.writeFrame(true, false, opPong, payload)
+	case opPong:
+		if c.onPong != nil {
+			c.onPong(payload)
+		}
+		return nil
+	}
+
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return errProtocol
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return errProtocol
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return errInvalidPayload
+		}
+	}
+
+	// Echo the close (section 5.5.1); without a code, with none.
+	code := closeErr.Code
+	if code == CloseNoStatus {
+		code = 0
+	}
+	c.writeClose(code, "")
+	c.readErr = closeErr
+	return closeErr
+}
+
+// validCloseCode reports whether code may be sent in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1014:
+		return false
+	}
+	return code != 1004 && code != CloseNoStatus && code != 1006
+}
+
+// fail ends reading after err. Protocol violations fail the connection with
+// the matching close code; a CloseError was already answered.
+func (c *Conn) fail(err error) error {
+	var closeErr *CloseError
+	switch {
+	case errors.As(err, &closeErr):
+	case errors.Is(err, errProtocol):
+		c.writeClose(CloseProtocolError, "")
+	case errors.Is(err, errMessageTooBig):
+		c.writeClose(CloseMessageTooBig, "")
+	case errors.Is(err, errInvalidPayload):
+		c.writeClose(CloseInvalidPayload, "")
+	}
+	c.readErr = err
+	return err
+}
+
+// WriteMessage sends a data message, compressed if permessage-deflate was
+// negotiated and split into frames of Options.FragmentSize if set.
+func (c *Conn) WriteMessage(msgType MessageType, p []byte) error {
+	if msgType != TextMessage && msgType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", msgType)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+
+	compressed := c.compress && len(p) > 0
+	if compressed {
+		var err error
+		if p, err = c.compressor.compress(p); err != nil {
+			return err
+		}
+	}
+
+	opcode := byte(msgType)
+	for {
+		n := len(p)
+		if c.fragmentSize > 0 && n > c.fragmentSize {
+			n = c.fragmentSize
+		}
+		if err := c.writeFrame(n == len(p), compressed, opcode, p[:n]); err != nil {
+			return err
+		}
+		p = p[n:]
+		if len(p) == 0 {
+			return nil
+		}
+		opcode = opContinuation
+		compressed = false
+	}
+}
+
+// Ping sends a ping; the client's pong goes to the pong handler.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: ping payload too large")
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	return c.writeFrame(true, false, opPing, data)
+}
+
+// WriteClose starts the closing handshake with code and reason. The client
+// answers with its own close, which ReadMessage returns as a *CloseError.
+func (c *Conn) WriteClose(code int, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		return errors.New("websocket: close reason too long")
+	}
+	return c.writeClose(code, reason)
+}
+
+// Close sends a normal close frame unless one was sent, then closes the
+// connection.
+func (c *Conn) Close() error {
+	c.writeClose(CloseNormal, "")
+	return c.conn.Close()
+}
+
+// writeClose sends a close frame once. A code of 0 sends no status.
+func (c *Conn) writeClose(code int, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+
+	var payload []byte
+	if code != 0 {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	return c.writeFrame(true, false, opClose, payload)
+}
+
+// writeFrame sends one unmasked frame; servers never mask. Callers hold
+// wmu.
+func (c *Conn) writeFrame(fin, rsv1 bool, opcode byte, payload []byte) error {
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+
+	buf := append(c.wbuf[:0], b0)
+	switch n := len(payload); {
+	case n < 126:
+		buf = append(buf, byte(n))
+	case n <= 0xffff:
+		buf = append(buf, 126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, 127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	c.wbuf = buf
+
+	// net.Buffers sends header and payload together without copying.
+	bufs := net.Buffers{buf, payload}
+	_, err := bufs.WriteTo(c.conn)
+	return err
+}
@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+
+	"chillhttp/internal/request"
+)
+
+// deflateResponse accepts permessage-deflate without context takeover in
+// either direction, so each message is compressed on its own and neither
+// side keeps a 32 KiB window per connection between messages.
+const deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// deflateTail is appended to a received message to restore the empty
+// stored block its sender stripped, followed by a final empty block so the
+// decompressor sees the end of the stream (RFC 7692 section 7.2.2).
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// offersDeflate reports whether the client offered permessage-deflate with
+// parameters we can honor. compress/flate always uses a 32 KiB window, so
+// an offer limiting ours with server_max_window_bits is declined.
+func offersDeflate(req *request.Request) bool {
+	for _, offer := range strings.Split(req.Headers.Get("Sec-WebSocket-Extensions"), ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		ok := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			switch strings.TrimSpace(name) {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				ok = ok && strings.Trim(strings.TrimSpace(value), `"`) == "15"
+			default:
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// compressor compresses outgoing messages.
+type compressor struct {
+	buf bytes.Buffer
+	w   *flate.Writer
+}
+
+// compress returns p deflated, without the empty stored block that ends a
+// flush (RFC 7692 section 7.2.1). The result is only valid until the next
+// call.
+func (c *compressor) compress(p []byte) ([]byte, error) {
+	c.buf.Reset()
+	if c.w == nil {
+		var err error
+		if c.w, err = flate.NewWriter(&c.buf, flate.DefaultCompression); err != nil {
+			return nil, err
+		}
+	} else {
+		c.w.Reset(&c.buf)
+	}
+	if _, err := c.w.Write(p); err != nil {
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(c.buf.Bytes(), deflateTail[:4]), nil
+}
+
+// decompress inflates a received message, failing with errMessageTooBig
+// once it grows past limit.
+func decompress(p []byte, limit int64) ([]byte, error) {
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateTail)))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, errMessageTooBig
+	}
+	return out, nil
+}
@@ -0,0 +1,173 @@
+// Package websocket upgrades HTTP/1.1 requests to WebSocket connections
+// (RFC 6455), optionally compressed with permessage-deflate (RFC 7692).
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+
+	"chillhttp/internal/headers"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+)
+
+// acceptGUID is appended to the client's key to compute
+// Sec-WebSocket-Accept (RFC 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// defaultMaxMessageSize is the largest message a connection accepts when
+// Options doesn't set a limit.
+const defaultMaxMessageSize = 1 << 20
+
+var (
+	ErrBadHandshake       = errors.New("websocket: bad handshake")
+	ErrUnsupportedVersion = errors.New("websocket: unsupported version")
+	ErrForbiddenOrigin    = errors.New("websocket: origin not allowed")
+)
+
+type Options struct {
+	// Subprotocols lists the subprotocols the server speaks, in order of
+	// preference. The first one the client also offers is selected.
+	Subprotocols []string
+	// MaxMessageSize limits the size of a received message, after
+	// decompression. Larger messages fail the connection with 1009
+	// (message too big). 0 means 1 MiB.
+	MaxMessageSize int64
+	// FragmentSize, if set, splits sent messages into frames of at most
+	// that many bytes.
+	FragmentSize int
+	// EnableCompression accepts a client's offer of permessage-deflate.
+	EnableCompression bool
+	// CheckOrigin decides whether to accept a request from a browser page.
+	// When nil, requests with an Origin header are only accepted from the
+	// host they are addressed to, which stops other sites from opening
+	// connections with the user's cookies.
+	CheckOrigin func(req *request.Request) bool
+}
+
+// Upgrade completes the opening handshake of req, writing the 101 response
+// through w, and takes over the connection. A request that isn't a valid
+// handshake is answered with an error status and an error is returned.
+func Upgrade(w *response.Writer, req *request.Request, opts Options) (*Conn, error) {
+	key, err := checkHandshake(req, opts)
+	if err != nil {
+		rejectHandshake(w, err)
+		return nil, err
+	}
+
+	h := headers.NewHeaders()
+	h["Upgrade"] = "websocket"
+	h["Connection"] = "Upgrade"
+	h["Sec-WebSocket-Accept"] = AcceptKey(key)
+	subprotocol := selectSubprotocol(req, opts.Subprotocols)
+	if subprotocol != "" {
+		h["Sec-WebSocket-Protocol"] = subprotocol
+	}
+	compress := opts.EnableCompression && offersDeflate(req)
+	if compress {
+		h["Sec-WebSocket-Extensions"] = deflateResponse
+	}
+
+	if err := w.WriteStatusLine(response.SwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn, buffered, opts, subprotocol, compress), nil
+}
+
+// IsUpgrade reports whether req asks to switch to WebSocket, so a handler
+// can serve plain HTTP on the same route.
+func IsUpgrade(req *request.Request) bool {
+	return req.Headers.HasToken("Upgrade", "websocket")
+}
+
+// AcceptKey returns the Sec-WebSocket-Accept value answering key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// checkHandshake validates the opening handshake (RFC 6455 section 4.2.1)
+// and returns the client's key.
+func checkHandshake(req *request.Request, opts Options) (string, error) {
+	switch {
+	case req.RequestLine.Method != "GET",
+		req.RequestLine.HttpVersion != "1.1",
+		!IsUpgrade(req),
+		!req.Headers.HasToken("Connection", "Upgrade"):
+		return "", ErrBadHandshake
+	case req.Headers.Get("Sec-WebSocket-Version") != "13":
+		return "", ErrUnsupportedVersion
+	}
+
+	key := req.Headers.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		return "", ErrBadHandshake
+	}
+
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return "", ErrForbiddenOrigin
+	}
+	return key, nil
+}
+
+// rejectHandshake answers a failed handshake.
+func rejectHandshake(w *response.Writer, err error) {
+	statusCode := response.BadRequest
+	body := []byte(err.Error())
+	h := response.GetDefaultHeaders(len(body))
+	switch {
+	case errors.Is(err, ErrUnsupportedVersion):
+		// Tell the client which version to retry with (section 4.4).
+		statusCode = response.UpgradeRequired
+		h["Sec-WebSocket-Version"] = "13"
+	case errors.Is(err, ErrForbiddenOrigin):
+		statusCode = response.Forbidden
+	}
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+// sameOrigin accepts requests without an Origin header, which don't come
+// from browsers, and those whose origin is the host and port they are
+// addressed to, as sent in the Host header. The scheme isn't compared, as a
+// server behind a TLS-terminating proxy sees https origins over plain HTTP.
+func sameOrigin(req *request.Request) bool {
+	origin := req.Headers.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Headers.Get("Host"))
+}
+
+// selectSubprotocol picks the first of ours the client offered. Unlike most
+// tokens, subprotocol names are compared case-sensitively.
+func selectSubprotocol(req *request.Request, ours []string) string {
+	offered := strings.Split(req.Headers.Get("Sec-WebSocket-Protocol"), ",")
+	for _, p := range ours {
+		for _, o := range offered {
+			if strings.TrimSpace(o) == p {
+				return p
+			}
+		}
+	}
+	return ""
+}
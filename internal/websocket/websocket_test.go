@@ -0,0 +1,360 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"chillhttp/internal/headers"
+	"chillhttp/internal/request"
+	"chillhttp/internal/response"
+	"chillhttp/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// echoServer upgrades every request and echoes messages back until the
+// client closes.
+func echoServer(t *testing.T, opts Options) string {
+	t.Helper()
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		conn, err := Upgrade(w, req, opts)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			msgType, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(msgType, msg); err != nil {
+				return
+			}
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s.Listener.Addr().String()
+}
+
+// testClient is the client side of a WebSocket connection.
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, addr, extraHeaders string) (*testClient, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "GET /chat HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n%s\r\n", testKey, extraHeaders)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	return &testClient{t: t, conn: conn, r: r}, resp
+}
+
+// send writes a masked frame, as clients must.
+func (c *testClient) send(fin, rsv1 bool, opcode byte, payload []byte) {
+	c.t.Helper()
+	c.sendFrame(fin, rsv1, opcode, payload, true)
+}
+
+func (c *testClient) sendFrame(fin, rsv1 bool, opcode byte, payload []byte, masked bool) {
+	c.t.Helper()
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	buf := []byte{b0}
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	if masked {
+		mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+		buf = append(buf, mask[:]...)
+		for i, b := range payload {
+			buf = append(buf, b^mask[i%4])
+		}
+	} else {
+		buf = append(buf, payload...)
+	}
+	_, err := c.conn.Write(buf)
+	require.NoError(c.t, err)
+}
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  byte
+	payload []byte
+}
+
+func (c *testClient) read() frame {
+	c.t.Helper()
+	var b [2]byte
+	_, err := io.ReadFull(c.r, b[:])
+	require.NoError(c.t, err)
+	require.Zero(c.t, b[1]&0x80, "server frames are not masked")
+
+	n := int(b[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.r, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.r, ext[:])
+		n = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	require.NoError(c.t, err)
+	payload := make([]byte, n)
+	_, err = io.ReadFull(c.r, payload)
+	require.NoError(c.t, err)
+	return frame{fin: b[0]&0x80 != 0, rsv1: b[0]&0x40 != 0, opcode: b[0] & 0x0f, payload: payload}
+}
+
+// closeCode reads frames until a close and returns its code.
+func (c *testClient) closeCode() int {
+	c.t.Helper()
+	for {
+		f := c.read()
+		if f.opcode == opClose {
+			require.GreaterOrEqual(c.t, len(f.payload), 2)
+			return int(binary.BigEndian.Uint16(f.payload))
+		}
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example from RFC 6455 section 1.3.
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey(testKey))
+}
+
+func TestHandshake(t *testing.T) {
+	addr := echoServer(t, Options{Subprotocols: []string{"v2.chat", "chat"}})
+	_, resp := dial(t, addr, "Sec-WebSocket-Protocol: chat, v2.chat\r\n")
+
+	assert.Equal(t, 101, resp.StatusCode)
+	assert.Equal(t, "websocket", resp.Header.Get("Upgrade"))
+	assert.Equal(t, "Upgrade", resp.Header.Get("Connection"))
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	assert.Equal(t, "v2.chat", resp.Header.Get("Sec-WebSocket-Protocol"))
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+}
+
+func TestHandshakeErrors(t *testing.T) {
+	addr := echoServer(t, Options{})
+	tests := []struct {
+		name    string
+		request string
+		status  int
+	}{
+		{"missing key", "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n\r\n", 400},
+		{"not GET", "POST / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 13\r\nContent-Length: 0\r\n\r\n", 400},
+		{"old version", "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 8\r\n\r\n", 426},
+		{"cross origin", "GET / HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 13\r\nOrigin: https://evil.test\r\n\r\n", 403},
+		{"other port", "GET / HTTP/1.1\r\nHost: localhost:8080\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 13\r\nOrigin: http://localhost:1234\r\n\r\n", 403},
+		{"other IPv6 port", "GET / HTTP/1.1\r\nHost: [::1]:8080\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: " + testKey + "\r\nSec-WebSocket-Version: 13\r\nOrigin: http://[::1]\r\n\r\n", 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			require.NoError(t, err)
+			defer conn.Close()
+			fmt.Fprint(conn, tt.request)
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			if tt.status == 426 {
+				assert.Equal(t, "13", resp.Header.Get("Sec-WebSocket-Version"))
+			}
+		})
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		host, origin string
+		want         bool
+	}{
+		{"localhost", "", true},
+		{"localhost", "http://localhost", true},
+		{"LocalHost:8080", "http://localhost:8080", true},
+		{"[::1]:8080", "http://[::1]:8080", true},
+		{"[::1]", "https://[::1]", true},
+		{"localhost:8080", "http://localhost:1234", false},
+		{"localhost:8080", "http://localhost", false},
+		{"[::1]:8080", "http://[::1]", false},
+		{"localhost", "http://evil.test", false},
+		{"localhost", "null", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host+" "+tt.origin, func(t *testing.T) {
+			req := &request.Request{Headers: headers.Headers{"host": tt.host}}
+			if tt.origin != "" {
+				req.Headers["origin"] = tt.origin
+			}
+			assert.Equal(t, tt.want, sameOrigin(req))
+		})
+	}
+}
+
+func TestEcho(t *testing.T) {
+	c, _ := dial(t, echoServer(t, Options{}), "Origin: http://localhost\r\n")
+
+	c.send(true, false, byte(TextMessage), []byte("hello"))
+	f := c.read()
+	assert.Equal(t, frame{fin: true, opcode: byte(TextMessage), payload: []byte("hello")}, f)
+
+	// Large enough for the 64-bit length encoding.
+	big := bytes.Repeat([]byte{0xab}, 70000)
+	c.send(true, false, byte(BinaryMessage), big)
+	f = c.read()
+	assert.Equal(t, byte(BinaryMessage), f.opcode)
+	assert.Equal(t, big, f.payload)
+}
+
+func TestFragmentsAndControlFrames(t *testing.T) {
+	c, _ := dial(t, echoServer(t, Options{}), "")
+
+	c.send(false, false, byte(TextMessage), []byte("hel"))
+	// Control frames may come between the fragments of a message.
+	c.send(true, false, opPing, []byte("are you there"))
+	c.send(true, false, opContinuation, []byte("lo"))
+
+	f := c.read()
+	assert.Equal(t, frame{fin: true, opcode: opPong, payload: []byte("are you there")}, f)
+	f = c.read()
+	assert.Equal(t, frame{fin: true, opcode: byte(TextMessage), payload: []byte("hello")}, f)
+}
+
+func TestServerFragments(t *testing.T) {
+	c, _ := dial(t, echoServer(t, Options{FragmentSize: 4}), "")
+	c.send(true, false, byte(TextMessage), []byte("hello world"))
+
+	var frames []frame
+	for {
+		f := c.read()
+		frames = append(frames, f)
+		if f.fin {
+			break
+		}
+	}
+	require.Len(t, frames, 3)
+	assert.Equal(t, byte(TextMessage), frames[0].opcode)
+	assert.Equal(t, byte(opContinuation), frames[1].opcode)
+	assert.Equal(t, "hello world", string(frames[0].payload)+string(frames[1].payload)+string(frames[2].payload))
+}
+
+func TestCloseHandshake(t *testing.T) {
+	c, _ := dial(t, echoServer(t, Options{}), "")
+	c.send(true, false, opClose, append(binary.BigEndian.AppendUint16(nil, CloseGoingAway), "bye"...))
+
+	f := c.read()
+	require.Equal(t, byte(opClose), f.opcode)
+	assert.Equal(t, CloseGoingAway, int(binary.BigEndian.Uint16(f.payload)))
+	// Then the server closes the connection.
+	_, err := c.r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(c *testClient)
+		code int
+	}{
+		{"unmasked frame", func(c *testClient) {
+			c.sendFrame(true, false, byte(TextMessage), []byte("hi"), false)
+		}, CloseProtocolError},
+		{"unknown opcode", func(c *testClient) {
+			c.send(true, false, 0x3, nil)
+		}, CloseProtocolError},
+		{"fragmented ping", func(c *testClient) {
+			c.send(false, false, opPing, nil)
+		}, CloseProtocolError},
+		{"continuation without message", func(c *testClient) {
+			c.send(true, false, opContinuation, []byte("x"))
+		}, CloseProtocolError},
+		{"rsv1 without compression", func(c *testClient) {
+			c.send(true, true, byte(TextMessage), []byte("x"))
+		}, CloseProtocolError},
+		{"invalid UTF-8", func(c *testClient) {
+			c.send(true, false, byte(TextMessage), []byte{0xff, 0xfe})
+		}, CloseInvalidPayload},
+		{"too big", func(c *testClient) {
+			c.send(false, false, byte(BinaryMessage), make([]byte, 60))
+			c.send(true, false, opContinuation, make([]byte, 60))
+		}, CloseMessageTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := dial(t, echoServer(t, Options{MaxMessageSize: 100}), "")
+			tt.send(c)
+			assert.Equal(t, tt.code, c.closeCode())
+		})
+	}
+}
+
+func TestPermessageDeflate(t *testing.T) {
+	c, resp := dial(t, echoServer(t, Options{EnableCompression: true}),
+		"Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+	require.Equal(t, 101, resp.StatusCode)
+	assert.Equal(t, deflateResponse, resp.Header.Get("Sec-WebSocket-Extensions"))
+
+	msg := strings.Repeat("compress me please ", 50)
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestCompression)
+	require.NoError(t, err)
+	fw.Write([]byte(msg))
+	fw.Flush()
+	compressed := bytes.TrimSuffix(buf.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+	c.send(true, true, byte(TextMessage), compressed)
+
+	f := c.read()
+	assert.True(t, f.rsv1)
+	assert.Less(t, len(f.payload), len(msg))
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(f.payload), bytes.NewReader(deflateTail)))
+	got, err := io.ReadAll(fr)
+	require.NoError(t, err)
+	assert.Equal(t, msg, string(got))
+}
+
+func TestDeflateDeclined(t *testing.T) {
+	addr := echoServer(t, Options{EnableCompression: true})
+	_, resp := dial(t, addr, "Sec-WebSocket-Extensions: permessage-deflate; server_max_window_bits=10\r\n")
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+}